@@ -3,23 +3,40 @@ package main
 import (
 	"encoding/json"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
 	"time"
 
 	"claude-nvidia-proxy/internal/config"
+	"claude-nvidia-proxy/internal/logging"
 	"claude-nvidia-proxy/internal/server"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "replay":
+			os.Exit(runReplay(os.Args[2:], os.Stdout, os.Stderr))
+		case "mock-upstream":
+			os.Exit(runMockUpstream(os.Args[2:], os.Stderr))
+		}
+	}
+
 	cfg, err := config.LoadConfig()
 	if err != nil {
 		log.Fatalf("config error: %v", err)
 	}
+	logging.Setup(cfg, os.Stderr)
+
+	proxy, err := server.New(cfg)
+	if err != nil {
+		fatal("server error", err)
+	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("POST /v1/messages", func(w http.ResponseWriter, r *http.Request) {
-		server.HandleMessages(w, r, cfg)
-	})
+	mux.HandleFunc("POST /v1/messages", proxy.HandleMessages)
+	mux.Handle("GET /metrics", proxy.MetricsHandler())
 	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(map[string]any{
@@ -28,6 +45,27 @@ func main() {
 		})
 	})
 
+	if admin := proxy.AdminHandler(); admin != nil {
+		if cfg.AdminAddr == "" {
+			mux.Handle("/admin/", admin)
+			slog.Warn("admin api served on the main listener; set ADMIN_ADDR to move it", "addr", cfg.Addr)
+		} else {
+			adminSrv := &http.Server{
+				Addr:              cfg.AdminAddr,
+				Handler:           admin,
+				ReadHeaderTimeout: 10 * time.Second,
+				ReadTimeout:       60 * time.Second,
+				WriteTimeout:      60 * time.Second,
+				IdleTimeout:       60 * time.Second,
+				ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelError),
+			}
+			go func() {
+				fatal("admin server error", adminSrv.ListenAndServe())
+			}()
+			slog.Info("admin api listening", "addr", cfg.AdminAddr)
+		}
+	}
+
 	srv := &http.Server{
 		Addr:              cfg.Addr,
 		Handler:           mux,
@@ -35,14 +73,24 @@ func main() {
 		ReadTimeout:       60 * time.Second,
 		WriteTimeout:      0, // allow streaming
 		IdleTimeout:       60 * time.Second,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelError),
 	}
 
-	log.Printf("listening on %s", cfg.Addr)
-	log.Printf("upstream: %s", cfg.UpstreamURL)
-	if cfg.ServerAPIKey != "" {
-		log.Printf("inbound auth: enabled")
+	slog.Info("listening", "addr", cfg.Addr, "upstream", cfg.UpstreamURL)
+	if cfg.ResponseCache != "off" {
+		slog.Info("response cache enabled", "mode", cfg.ResponseCache, "ttl", cfg.ResponseCacheTTL)
+	}
+	if cfg.KeysFile != "" {
+		slog.Info("inbound auth: key registry", "file", cfg.KeysFile)
+	} else if cfg.ServerAPIKey != "" {
+		slog.Info("inbound auth: enabled")
 	} else {
-		log.Printf("inbound auth: disabled (SERVER_API_KEY not set)")
+		slog.Warn("inbound auth: disabled (SERVER_API_KEY not set)")
 	}
-	log.Fatal(srv.ListenAndServe())
+	fatal("server error", srv.ListenAndServe())
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"claude-nvidia-proxy/internal/mockupstream"
+)
+
+const mockUpstreamUsage = `usage: proxy mock-upstream [-addr host:port] [-fixtures path]
+
+Serves a scripted OpenAI-compatible chat completions API for testing the
+proxy offline. Point UPSTREAM_URL at it. Fixtures are a JSON file or a
+directory of JSON files; without them every request gets a short text reply.
+`
+
+// runMockUpstream implements the mock-upstream subcommand and returns the
+// exit code.
+func runMockUpstream(args []string, stderr io.Writer) int {
+	fs := flag.NewFlagSet("mock-upstream", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, mockUpstreamUsage)
+		fs.PrintDefaults()
+	}
+	addr := fs.String("addr", "127.0.0.1:8081", "listen address")
+	fixturesPath := fs.String("fixtures", "", "fixture file or directory")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	var fixtures []mockupstream.Fixture
+	if *fixturesPath != "" {
+		var err error
+		if fixtures, err = mockupstream.Load(*fixturesPath); err != nil {
+			fmt.Fprintf(stderr, "mock-upstream: %v\n", err)
+			return 2
+		}
+	}
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           mockupstream.New(fixtures...),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	slog.Info("mock upstream listening", "addr", *addr, "url", "http://"+*addr+"/v1/chat/completions", "fixtures", len(fixtures))
+	slog.Error("mock upstream error", "err", srv.ListenAndServe())
+	return 1
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"claude-nvidia-proxy/internal/config"
+	"claude-nvidia-proxy/internal/logging"
+	"claude-nvidia-proxy/internal/replay"
+)
+
+const replayUsage = `usage: proxy replay [-live] [-max-diff-lines n] [-v] CAPTURE...
+
+Re-runs captured requests (files or directories written with CAPTURE_DIR)
+through the current converter and diffs the converted request and the
+Anthropic response against the capture. Without -live, the captured
+upstream response is served back instead of calling the upstream.
+Exits with status 1 when any exchange differs.
+`
+
+// runReplay implements the replay subcommand and returns the exit code.
+func runReplay(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, replayUsage)
+		fs.PrintDefaults()
+	}
+	live := fs.Bool("live", false, "send requests to the configured upstream instead of the captured response")
+	maxDiffLines := fs.Int("max-diff-lines", 40, "diff lines to print per exchange (0 = all)")
+	verbose := fs.Bool("v", false, "also list exchanges that match")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	exchanges, err := replay.Load(fs.Args())
+	if err != nil {
+		fmt.Fprintf(stderr, "replay: %v\n", err)
+		return 2
+	}
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		fmt.Fprintf(stderr, "config error: %v\n", err)
+		return 2
+	}
+	// Keep the proxy's own logs out of the report unless asked for.
+	if os.Getenv("LOG_LEVEL") == "" {
+		cfg.LogLevel = slog.LevelWarn
+	}
+	logging.Setup(cfg, stderr)
+
+	r, err := replay.New(cfg, *live)
+	if err != nil {
+		fmt.Fprintf(stderr, "replay: %v\n", err)
+		return 2
+	}
+	defer r.Close()
+
+	var differ, skipped int
+	for _, ex := range exchanges {
+		res := r.Replay(ex)
+		label := fmt.Sprintf("%s (%s, stream=%v)", ex.RequestID, ex.Model, ex.Stream)
+		switch {
+		case res.Skipped != "":
+			skipped++
+			fmt.Fprintf(stdout, "SKIP %s: %s\n", label, res.Skipped)
+		case res.Differs():
+			differ++
+			fmt.Fprintf(stdout, "DIFF %s\n", label)
+			printDiff(stdout, "converted request", res.ConvertedDiff, *maxDiffLines)
+			printDiff(stdout, "response", res.ResponseDiff, *maxDiffLines)
+		case *verbose:
+			fmt.Fprintf(stdout, "OK   %s\n", label)
+		}
+	}
+	fmt.Fprintf(stdout, "%d replayed, %d differ, %d skipped\n", len(exchanges)-skipped, differ, skipped)
+	if differ > 0 {
+		return 1
+	}
+	return 0
+}
+
+func printDiff(w io.Writer, what string, lines []string, limit int) {
+	if len(lines) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "  %s:\n", what)
+	for i, line := range lines {
+		if limit > 0 && i == limit {
+			fmt.Fprintf(w, "    ... %d more lines\n", len(lines)-limit)
+			break
+		}
+		fmt.Fprintf(w, "    %s\n", line)
+	}
+}
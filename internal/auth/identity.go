@@ -0,0 +1,27 @@
+package auth
+
+import "context"
+
+// Identity is the authenticated caller of a request.
+type Identity struct {
+	// Name is the registry key name, "default" for SERVER_API_KEY or
+	// "anonymous" when inbound auth is off.
+	Name string
+	// Client is a hash of the presented secret; it scopes per-caller state
+	// such as prompt cache tracking and request dedup.
+	Client string
+	// Policy is the registry entry, or nil for callers outside the registry.
+	Policy *Key
+}
+
+type identityKey struct{}
+
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// FromContext returns the identity attached by WithIdentity.
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
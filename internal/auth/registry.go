@@ -0,0 +1,247 @@
+// Package auth resolves inbound API keys to identities with per-key policy.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"claude-nvidia-proxy/internal/ratelimit"
+)
+
+// Key is one entry of the key registry file. The secret is stored either in
+// plain text (Key) or as the hex SHA-256 of the secret (KeySHA256).
+type Key struct {
+	Name               string           `json:"name"`
+	Key                string           `json:"key,omitempty"`
+	KeySHA256          string           `json:"key_sha256,omitempty"`
+	AllowedModels      []string         `json:"allowed_models,omitempty"`
+	RateLimits         ratelimit.Limits `json:"rate_limits"`
+	DailyTokenBudget   int64            `json:"daily_token_budget,omitempty"`
+	MonthlyTokenBudget int64            `json:"monthly_token_budget,omitempty"`
+	Enabled            *bool            `json:"enabled,omitempty"`
+}
+
+// IsEnabled reports whether the key may be used; keys are enabled unless
+// "enabled": false is set.
+func (k Key) IsEnabled() bool {
+	return k.Enabled == nil || *k.Enabled
+}
+
+type registryFile struct {
+	Keys []Key `json:"keys"`
+}
+
+// reloadInterval is how often the registry file is checked for changes.
+const reloadInterval = 5 * time.Second
+
+// Registry holds the keys from a JSON file and reloads it when it changes,
+// so keys can be added or revoked without a restart.
+type Registry struct {
+	path string
+
+	mu        sync.RWMutex
+	keys      []Key
+	byHash    map[string]int
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+var (
+	ErrKeyNotFound   = errors.New("key not found")
+	ErrDuplicateName = errors.New("key name already exists")
+)
+
+// LoadRegistry reads the key registry at path. A missing file is an empty
+// registry; it is created when the first key is added.
+func LoadRegistry(path string) (*Registry, error) {
+	r := &Registry{path: path, byHash: map[string]int{}}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// HashKey returns the registry form of a secret.
+func HashKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func (r *Registry) reload() error {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", r.path, err)
+	}
+	b, err := os.ReadFile(r.path)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", r.path, err)
+	}
+	var f registryFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return fmt.Errorf("parse %s: %w", r.path, err)
+	}
+	byHash := make(map[string]int, len(f.Keys))
+	names := map[string]bool{}
+	for i, k := range f.Keys {
+		if strings.TrimSpace(k.Name) == "" {
+			return fmt.Errorf("parse %s: key %d has no name", r.path, i)
+		}
+		if names[k.Name] {
+			return fmt.Errorf("parse %s: duplicate key name %q", r.path, k.Name)
+		}
+		names[k.Name] = true
+		h := strings.ToLower(strings.TrimSpace(k.KeySHA256))
+		if k.Key != "" {
+			h = HashKey(k.Key)
+		}
+		if h == "" {
+			return fmt.Errorf("parse %s: key %q has neither key nor key_sha256", r.path, k.Name)
+		}
+		byHash[h] = i
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys, r.byHash, r.modTime = f.Keys, byHash, info.ModTime()
+	return nil
+}
+
+// refresh reloads the file if it changed since the last load. A file that
+// fails to parse leaves the previous keys in place.
+func (r *Registry) refresh() error {
+	r.mu.Lock()
+	if time.Since(r.checkedAt) < reloadInterval {
+		r.mu.Unlock()
+		return nil
+	}
+	r.checkedAt = time.Now()
+	modTime := r.modTime
+	r.mu.Unlock()
+
+	info, err := os.Stat(r.path)
+	if errors.Is(err, os.ErrNotExist) && modTime.IsZero() {
+		return nil
+	}
+	if err != nil || info.ModTime().Equal(modTime) {
+		return err
+	}
+	return r.reload()
+}
+
+// Lookup returns the key whose secret is secret. Disabled keys are returned
+// too so callers can tell "revoked" from "unknown".
+func (r *Registry) Lookup(secret string) (Key, bool, error) {
+	err := r.refresh()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	i, ok := r.byHash[HashKey(secret)]
+	if !ok {
+		return Key{}, false, err
+	}
+	return r.keys[i], true, err
+}
+
+// AllowsModel reports whether the key may call model. An empty list allows
+// every model.
+func (k Key) AllowsModel(model string) bool {
+	return len(k.AllowedModels) == 0 || slices.Contains(k.AllowedModels, model)
+}
+
+// Keys returns a copy of every registered key.
+func (r *Registry) Keys() []Key {
+	_ = r.refresh()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return slices.Clone(r.keys)
+}
+
+// Create adds k with a newly generated secret and returns the secret. Only
+// its hash is written to the registry file.
+func (r *Registry) Create(k Key) (string, error) {
+	if strings.TrimSpace(k.Name) == "" {
+		return "", errors.New("name is required")
+	}
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	secret := "sk-proxy-" + hex.EncodeToString(buf)
+	k.Key, k.KeySHA256 = "", HashKey(secret)
+
+	_ = r.refresh()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if slices.ContainsFunc(r.keys, func(existing Key) bool { return existing.Name == k.Name }) {
+		return "", ErrDuplicateName
+	}
+	if err := r.save(append(slices.Clone(r.keys), k)); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// SetEnabled enables or revokes the key called name.
+func (r *Registry) SetEnabled(name string, enabled bool) error {
+	_ = r.refresh()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := slices.IndexFunc(r.keys, func(k Key) bool { return k.Name == name })
+	if i < 0 {
+		return ErrKeyNotFound
+	}
+	keys := slices.Clone(r.keys)
+	keys[i].Enabled = &enabled
+	return r.save(keys)
+}
+
+// save atomically writes keys to the registry file and makes them current.
+// The caller holds r.mu.
+func (r *Registry) save(keys []Key) error {
+	b, err := json.MarshalIndent(registryFile{Keys: keys}, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(r.path)
+	tmp, err := os.CreateTemp(dir, ".keys-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(b, '\n')); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), r.path); err != nil {
+		return err
+	}
+
+	byHash := make(map[string]int, len(keys))
+	for i, k := range keys {
+		h := strings.ToLower(strings.TrimSpace(k.KeySHA256))
+		if k.Key != "" {
+			h = HashKey(k.Key)
+		}
+		byHash[h] = i
+	}
+	r.keys, r.byHash = keys, byHash
+	if info, err := os.Stat(r.path); err == nil {
+		r.modTime = info.ModTime()
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeRegistry(t *testing.T, dir, body string) string {
+	t.Helper()
+	path := filepath.Join(dir, "keys.json")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadRegistryErrors(t *testing.T) {
+	tests := []struct {
+		name, body, want string
+	}{
+		{"invalid json", `{"keys":`, "parse"},
+		{"missing name", `{"keys":[{"key":"a"}]}`, "key 0 has no name"},
+		{"duplicate name", `{"keys":[{"name":"a","key":"1"},{"name":"a","key":"2"}]}`, `duplicate key name "a"`},
+		{"missing secret", `{"keys":[{"name":"a"}]}`, "neither key nor key_sha256"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadRegistry(writeRegistry(t, t.TempDir(), tt.body))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistryLookup(t *testing.T) {
+	path := writeRegistry(t, t.TempDir(), `{"keys":[
+		{"name":"plain","key":"sk-plain","allowed_models":["a/m"]},
+		{"name":"hashed","key_sha256":"`+strings.ToUpper(HashKey("sk-hashed"))+`"},
+		{"name":"revoked","key":"sk-revoked","enabled":false}]}`)
+	r, err := LoadRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		secret, name string
+		found        bool
+		enabled      bool
+	}{
+		{"sk-plain", "plain", true, true},
+		{"sk-hashed", "hashed", true, true},
+		{"sk-revoked", "revoked", true, false},
+		{"sk-unknown", "", false, false},
+	}
+	for _, tt := range tests {
+		k, found, err := r.Lookup(tt.secret)
+		if err != nil || found != tt.found || k.Name != tt.name || (found && k.IsEnabled() != tt.enabled) {
+			t.Errorf("Lookup(%q) = %q, found %v, enabled %v, err %v", tt.secret, k.Name, found, k.IsEnabled(), err)
+		}
+	}
+	k, _, _ := r.Lookup("sk-plain")
+	if !k.AllowsModel("a/m") || k.AllowsModel("b/m") {
+		t.Errorf("allowed models = %q", k.AllowedModels)
+	}
+	if k, _, _ := r.Lookup("sk-hashed"); !k.AllowsModel("anything") {
+		t.Error("empty allowlist rejected a model")
+	}
+}
+
+func TestRegistryReload(t *testing.T) {
+	dir := t.TempDir()
+	path := writeRegistry(t, dir, `{"keys":[{"name":"a","key":"sk-a"}]}`)
+	r, err := LoadRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+
+	// A broken edit keeps the previous keys.
+	writeRegistry(t, dir, `{"keys":[`)
+	_ = os.Chtimes(path, later, later)
+	r.checkedAt = time.Time{}
+	if _, found, err := r.Lookup("sk-a"); !found || err == nil {
+		t.Errorf("after a broken edit: found %v, err %v", found, err)
+	}
+
+	writeRegistry(t, dir, `{"keys":[{"name":"b","key":"sk-b"}]}`)
+	later = later.Add(time.Minute)
+	_ = os.Chtimes(path, later, later)
+	r.checkedAt = time.Time{}
+	if _, found, _ := r.Lookup("sk-a"); found {
+		t.Error("removed key still found")
+	}
+	if _, found, _ := r.Lookup("sk-b"); !found {
+		t.Error("added key not found")
+	}
+}
+
+func TestRegistryCreate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	r, err := LoadRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := r.Create(Key{Name: "ci", Key: "ignored", DailyTokenBudget: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(secret, "sk-proxy-") {
+		t.Errorf("secret = %q", secret)
+	}
+	if _, err := r.Create(Key{Name: "ci"}); !errors.Is(err, ErrDuplicateName) {
+		t.Errorf("duplicate create: %v", err)
+	}
+	if _, err := r.Create(Key{Name: " "}); err == nil {
+		t.Error("key without a name created")
+	}
+	if err := r.SetEnabled("ci", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetEnabled("missing", false); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("SetEnabled(missing) = %v", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), secret) || strings.Contains(string(b), "ignored") {
+		t.Errorf("registry file holds a plain secret:\n%s", b)
+	}
+	reloaded, err := LoadRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, found, _ := reloaded.Lookup(secret)
+	if !found || k.IsEnabled() || k.DailyTokenBudget != 100 {
+		t.Errorf("reloaded key = %+v, found %v", k, found)
+	}
+}
@@ -0,0 +1,35 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"claude-nvidia-proxy/internal/types"
+)
+
+// Store holds encoded responses under a request key until their TTL expires.
+type Store interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte, ttl time.Duration)
+}
+
+// Key returns a canonical hash of a converted request for the given upstream,
+// scoped so that different inbound clients never share an entry. Streaming
+// and non-streaming variants of a request share a key so a cached result can
+// be replayed either way.
+func Key(scope, upstreamURL string, req types.OpenAIChatCompletionRequest) (string, error) {
+	req.Stream = false
+	b, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write([]byte(scope))
+	h.Write([]byte{0})
+	h.Write([]byte(upstreamURL))
+	h.Write([]byte{0})
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
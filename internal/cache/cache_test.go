@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"claude-nvidia-proxy/internal/types"
+)
+
+func TestKey(t *testing.T) {
+	req := types.OpenAIChatCompletionRequest{Model: "m", Messages: []any{map[string]any{"role": "user", "content": "hi"}}}
+	key := func(scope, url string, r types.OpenAIChatCompletionRequest) string {
+		k, err := Key(scope, url, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	base := key("client", "https://up/v1", req)
+	if len(base) != 64 {
+		t.Fatalf("key = %q", base)
+	}
+
+	streamed := req
+	streamed.Stream = true
+	if key("client", "https://up/v1", streamed) != base {
+		t.Error("streaming changed the key")
+	}
+	other := req
+	other.Model = "n"
+	for name, k := range map[string]string{
+		"scope":    key("other", "https://up/v1", req),
+		"upstream": key("client", "https://other/v1", req),
+		"request":  key("client", "https://up/v1", other),
+		"boundary": key("clienthttps://up/v1", "", req),
+	} {
+		if k == base {
+			t.Errorf("different %s gave the same key", name)
+		}
+	}
+}
+
+// storeTests runs the behaviour every Store shares.
+func storeTests(t *testing.T, s Store) {
+	t.Helper()
+	const a, b = "aa00000000000000000000000000000000000000000000000000000000000000", "bb00000000000000000000000000000000000000000000000000000000000000"
+	if _, ok := s.Get(a); ok {
+		t.Error("empty store hit")
+	}
+	s.Set(a, []byte(`{"v":1}`), time.Minute)
+	if v, ok := s.Get(a); !ok || string(v) != `{"v":1}` {
+		t.Errorf("Get = %s, %v", v, ok)
+	}
+	s.Set(a, []byte(`{"v":2}`), time.Minute)
+	if v, _ := s.Get(a); string(v) != `{"v":2}` {
+		t.Errorf("overwritten Get = %s", v)
+	}
+	s.Set(b, []byte(`{}`), -time.Second)
+	if _, ok := s.Get(b); ok {
+		t.Error("expired entry returned")
+	}
+}
+
+func TestMemory(t *testing.T) {
+	storeTests(t, NewMemory(10))
+
+	m := NewMemory(2)
+	m.Set("a", []byte("1"), time.Minute)
+	m.Set("b", []byte("2"), time.Minute)
+	m.Get("a")
+	m.Set("c", []byte("3"), time.Minute)
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := m.Get(key); ok != want {
+			t.Errorf("after eviction %s present = %v, want %v", key, ok, want)
+		}
+	}
+}
+
+func TestDisk(t *testing.T) {
+	dir := t.TempDir()
+	d, err := NewDisk(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storeTests(t, d)
+
+	d.Set("cc00", []byte(`"kept"`), time.Minute)
+	reopened, err := NewDisk(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := reopened.Get("cc00"); !ok || string(v) != `"kept"` {
+		t.Errorf("entry did not survive reopening: %s, %v", v, ok)
+	}
+}
@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Disk stores one JSON file per key under a directory, so cached responses
+// survive restarts. Expired files are removed when read.
+type Disk struct {
+	dir string
+}
+
+type diskEntry struct {
+	ExpiresAt time.Time       `json:"expires_at"`
+	Value     json.RawMessage `json:"value"`
+}
+
+func NewDisk(dir string) (*Disk, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Disk{dir: dir}, nil
+}
+
+func (d *Disk) path(key string) string {
+	return filepath.Join(d.dir, key[:2], key+".json")
+}
+
+func (d *Disk) Get(key string) ([]byte, bool) {
+	b, err := os.ReadFile(d.path(key))
+	if err != nil {
+		return nil, false
+	}
+	var e diskEntry
+	if err := json.Unmarshal(b, &e); err != nil || time.Now().After(e.ExpiresAt) {
+		_ = os.Remove(d.path(key))
+		return nil, false
+	}
+	return e.Value, true
+}
+
+func (d *Disk) Set(key string, value []byte, ttl time.Duration) {
+	b, err := json.Marshal(diskEntry{ExpiresAt: time.Now().Add(ttl), Value: value})
+	if err != nil {
+		return
+	}
+	p := d.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		slog.Warn("response cache write failed", "err", err)
+		return
+	}
+	// Write then rename so concurrent readers never see a partial file.
+	f, err := os.CreateTemp(filepath.Dir(p), key+".*.tmp")
+	if err != nil {
+		slog.Warn("response cache write failed", "err", err)
+		return
+	}
+	_, werr := f.Write(b)
+	cerr := f.Close()
+	if werr != nil || cerr != nil {
+		_ = os.Remove(f.Name())
+		slog.Warn("response cache write failed", "file", f.Name())
+		return
+	}
+	if err := os.Rename(f.Name(), p); err != nil {
+		_ = os.Remove(f.Name())
+		slog.Warn("response cache write failed", "err", err)
+	}
+}
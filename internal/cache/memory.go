@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Memory is an in-process LRU store bounded by entry count.
+type Memory struct {
+	mu         sync.Mutex
+	maxEntries int
+	order      *list.List
+	items      map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+func NewMemory(maxEntries int) *Memory {
+	return &Memory{
+		maxEntries: maxEntries,
+		order:      list.New(),
+		items:      map[string]*list.Element{},
+	}
+}
+
+func (m *Memory) Get(key string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	el, ok := m.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*memoryEntry)
+	if time.Now().After(e.expires) {
+		m.order.Remove(el)
+		delete(m.items, key)
+		return nil, false
+	}
+	m.order.MoveToFront(el)
+	return e.value, true
+}
+
+func (m *Memory) Set(key string, value []byte, ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if el, ok := m.items[key]; ok {
+		e := el.Value.(*memoryEntry)
+		e.value = value
+		e.expires = time.Now().Add(ttl)
+		m.order.MoveToFront(el)
+		return
+	}
+	m.items[key] = m.order.PushFront(&memoryEntry{key: key, value: value, expires: time.Now().Add(ttl)})
+	for m.maxEntries > 0 && m.order.Len() > m.maxEntries {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.items, oldest.Value.(*memoryEntry).key)
+	}
+}
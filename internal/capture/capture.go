@@ -0,0 +1,266 @@
+// Package capture writes complete request/response exchanges to disk for
+// debugging conversion problems, either one JSON file per request or
+// rotated JSONL files, under a total size cap.
+package capture
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Exchange is everything the proxy saw and sent for one request.
+type Exchange struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id"`
+	Key       string    `json:"key"`
+	Model     string    `json:"model"`
+	Stream    bool      `json:"stream"`
+
+	// Inbound is the Anthropic request body as received.
+	Inbound json.RawMessage `json:"inbound"`
+	// Converted is the OpenAI request body sent upstream.
+	Converted json.RawMessage `json:"converted,omitempty"`
+	// Upstream is the raw upstream response: a JSON body or SSE text.
+	// Empty for cache hits and server tool requests.
+	UpstreamStatus int    `json:"upstream_status,omitempty"`
+	Upstream       string `json:"upstream,omitempty"`
+	// Response is what the client received: a JSON body or SSE text.
+	Status   int    `json:"status"`
+	Response string `json:"response"`
+}
+
+const (
+	FormatFiles = "files"
+	FormatJSONL = "jsonl"
+
+	jsonlName = "capture.jsonl"
+)
+
+type captureFile struct {
+	name string
+	size int64
+}
+
+// Writer stores exchanges in a directory. Once the directory holds more
+// than maxBytes of captures, the oldest files are deleted.
+type Writer struct {
+	dir         string
+	format      string
+	maxBytes    int64
+	rotateBytes int64
+
+	mu    sync.Mutex
+	files []captureFile // closed files, oldest first
+	total int64
+	cur   *os.File // current JSONL file
+	size  int64
+}
+
+// Open creates dir if needed and accounts for captures already in it.
+// Only files named like the ones Writer creates count as captures.
+// rotateBytes only applies to the JSONL format; 0 rotates only when the
+// cap requires it. Captures hold full prompts, so files are owner-only.
+func Open(dir, format string, maxBytes, rotateBytes int64) (*Writer, error) {
+	if format != FormatFiles && format != FormatJSONL {
+		return nil, fmt.Errorf("unknown capture format %q", format)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	w := &Writer{dir: dir, format: format, maxBytes: maxBytes, rotateBytes: rotateBytes}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type existing struct {
+		captureFile
+		mod time.Time
+	}
+	var found []existing
+	for _, e := range entries {
+		if e.IsDir() || e.Name() == jsonlName || !isCaptureFile(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		found = append(found, existing{captureFile{e.Name(), info.Size()}, info.ModTime()})
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].mod.Before(found[j].mod) })
+	for _, f := range found {
+		w.files = append(w.files, f.captureFile)
+		w.total += f.size
+	}
+
+	if format == FormatJSONL {
+		f, err := os.OpenFile(filepath.Join(dir, jsonlName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		w.cur, w.size = f, info.Size()
+		w.total += w.size
+	}
+	return w, nil
+}
+
+// captureName matches the names the writer creates, so pruning and
+// ReadDir leave other files in the directory alone.
+var captureName = regexp.MustCompile(`^(req_\d+\.json|capture\.jsonl|capture-\d+\.jsonl)$`)
+
+func isCaptureFile(name string) bool {
+	return captureName.MatchString(name)
+}
+
+// Write stores ex and enforces the size cap.
+func (w *Writer) Write(ex *Exchange) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var err error
+	if w.format == FormatJSONL {
+		err = w.appendJSONL(ex)
+	} else {
+		err = w.writeFile(ex)
+	}
+	w.prune()
+	return err
+}
+
+func (w *Writer) writeFile(ex *Exchange) error {
+	b, err := json.MarshalIndent(ex, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := ex.RequestID + ".json"
+	if err := os.WriteFile(filepath.Join(w.dir, name), b, 0o600); err != nil {
+		return err
+	}
+	w.files = append(w.files, captureFile{name, int64(len(b))})
+	w.total += int64(len(b))
+	return nil
+}
+
+func (w *Writer) appendJSONL(ex *Exchange) error {
+	b, err := json.Marshal(ex)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	// Rotate at rotateBytes, and also when the current file alone would
+	// outgrow the cap: prune only deletes closed files.
+	next := w.size + int64(len(b))
+	if w.size > 0 && (w.rotateBytes > 0 && next > w.rotateBytes || w.maxBytes > 0 && next > w.maxBytes) {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := w.cur.Write(b)
+	w.size += int64(n)
+	w.total += int64(n)
+	return err
+}
+
+// rotate renames the current JSONL file after its last write time and
+// starts a new one.
+func (w *Writer) rotate() error {
+	if err := w.cur.Close(); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("capture-%d.jsonl", time.Now().UnixNano())
+	if err := os.Rename(filepath.Join(w.dir, jsonlName), filepath.Join(w.dir, name)); err != nil {
+		return err
+	}
+	w.files = append(w.files, captureFile{name, w.size})
+	f, err := os.OpenFile(filepath.Join(w.dir, jsonlName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	w.cur, w.size = f, 0
+	return nil
+}
+
+// prune deletes the oldest closed files until the directory fits the cap.
+// The current JSONL file is never deleted.
+func (w *Writer) prune() {
+	if w.maxBytes <= 0 {
+		return
+	}
+	for w.total > w.maxBytes && len(w.files) > 0 {
+		f := w.files[0]
+		w.files = w.files[1:]
+		w.total -= f.size
+		_ = os.Remove(filepath.Join(w.dir, f.name))
+	}
+}
+
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.cur == nil {
+		return nil
+	}
+	return w.cur.Close()
+}
+
+// ReadFile loads the exchanges in a capture file: a single JSON exchange
+// (.json) or one per line (.jsonl).
+func ReadFile(path string) ([]*Exchange, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".jsonl") {
+		var ex Exchange
+		if err := json.Unmarshal(b, &ex); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+		return []*Exchange{&ex}, nil
+	}
+	var out []*Exchange
+	for i, line := range strings.Split(string(b), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var ex Exchange
+		if err := json.Unmarshal([]byte(line), &ex); err != nil {
+			return nil, fmt.Errorf("parse %s line %d: %w", path, i+1, err)
+		}
+		out = append(out, &ex)
+	}
+	return out, nil
+}
+
+// ReadDir loads every capture file in dir, oldest exchange first. Other
+// files are ignored.
+func ReadDir(dir string) ([]*Exchange, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []*Exchange
+	for _, e := range entries {
+		if e.IsDir() || !isCaptureFile(e.Name()) {
+			continue
+		}
+		exs, err := ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, exs...)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Time.Before(out[j].Time) })
+	return out, nil
+}
@@ -0,0 +1,276 @@
+package capture
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func exchange(i int) *Exchange {
+	return &Exchange{
+		Time:      time.Unix(1700000000, 0).Add(time.Duration(i) * time.Second),
+		RequestID: "req_" + strconv.Itoa(i),
+		Model:     "m",
+		Inbound:   json.RawMessage(`{"messages":[]}`),
+		Status:    200,
+		Response:  strings.Repeat("x", 100),
+	}
+}
+
+// dirSize sums the capture files in dir.
+func dirSize(t *testing.T, dir string) (total int64, names []string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += info.Size()
+		names = append(names, e.Name())
+	}
+	return total, names
+}
+
+// requestIDs lists the exchanges in dir, oldest first.
+func requestIDs(t *testing.T, dir string) []string {
+	t.Helper()
+	exs, err := ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, ex := range exs {
+		ids = append(ids, ex.RequestID)
+	}
+	return ids
+}
+
+func TestOpenRejectsUnknownFormat(t *testing.T) {
+	if _, err := Open(t.TempDir(), "csv", 0, 0); err == nil {
+		t.Error("Open accepted format csv")
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	for _, format := range []string{FormatFiles, FormatJSONL} {
+		t.Run(format, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "captures")
+			w, err := Open(dir, format, 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+			if err := w.Write(exchange(1)); err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Stat(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if perm := info.Mode().Perm(); perm != 0o700 {
+				t.Errorf("dir mode = %o, want 700", perm)
+			}
+			_, names := dirSize(t, dir)
+			for _, name := range names {
+				info, err := os.Stat(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if perm := info.Mode().Perm(); perm != 0o600 {
+					t.Errorf("%s mode = %o, want 600", name, perm)
+				}
+			}
+		})
+	}
+}
+
+func TestFilesPrune(t *testing.T) {
+	dir := t.TempDir()
+	one, err := json.MarshalIndent(exchange(1), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Room for three files.
+	w, err := Open(dir, FormatFiles, 3*int64(len(one))+10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for i := 1; i <= 5; i++ {
+		if err := w.Write(exchange(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := strings.Join(requestIDs(t, dir), ","), "req_3,req_4,req_5"; got != want {
+		t.Errorf("kept %s, want %s", got, want)
+	}
+}
+
+func TestJSONLRotation(t *testing.T) {
+	dir := t.TempDir()
+	line, err := json.Marshal(exchange(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two lines per file, no cap.
+	w, err := Open(dir, FormatJSONL, 0, 2*int64(len(line)+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for i := 1; i <= 5; i++ {
+		if err := w.Write(exchange(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, names := dirSize(t, dir)
+	if len(names) != 3 {
+		t.Errorf("files = %v, want two rotated files and %s", names, jsonlName)
+	}
+	if got, want := strings.Join(requestIDs(t, dir), ","), "req_1,req_2,req_3,req_4,req_5"; got != want {
+		t.Errorf("exchanges = %s, want %s", got, want)
+	}
+	cur, err := ReadFile(filepath.Join(dir, jsonlName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cur) != 1 || cur[0].RequestID != "req_5" {
+		t.Errorf("current file holds %d exchanges", len(cur))
+	}
+}
+
+func TestJSONLCap(t *testing.T) {
+	line, err := json.Marshal(exchange(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(len(line) + 1)
+	tests := []struct {
+		name   string
+		rotate int64
+	}{
+		{"without rotation", 0},
+		{"rotation larger than the cap", 100 * size},
+		{"rotation smaller than the cap", size},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			maxBytes := 3*size + size/2
+			w, err := Open(dir, FormatJSONL, maxBytes, tt.rotate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+			for i := 1; i <= 10; i++ {
+				if err := w.Write(exchange(i)); err != nil {
+					t.Fatal(err)
+				}
+				if total, names := dirSize(t, dir); total > maxBytes {
+					t.Fatalf("after write %d: %d bytes in %v, cap %d", i, total, names, maxBytes)
+				}
+			}
+			ids := requestIDs(t, dir)
+			if len(ids) == 0 || ids[len(ids)-1] != "req_10" {
+				t.Errorf("exchanges = %v, want the newest kept", ids)
+			}
+		})
+	}
+}
+
+func TestOpenAccountsForExistingCaptures(t *testing.T) {
+	dir := t.TempDir()
+	w, err := Open(dir, FormatFiles, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 4; i++ {
+		if err := w.Write(exchange(i)); err != nil {
+			t.Fatal(err)
+		}
+		// Distinct mtimes so Open can order the files.
+		old := time.Now().Add(time.Duration(i-10) * time.Minute)
+		if err := os.Chtimes(filepath.Join(dir, exchange(i).RequestID+".json"), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = w.Close()
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte(strings.Repeat("n", 10000)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	total, _ := dirSize(t, dir)
+	total -= 10000
+	w, err = Open(dir, FormatFiles, total, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := w.Write(exchange(5)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(requestIDs(t, dir), ","), "req_2,req_3,req_4,req_5"; got != want {
+		t.Errorf("kept %s, want %s", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+		t.Errorf("non-capture file removed: %v", err)
+	}
+}
+
+func TestForeignFilesKept(t *testing.T) {
+	foreign := map[string]string{
+		"config.json":      `{"upstream_url":"http://x"}`,
+		"keys.json":        `[{"name":"a","key":"secret"}]`,
+		"req_notes.json":   `not json`,
+		"notes.jsonl":      "not json\n",
+		"capture-x.jsonl":  "not json\n",
+		"capture.jsonl.gz": "gzip",
+	}
+	for _, format := range []string{FormatFiles, FormatJSONL} {
+		t.Run(format, func(t *testing.T) {
+			dir := t.TempDir()
+			old := time.Now().Add(-time.Hour)
+			for name, body := range foreign {
+				path := filepath.Join(dir, name)
+				if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chtimes(path, old, old); err != nil {
+					t.Fatal(err)
+				}
+			}
+			line, err := json.Marshal(exchange(1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Room for about two captures, far less than the foreign files.
+			w, err := Open(dir, format, 2*int64(len(line))+int64(len(line))/2, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+			for i := 1; i <= 5; i++ {
+				if err := w.Write(exchange(i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for name := range foreign {
+				if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+					t.Errorf("%s removed: %v", name, err)
+				}
+			}
+			ids := requestIDs(t, dir)
+			if len(ids) == 0 || ids[len(ids)-1] != "req_5" {
+				t.Errorf("exchanges = %v, want the newest kept", ids)
+			}
+		})
+	}
+}
@@ -4,15 +4,96 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+
+	"claude-nvidia-proxy/internal/ratelimit"
+	"claude-nvidia-proxy/internal/types"
 )
 
 type FileConfig struct {
-	NvidiaURL string `json:"nvidia_url"`
-	NvidiaKey string `json:"nvidia_key"`
+	NvidiaURL string                             `json:"nvidia_url"`
+	NvidiaKey string                             `json:"nvidia_key"`
+	Models    map[string]ModelCapabilityOverride `json:"models,omitempty"`
+
+	// SchemaSanitizer starts from defaultSchemaRules; fields present in
+	// config.json replace the defaults. A models entry may override fields
+	// again for one model.
+	SchemaSanitizer types.SchemaRules `json:"schema_sanitizer"`
+
+	// RateLimits applies to each inbound API key; ModelRateLimits to all
+	// traffic for one model.
+	RateLimits      ratelimit.Limits            `json:"rate_limits"`
+	ModelRateLimits map[string]ratelimit.Limits `json:"model_rate_limits,omitempty"`
+
+	KeysFile  string `json:"keys_file,omitempty"`
+	UsageFile string `json:"usage_file,omitempty"`
+
+	// LogRedaction masks secrets in logged bodies. Builtin defaults to true.
+	LogRedaction LogRedaction `json:"log_redaction"`
+}
+
+// LogRedaction enables the built-in secret detectors and adds custom
+// patterns, keyed by the name shown in the replacement text.
+type LogRedaction struct {
+	Builtin  bool              `json:"builtin"`
+	Patterns map[string]string `json:"patterns,omitempty"`
+}
+
+// defaultSchemaMaxDepth bounds schema nesting; real tool schemas stay far
+// below it.
+const defaultSchemaMaxDepth = 32
+
+func defaultSchemaRules() types.SchemaRules {
+	return types.SchemaRules{
+		Enabled:                         true,
+		StripKeywords:                   []string{"$schema", "$id", "$comment"},
+		StripFormats:                    []string{"uri", "uri-reference"},
+		StripNestedAdditionalProperties: true,
+		CollapseNullable:                true,
+		ResolveRefs:                     true,
+		MaxDepth:                        defaultSchemaMaxDepth,
+	}
+}
+
+// ModelCapabilityOverride is a partial capability entry from config.json;
+// unset fields keep the built-in (or full) capability value.
+type ModelCapabilityOverride struct {
+	Vision        *bool `json:"vision,omitempty"`
+	Tools         *bool `json:"tools,omitempty"`
+	ParallelTools *bool `json:"parallel_tools,omitempty"`
+	SystemRole    *bool `json:"system_role,omitempty"`
+	Thinking      *bool `json:"thinking,omitempty"`
+
+	SystemBlocks   *bool `json:"system_blocks,omitempty"`
+	PromptCacheKey *bool `json:"prompt_cache_key,omitempty"`
+
+	// SchemaSanitizer holds schema_sanitizer fields that replace the global
+	// rules for this model.
+	SchemaSanitizer json.RawMessage `json:"schema_sanitizer,omitempty"`
+}
+
+// builtinModelCapabilities lists known limitations of NVIDIA-hosted models.
+var builtinModelCapabilities = map[string]types.ModelCapabilities{
+	"z-ai/glm4.7": {
+		Vision:        false,
+		Tools:         true,
+		ParallelTools: true,
+		SystemRole:    true,
+		Thinking:      true,
+	},
+	"minimaxai/minimax-m2.1": {
+		Vision:        false,
+		Tools:         true,
+		ParallelTools: true,
+		SystemRole:    true,
+		Thinking:      true,
+	},
 }
 
 type ServerConfig struct {
@@ -23,6 +104,88 @@ type ServerConfig struct {
 	Timeout             time.Duration
 	LogBodyMax          int
 	LogStreamPreviewMax int
+	LogFormat           string // "text" or "json"
+	LogLevel            slog.Level
+	LogRedactBuiltin    bool
+	LogRedactPatterns   map[string]*regexp.Regexp
+	Models              map[string]types.ModelCapabilities
+	SchemaRules         types.SchemaRules
+	ModelSchemaRules    map[string]types.SchemaRules
+	WebSearchURL        string
+	WebSearchAPIKey     string
+	WebSearchMaxResults int
+
+	// ResponseCache is "off", "memory" or "disk".
+	ResponseCache           string
+	ResponseCacheTTL        time.Duration
+	ResponseCacheMaxEntries int
+	ResponseCacheDir        string
+
+	PromptCacheEmulation bool
+	PromptCacheTTL       time.Duration
+
+	// RequestDedup coalesces concurrent identical requests from one API key
+	// into a single upstream call.
+	RequestDedup bool
+
+	KeyRateLimits   ratelimit.Limits
+	ModelRateLimits map[string]ratelimit.Limits
+
+	// KeysFile is the inbound key registry; SERVER_API_KEY still works
+	// alongside it as the "default" key.
+	KeysFile string
+
+	// AdminAPIKey enables the /admin API; AdminAddr, when set, serves it on
+	// its own listener instead of Addr.
+	AdminAPIKey string
+	AdminAddr   string
+
+	// UsageFile is the JSONL usage log; empty keeps usage in memory only.
+	UsageFile string
+
+	// TraceEndpoint is the OTLP/HTTP traces URL; empty disables export.
+	TraceEndpoint    string
+	TraceServiceName string
+	TraceHeaders     map[string]string
+
+	// CaptureDir enables full request/response capture. When any of
+	// CaptureModels, CaptureKeys or CaptureHeader is set, only requests
+	// matching one of them are captured.
+	CaptureDir         string
+	CaptureFormat      string // "files" or "jsonl"
+	CaptureMaxBytes    int64
+	CaptureRotateBytes int64
+	CaptureModels      []string
+	CaptureKeys        []string
+	CaptureHeader      string
+
+	// MetricsModels always get their own metric model label; up to
+	// MetricsMaxModels other model names get one in the order they are
+	// first seen, and the rest are counted as "other".
+	MetricsModels    []string
+	MetricsMaxModels int
+
+	// UpstreamMaxEventBytes caps a single upstream stream event; 0 uses
+	// the SSE decoder's default.
+	UpstreamMaxEventBytes int
+}
+
+// CapabilitiesFor returns the capability entry for model, falling back to
+// full capabilities for models not in the table.
+func (c *ServerConfig) CapabilitiesFor(model string) types.ModelCapabilities {
+	if caps, ok := c.Models[strings.TrimSpace(model)]; ok {
+		return caps
+	}
+	return types.FullCapabilities()
+}
+
+// SchemaRulesFor returns the schema sanitizer rules for model: its own
+// entry when config.json has one, otherwise the global rules.
+func (c *ServerConfig) SchemaRulesFor(model string) types.SchemaRules {
+	if rules, ok := c.ModelSchemaRules[strings.TrimSpace(model)]; ok {
+		return rules
+	}
+	return c.SchemaRules
 }
 
 func LoadConfig() (*ServerConfig, error) {
@@ -35,6 +198,61 @@ func LoadConfig() (*ServerConfig, error) {
 	upstreamURL := strings.TrimSpace(envOr("UPSTREAM_URL", fc.NvidiaURL))
 	providerAPIKey := strings.TrimSpace(envOr("PROVIDER_API_KEY", fc.NvidiaKey))
 	serverAPIKey := strings.TrimSpace(envOr("SERVER_API_KEY", ""))
+	keysFile := strings.TrimSpace(envOr("KEYS_FILE", fc.KeysFile))
+	adminAPIKey := strings.TrimSpace(envOr("ADMIN_API_KEY", ""))
+	adminAddr := strings.TrimSpace(envOr("ADMIN_ADDR", ""))
+	usageFile := strings.TrimSpace(envOr("USAGE_FILE", fc.UsageFile))
+
+	traceEndpoint := strings.TrimSpace(envOr("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", ""))
+	if base := strings.TrimSpace(envOr("OTEL_EXPORTER_OTLP_ENDPOINT", "")); traceEndpoint == "" && base != "" {
+		traceEndpoint = strings.TrimRight(base, "/") + "/v1/traces"
+	}
+	traceServiceName := strings.TrimSpace(envOr("OTEL_SERVICE_NAME", "claude-nvidia-proxy"))
+	traceHeaders := map[string]string{}
+	if raw := strings.TrimSpace(envOr("OTEL_EXPORTER_OTLP_HEADERS", "")); raw != "" {
+		for _, pair := range strings.Split(raw, ",") {
+			k, v, ok := strings.Cut(pair, "=")
+			if !ok || strings.TrimSpace(k) == "" {
+				return nil, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_HEADERS: %q", raw)
+			}
+			traceHeaders[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+
+	captureDir := strings.TrimSpace(envOr("CAPTURE_DIR", ""))
+	captureFormat := strings.ToLower(strings.TrimSpace(envOr("CAPTURE_FORMAT", "files")))
+	if captureFormat != "files" && captureFormat != "jsonl" {
+		return nil, fmt.Errorf("invalid CAPTURE_FORMAT: %q", captureFormat)
+	}
+	captureMaxMB := int64(512)
+	if raw := strings.TrimSpace(envOr("CAPTURE_MAX_MB", "")); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid CAPTURE_MAX_MB: %q", raw)
+		}
+		captureMaxMB = n
+	}
+	captureRotateMB := int64(64)
+	if raw := strings.TrimSpace(envOr("CAPTURE_ROTATE_MB", "")); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid CAPTURE_ROTATE_MB: %q", raw)
+		}
+		captureRotateMB = n
+	}
+	captureModels := splitList(envOr("CAPTURE_MODELS", ""))
+	captureKeys := splitList(envOr("CAPTURE_KEYS", ""))
+	captureHeader := strings.TrimSpace(envOr("CAPTURE_HEADER", ""))
+
+	metricsModels := splitList(envOr("METRICS_MODELS", ""))
+	metricsMaxModels := 20
+	if raw := strings.TrimSpace(envOr("METRICS_MAX_MODELS", "")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid METRICS_MAX_MODELS: %q", raw)
+		}
+		metricsMaxModels = n
+	}
 
 	timeout := 5 * time.Minute
 	if raw := strings.TrimSpace(envOr("UPSTREAM_TIMEOUT_SECONDS", "")); raw != "" {
@@ -45,6 +263,15 @@ func LoadConfig() (*ServerConfig, error) {
 		timeout = time.Duration(seconds) * time.Second
 	}
 
+	upstreamMaxEventKB := 1024
+	if raw := strings.TrimSpace(envOr("UPSTREAM_MAX_EVENT_KB", "")); raw != "" {
+		kb, err := strconv.Atoi(raw)
+		if err != nil || kb <= 0 {
+			return nil, fmt.Errorf("invalid UPSTREAM_MAX_EVENT_KB: %q", raw)
+		}
+		upstreamMaxEventKB = kb
+	}
+
 	logBodyMax := 4096
 	if raw := strings.TrimSpace(envOr("LOG_BODY_MAX_CHARS", "")); raw != "" {
 		n, err := strconv.Atoi(raw)
@@ -63,6 +290,122 @@ func LoadConfig() (*ServerConfig, error) {
 		logStreamPreviewMax = n
 	}
 
+	logFormat := strings.ToLower(strings.TrimSpace(envOr("LOG_FORMAT", "text")))
+	if logFormat != "text" && logFormat != "json" {
+		return nil, fmt.Errorf("invalid LOG_FORMAT: %q", logFormat)
+	}
+
+	var logLevel slog.Level
+	if raw := strings.TrimSpace(envOr("LOG_LEVEL", "info")); logLevel.UnmarshalText([]byte(raw)) != nil {
+		return nil, fmt.Errorf("invalid LOG_LEVEL: %q", raw)
+	}
+
+	logRedactBuiltin := fc.LogRedaction.Builtin
+	if raw := strings.TrimSpace(envOr("LOG_REDACT", "")); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LOG_REDACT: %q", raw)
+		}
+		logRedactBuiltin = v
+	}
+	logRedactPatterns := map[string]*regexp.Regexp{}
+	for name, expr := range fc.LogRedaction.Patterns {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid log_redaction pattern %q: %w", name, err)
+		}
+		logRedactPatterns[name] = re
+	}
+
+	webSearchURL := strings.TrimSpace(envOr("WEB_SEARCH_URL", ""))
+	webSearchAPIKey := strings.TrimSpace(envOr("WEB_SEARCH_API_KEY", ""))
+	webSearchMaxResults := 5
+	if raw := strings.TrimSpace(envOr("WEB_SEARCH_MAX_RESULTS", "")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid WEB_SEARCH_MAX_RESULTS: %q", raw)
+		}
+		webSearchMaxResults = n
+	}
+
+	responseCache := strings.ToLower(strings.TrimSpace(envOr("RESPONSE_CACHE", "off")))
+	switch responseCache {
+	case "off", "memory", "disk":
+	default:
+		return nil, fmt.Errorf("invalid RESPONSE_CACHE: %q (want off, memory or disk)", responseCache)
+	}
+	responseCacheTTL := time.Hour
+	if raw := strings.TrimSpace(envOr("RESPONSE_CACHE_TTL_SECONDS", "")); raw != "" {
+		seconds, err := strconv.Atoi(raw)
+		if err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("invalid RESPONSE_CACHE_TTL_SECONDS: %q", raw)
+		}
+		responseCacheTTL = time.Duration(seconds) * time.Second
+	}
+	responseCacheMaxEntries := 1000
+	if raw := strings.TrimSpace(envOr("RESPONSE_CACHE_MAX_ENTRIES", "")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid RESPONSE_CACHE_MAX_ENTRIES: %q", raw)
+		}
+		responseCacheMaxEntries = n
+	}
+	responseCacheDir := strings.TrimSpace(envOr("RESPONSE_CACHE_DIR", "cache/responses"))
+
+	promptCacheEmulation := true
+	if raw := strings.TrimSpace(envOr("PROMPT_CACHE_EMULATION", "")); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PROMPT_CACHE_EMULATION: %q", raw)
+		}
+		promptCacheEmulation = v
+	}
+	promptCacheTTL := 5 * time.Minute
+	if raw := strings.TrimSpace(envOr("PROMPT_CACHE_TTL_SECONDS", "")); raw != "" {
+		seconds, err := strconv.Atoi(raw)
+		if err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("invalid PROMPT_CACHE_TTL_SECONDS: %q", raw)
+		}
+		promptCacheTTL = time.Duration(seconds) * time.Second
+	}
+
+	requestDedup := true
+	if raw := strings.TrimSpace(envOr("REQUEST_DEDUP", "")); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid REQUEST_DEDUP: %q", raw)
+		}
+		requestDedup = v
+	}
+
+	keyRateLimits := fc.RateLimits
+	if raw := strings.TrimSpace(envOr("RATE_LIMIT_RPM", "")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid RATE_LIMIT_RPM: %q", raw)
+		}
+		keyRateLimits.RequestsPerMinute = n
+	}
+	if raw := strings.TrimSpace(envOr("RATE_LIMIT_TPM", "")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid RATE_LIMIT_TPM: %q", raw)
+		}
+		keyRateLimits.TokensPerMinute = n
+	}
+	if raw := strings.TrimSpace(envOr("RATE_LIMIT_MAX_STREAMS", "")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid RATE_LIMIT_MAX_STREAMS: %q", raw)
+		}
+		keyRateLimits.MaxConcurrentStreams = n
+	}
+
+	modelSchemaRules, err := resolveModelSchemaRules(fc.SchemaSanitizer, fc.Models)
+	if err != nil {
+		return nil, err
+	}
+
 	if upstreamURL == "" {
 		return nil, errors.New("missing nvidia_url in config.json (or UPSTREAM_URL)")
 	}
@@ -77,21 +420,128 @@ func LoadConfig() (*ServerConfig, error) {
 		Timeout:             timeout,
 		LogBodyMax:          logBodyMax,
 		LogStreamPreviewMax: logStreamPreviewMax,
+		LogFormat:           logFormat,
+		LogLevel:            logLevel,
+		LogRedactBuiltin:    logRedactBuiltin,
+		LogRedactPatterns:   logRedactPatterns,
+		Models:              resolveModelCapabilities(fc.Models),
+		SchemaRules:         fc.SchemaSanitizer,
+		ModelSchemaRules:    modelSchemaRules,
+		WebSearchURL:        webSearchURL,
+		WebSearchAPIKey:     webSearchAPIKey,
+		WebSearchMaxResults: webSearchMaxResults,
+
+		ResponseCache:           responseCache,
+		ResponseCacheTTL:        responseCacheTTL,
+		ResponseCacheMaxEntries: responseCacheMaxEntries,
+		ResponseCacheDir:        responseCacheDir,
+
+		PromptCacheEmulation: promptCacheEmulation,
+		PromptCacheTTL:       promptCacheTTL,
+
+		RequestDedup: requestDedup,
+
+		KeyRateLimits:   keyRateLimits,
+		ModelRateLimits: fc.ModelRateLimits,
+
+		KeysFile: keysFile,
+
+		AdminAPIKey: adminAPIKey,
+		AdminAddr:   adminAddr,
+
+		UsageFile: usageFile,
+
+		TraceEndpoint:    traceEndpoint,
+		TraceServiceName: traceServiceName,
+		TraceHeaders:     traceHeaders,
+
+		CaptureDir:         captureDir,
+		CaptureFormat:      captureFormat,
+		CaptureMaxBytes:    captureMaxMB << 20,
+		CaptureRotateBytes: captureRotateMB << 20,
+		CaptureModels:      captureModels,
+		CaptureKeys:        captureKeys,
+		CaptureHeader:      captureHeader,
+
+		MetricsModels:    metricsModels,
+		MetricsMaxModels: metricsMaxModels,
+
+		UpstreamMaxEventBytes: upstreamMaxEventKB << 10,
 	}, nil
 }
 
+func resolveModelCapabilities(overrides map[string]ModelCapabilityOverride) map[string]types.ModelCapabilities {
+	out := make(map[string]types.ModelCapabilities, len(builtinModelCapabilities)+len(overrides))
+	for model, caps := range builtinModelCapabilities {
+		out[model] = caps
+	}
+	for model, o := range overrides {
+		model = strings.TrimSpace(model)
+		caps, ok := out[model]
+		if !ok {
+			caps = types.FullCapabilities()
+		}
+		applyBool(&caps.Vision, o.Vision)
+		applyBool(&caps.Tools, o.Tools)
+		applyBool(&caps.ParallelTools, o.ParallelTools)
+		applyBool(&caps.SystemRole, o.SystemRole)
+		applyBool(&caps.Thinking, o.Thinking)
+		applyBool(&caps.SystemBlocks, o.SystemBlocks)
+		applyBool(&caps.PromptCacheKey, o.PromptCacheKey)
+		out[model] = caps
+	}
+	return out
+}
+
+// resolveModelSchemaRules layers each model's schema_sanitizer fields over
+// the global rules.
+func resolveModelSchemaRules(global types.SchemaRules, overrides map[string]ModelCapabilityOverride) (map[string]types.SchemaRules, error) {
+	out := map[string]types.SchemaRules{}
+	for model, o := range overrides {
+		if len(o.SchemaSanitizer) == 0 {
+			continue
+		}
+		rules := global
+		// Unmarshal reuses slice storage; keep the global lists intact.
+		rules.StripKeywords = slices.Clone(global.StripKeywords)
+		rules.StripFormats = slices.Clone(global.StripFormats)
+		if err := json.Unmarshal(o.SchemaSanitizer, &rules); err != nil {
+			return nil, fmt.Errorf("invalid schema_sanitizer for model %q: %w", model, err)
+		}
+		out[strings.TrimSpace(model)] = rules
+	}
+	return out, nil
+}
+
+func applyBool(dst *bool, v *bool) {
+	if v != nil {
+		*dst = *v
+	}
+}
+
 func loadFileConfig(path string) (*FileConfig, error) {
 	b, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("read %s: %w", path, err)
 	}
-	var fc FileConfig
+	fc := FileConfig{SchemaSanitizer: defaultSchemaRules(), LogRedaction: LogRedaction{Builtin: true}}
 	if err := json.Unmarshal(b, &fc); err != nil {
 		return nil, fmt.Errorf("parse %s: %w", path, err)
 	}
 	return &fc, nil
 }
 
+// splitList splits a comma-separated list, dropping empty items.
+func splitList(raw string) []string {
+	var out []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
 func envOr(key, fallback string) string {
 	if v, ok := os.LookupEnv(key); ok {
 		return v
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"testing"
+
+	"claude-nvidia-proxy/internal/types"
+)
+
+func TestResolveModelCapabilities(t *testing.T) {
+	no, yes := false, true
+	models := resolveModelCapabilities(map[string]ModelCapabilityOverride{
+		"z-ai/glm4.7":      {Vision: &yes},
+		" custom/model ":   {Tools: &no, SystemBlocks: &yes},
+		"untouched/model":  {},
+		"minimaxai/other":  {Thinking: &no},
+		"text-only/model":  {Vision: &no, Tools: &no, ParallelTools: &no},
+		"builtin-override": {SystemRole: &no},
+	})
+
+	glm := builtinModelCapabilities["z-ai/glm4.7"]
+	glm.Vision = true
+	custom := types.FullCapabilities()
+	custom.Tools, custom.SystemBlocks = false, true
+	minimax := types.FullCapabilities()
+	minimax.Thinking = false
+	textOnly := types.FullCapabilities()
+	textOnly.Vision, textOnly.Tools, textOnly.ParallelTools = false, false, false
+	noSystem := types.FullCapabilities()
+	noSystem.SystemRole = false
+
+	tests := []struct {
+		model string
+		want  types.ModelCapabilities
+	}{
+		{"z-ai/glm4.7", glm},
+		{"minimaxai/minimax-m2.1", builtinModelCapabilities["minimaxai/minimax-m2.1"]},
+		{"custom/model", custom},
+		{"untouched/model", types.FullCapabilities()},
+		{"minimaxai/other", minimax},
+		{"text-only/model", textOnly},
+		{"builtin-override", noSystem},
+	}
+	for _, tt := range tests {
+		if got, ok := models[tt.model]; !ok || got != tt.want {
+			t.Errorf("%s = %+v (present %v), want %+v", tt.model, got, ok, tt.want)
+		}
+	}
+}
+
+func TestCapabilitiesFor(t *testing.T) {
+	cfg := &ServerConfig{Models: resolveModelCapabilities(nil)}
+	if got := cfg.CapabilitiesFor(" z-ai/glm4.7 "); got.Vision {
+		t.Errorf("CapabilitiesFor(glm4.7) = %+v, want no vision", got)
+	}
+	if got := cfg.CapabilitiesFor("unknown/model"); got != types.FullCapabilities() {
+		t.Errorf("CapabilitiesFor(unknown) = %+v, want full capabilities", got)
+	}
+}
+
+func TestResolveModelSchemaRules(t *testing.T) {
+	global := defaultSchemaRules()
+	models, err := resolveModelSchemaRules(global, map[string]ModelCapabilityOverride{
+		"strict/model": {SchemaSanitizer: []byte(`{"strip_formats":["uri","date-time"],"max_depth":4}`)},
+		"plain/model":  {},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &ServerConfig{SchemaRules: global, ModelSchemaRules: models}
+
+	strict := cfg.SchemaRulesFor("strict/model")
+	if strict.MaxDepth != 4 || len(strict.StripFormats) != 2 || strict.StripFormats[1] != "date-time" {
+		t.Errorf("strict/model rules = %+v", strict)
+	}
+	if !strict.ResolveRefs || len(strict.StripKeywords) != len(global.StripKeywords) {
+		t.Errorf("strict/model lost global fields: %+v", strict)
+	}
+	if got := cfg.SchemaRulesFor("plain/model"); got.MaxDepth != defaultSchemaMaxDepth {
+		t.Errorf("plain/model rules = %+v, want the global rules", got)
+	}
+	if global.StripFormats[1] != "uri-reference" {
+		t.Errorf("model override changed the global rules: %q", global.StripFormats)
+	}
+
+	if _, err := resolveModelSchemaRules(global, map[string]ModelCapabilityOverride{"bad": {SchemaSanitizer: []byte(`{"max_depth":"x"}`)}}); err == nil {
+		t.Error("invalid schema_sanitizer accepted")
+	}
+}
@@ -0,0 +1,111 @@
+package converter
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"claude-nvidia-proxy/internal/types"
+)
+
+// imagePlaceholder replaces image blocks for models without vision support.
+const imagePlaceholder = "[image omitted: the model does not support image input]"
+
+// GeneratedToolIDPrefix starts every tool_use id the proxy makes up, so a
+// generated id cannot collide with one the upstream sent.
+const GeneratedToolIDPrefix = "toolu_proxy_"
+
+var toolIDSeq atomic.Uint64
+
+// NewToolUseID returns a fresh id for a tool call that arrived without a
+// usable one, or for an emulated call.
+func NewToolUseID() string {
+	return fmt.Sprintf("%s%d_%d", GeneratedToolIDPrefix, time.Now().UnixNano(), toolIDSeq.Add(1))
+}
+
+// Conversion carries the per-request settings used by the converters and
+// records every feature that had to be downgraded for the upstream model.
+type Conversion struct {
+	Capabilities types.ModelCapabilities
+	Downgrades   []string
+
+	// SchemaRules is applied to every tool input schema; SchemaChanges lists
+	// the rewrites that were made.
+	SchemaRules   types.SchemaRules
+	SchemaChanges []string
+
+	// EmulateTools is set when tools are described in the prompt instead of
+	// being sent natively; responses must then be parsed for call blocks.
+	EmulateTools bool
+
+	// toolNamesUp and toolNamesDown hold the reversible mapping between
+	// client tool names and names accepted by the upstream.
+	toolNamesUp   map[string]string
+	toolNamesDown map[string]string
+
+	// WebSearchEnabled is set when a local web search backend can execute
+	// web_search server tools; serverTools holds the declared ones by name.
+	WebSearchEnabled bool
+	serverTools      map[string]types.AnthropicTool
+
+	// SingleToolCall is set by tool_choice.disable_parallel_tool_use; replies
+	// are truncated to their first tool call for backends that ignore
+	// parallel_tool_calls. DroppedToolCalls counts the calls removed.
+	SingleToolCall   bool
+	DroppedToolCalls int
+
+	// Normalizations describes the repairs made to the message history.
+	Normalizations []string
+
+	// CacheBreakpoints snapshots the prompt prefix at each cache_control
+	// marker, in prompt order.
+	CacheBreakpoints []CacheBreakpoint
+}
+
+func NewConversion(caps types.ModelCapabilities) *Conversion {
+	return &Conversion{Capabilities: caps}
+}
+
+func (c *Conversion) downgrade(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	for _, d := range c.Downgrades {
+		if d == msg {
+			return
+		}
+	}
+	c.Downgrades = append(c.Downgrades, msg)
+}
+
+func (c *Conversion) normalized(format string, args ...any) {
+	c.Normalizations = append(c.Normalizations, fmt.Sprintf(format, args...))
+}
+
+// KeepToolCall reports whether another tool call may be returned to the client
+// after seen calls have already been emitted, counting the ones it rejects.
+func (c *Conversion) KeepToolCall(seen int) bool {
+	if c.SingleToolCall && seen >= 1 {
+		c.DroppedToolCalls++
+		return false
+	}
+	return true
+}
+
+func disableParallelToolUse(toolChoice any) bool {
+	m, ok := toolChoice.(map[string]any)
+	if !ok {
+		return false
+	}
+	disabled, _ := m["disable_parallel_tool_use"].(bool)
+	return disabled
+}
+
+func thinkingEnabled(v any) bool {
+	if v == nil {
+		return false
+	}
+	if m, ok := v.(map[string]any); ok {
+		typ, _ := m["type"].(string)
+		return typ != "" && typ != "disabled"
+	}
+	return true
+}
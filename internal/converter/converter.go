@@ -9,13 +9,48 @@ import (
 	"claude-nvidia-proxy/internal/types"
 )
 
-func ConvertAnthropicToOpenAI(req *types.AnthropicMessageRequest) (types.OpenAIChatCompletionRequest, error) {
+func ConvertAnthropicToOpenAI(req *types.AnthropicMessageRequest, conv *Conversion) (types.OpenAIChatCompletionRequest, error) {
+	if conv == nil {
+		conv = NewConversion(types.FullCapabilities())
+	}
+	caps := conv.Capabilities
+	conv.SchemaChanges = nil
+
 	var messages []any
 
-	if sys := strings.TrimSpace(extractSystemText(req.System)); sys != "" {
+	tools := conv.resolveTools(req.Tools)
+	conv.reserveToolNames(tools)
+	conv.SingleToolCall = len(tools) > 0 && disableParallelToolUse(req.ToolChoice)
+
+	systemBlocks := extractSystemBlocks(req.System)
+	conv.CacheBreakpoints = computeCacheBreakpoints(req, systemBlocks)
+
+	sys := strings.TrimSpace(joinTextBlocks(systemBlocks))
+	var systemParts []any
+	for _, blk := range systemBlocks {
+		if blk.Type == "text" && strings.TrimSpace(blk.Text) != "" {
+			systemParts = append(systemParts, map[string]any{"type": "text", "text": blk.Text})
+		}
+	}
+	if len(tools) > 0 && !caps.Tools {
+		conv.EmulateTools = true
+		prompt := renderToolEmulationPrompt(tools, req.ToolChoice)
+		if sys != "" {
+			sys += "\n\n" + prompt
+		} else {
+			sys = prompt
+		}
+		systemParts = append(systemParts, map[string]any{"type": "text", "text": prompt})
+		conv.downgrade("%d tool definition(s) emulated via system prompt", len(tools))
+	}
+	if sys != "" && caps.SystemRole {
+		var content any = sys
+		if caps.SystemBlocks {
+			content = systemParts
+		}
 		messages = append(messages, map[string]any{
 			"role":    "system",
-			"content": sys,
+			"content": content,
 		})
 	}
 
@@ -41,17 +76,17 @@ func ConvertAnthropicToOpenAI(req *types.AnthropicMessageRequest) (types.OpenAIC
 
 		switch role {
 		case "user":
-			userMsgs, err := convertAnthropicUserBlocksToOpenAIMessages(blocks)
+			userMsgs, err := convertAnthropicUserBlocksToOpenAIMessages(blocks, conv)
 			if err != nil {
 				return types.OpenAIChatCompletionRequest{}, err
 			}
 			messages = append(messages, userMsgs...)
 		case "assistant":
-			assistantMsg, err := convertAnthropicAssistantBlocksToOpenAIMessage(blocks)
+			assistantMsgs, err := convertAnthropicAssistantBlocksToOpenAIMessages(blocks, conv)
 			if err != nil {
 				return types.OpenAIChatCompletionRequest{}, err
 			}
-			messages = append(messages, assistantMsg)
+			messages = append(messages, assistantMsgs...)
 		default:
 			text := joinTextBlocks(blocks)
 			messages = append(messages, map[string]any{
@@ -61,6 +96,14 @@ func ConvertAnthropicToOpenAI(req *types.AnthropicMessageRequest) (types.OpenAIC
 		}
 	}
 
+	conv.Normalizations = nil
+	messages = normalizeMessages(messages, conv)
+
+	if sys != "" && !caps.SystemRole {
+		messages = foldSystemIntoFirstUser(messages, sys)
+		conv.downgrade("system prompt folded into first user message")
+	}
+
 	out := types.OpenAIChatCompletionRequest{
 		Model:       req.Model,
 		Messages:    messages,
@@ -69,44 +112,89 @@ func ConvertAnthropicToOpenAI(req *types.AnthropicMessageRequest) (types.OpenAIC
 		Stream:      req.Stream,
 	}
 
-	if len(req.Tools) > 0 {
-		out.Tools = make([]any, 0, len(req.Tools))
-		for _, t := range req.Tools {
+	if len(tools) > 0 && caps.Tools {
+		out.Tools = make([]any, 0, len(tools))
+		for _, t := range tools {
 			var params any
 			if len(t.InputSchema) > 0 {
 				_ = json.Unmarshal(t.InputSchema, &params)
 			}
+			params, changes := SanitizeSchema(t.Name, params, conv.SchemaRules)
+			conv.SchemaChanges = append(conv.SchemaChanges, changes...)
 			out.Tools = append(out.Tools, map[string]any{
 				"type": "function",
 				"function": map[string]any{
-					"name":        t.Name,
+					"name":        conv.UpstreamToolName(t.Name),
 					"description": t.Description,
 					"parameters":  params,
 				},
 			})
 		}
+		if !caps.ParallelTools || conv.SingleToolCall {
+			parallel := false
+			out.ParallelToolCalls = &parallel
+		}
+	}
+
+	if req.ToolChoice != nil && caps.Tools {
+		out.ToolChoice = convertToolChoice(req.ToolChoice, conv)
 	}
 
-	if req.ToolChoice != nil {
-		out.ToolChoice = convertToolChoice(req.ToolChoice)
+	if caps.PromptCacheKey {
+		out.PromptCacheKey = conv.PrefixHash()
+	}
+
+	if thinkingEnabled(req.Thinking) && !caps.Thinking {
+		conv.downgrade("extended thinking not supported; thinking request ignored")
 	}
 
 	return out, nil
 }
 
-func extractSystemText(raw json.RawMessage) string {
+// foldSystemIntoFirstUser prepends the system prompt to the first user
+// message, or inserts a user message when the history has none.
+func foldSystemIntoFirstUser(messages []any, sys string) []any {
+	for i, m := range messages {
+		mm, ok := m.(map[string]any)
+		if !ok || mm["role"] != "user" {
+			continue
+		}
+		switch content := mm["content"].(type) {
+		case string:
+			if content == "" {
+				mm["content"] = sys
+			} else {
+				mm["content"] = sys + "\n\n" + content
+			}
+		case []any:
+			parts := make([]any, 0, len(content)+1)
+			parts = append(parts, map[string]any{"type": "text", "text": sys})
+			mm["content"] = append(parts, content...)
+		default:
+			mm["content"] = sys
+		}
+		messages[i] = mm
+		return messages
+	}
+	return append([]any{map[string]any{"role": "user", "content": sys}}, messages...)
+}
+
+// extractSystemBlocks returns the system prompt as content blocks, keeping
+// their boundaries and cache_control markers. A plain string becomes a single
+// text block.
+func extractSystemBlocks(raw json.RawMessage) []types.AnthropicContentBlock {
 	if len(raw) == 0 {
-		return ""
+		return nil
 	}
 	var s string
 	if err := json.Unmarshal(raw, &s); err == nil {
-		return s
+		return []types.AnthropicContentBlock{{Type: "text", Text: s}}
 	}
 	var blocks []types.AnthropicContentBlock
 	if err := json.Unmarshal(raw, &blocks); err == nil {
-		return joinTextBlocks(blocks)
+		return blocks
 	}
-	return ""
+	return nil
 }
 
 func joinTextBlocks(blocks []types.AnthropicContentBlock) string {
@@ -122,21 +210,21 @@ func joinTextBlocks(blocks []types.AnthropicContentBlock) string {
 	return b.String()
 }
 
-func convertAnthropicUserBlocksToOpenAIMessages(blocks []types.AnthropicContentBlock) ([]any, error) {
+func convertAnthropicUserBlocksToOpenAIMessages(blocks []types.AnthropicContentBlock, conv *Conversion) ([]any, error) {
 	var out []any
+	var parts []any
 
 	for _, blk := range blocks {
 		if blk.Type != "tool_result" || strings.TrimSpace(blk.ToolUseID) == "" {
 			continue
 		}
-		contentStr := ""
-		if len(blk.Content) > 0 {
-			var s string
-			if err := json.Unmarshal(blk.Content, &s); err == nil {
-				contentStr = s
-			} else {
-				contentStr = string(blk.Content)
-			}
+		contentStr := toolResultText(blk.Content)
+		if !conv.Capabilities.Tools {
+			parts = append(parts, map[string]any{
+				"type": "text",
+				"text": renderEmulatedToolResult(blk.ToolUseID, contentStr),
+			})
+			continue
 		}
 		out = append(out, map[string]any{
 			"role":         "tool",
@@ -145,7 +233,6 @@ func convertAnthropicUserBlocksToOpenAIMessages(blocks []types.AnthropicContentB
 		})
 	}
 
-	var parts []any
 	for _, blk := range blocks {
 		switch blk.Type {
 		case "text":
@@ -156,6 +243,11 @@ func convertAnthropicUserBlocksToOpenAIMessages(blocks []types.AnthropicContentB
 			if blk.Source == nil {
 				continue
 			}
+			if !conv.Capabilities.Vision {
+				parts = append(parts, map[string]any{"type": "text", "text": imagePlaceholder})
+				conv.downgrade("image input replaced with text placeholder")
+				continue
+			}
 			url := ""
 			switch blk.Source.Type {
 			case "base64":
@@ -183,7 +275,6 @@ func convertAnthropicUserBlocksToOpenAIMessages(blocks []types.AnthropicContentB
 	}
 
 	if len(parts) == 0 {
-		out = append(out, map[string]any{"role": "user", "content": ""})
 		return out, nil
 	}
 	if len(parts) == 1 {
@@ -202,23 +293,50 @@ func convertAnthropicUserBlocksToOpenAIMessages(blocks []types.AnthropicContentB
 	return out, nil
 }
 
-func convertAnthropicAssistantBlocksToOpenAIMessage(blocks []types.AnthropicContentBlock) (any, error) {
+// convertAnthropicAssistantBlocksToOpenAIMessages returns the assistant
+// message followed by tool messages for any server tool results the proxy
+// produced in that turn.
+func convertAnthropicAssistantBlocksToOpenAIMessages(blocks []types.AnthropicContentBlock, conv *Conversion) ([]any, error) {
 	text := joinTextBlocks(blocks)
 
 	var toolCalls []any
+	var serverResults []any
 	for _, blk := range blocks {
-		if blk.Type != "tool_use" || strings.TrimSpace(blk.ID) == "" || strings.TrimSpace(blk.Name) == "" {
+		if blk.Type == "web_search_tool_result" && strings.TrimSpace(blk.ToolUseID) != "" {
+			result := renderWebSearchToolResult(blk.Content)
+			if !conv.Capabilities.Tools {
+				serverResults = append(serverResults, map[string]any{
+					"role":    "user",
+					"content": renderEmulatedToolResult(blk.ToolUseID, result),
+				})
+				continue
+			}
+			serverResults = append(serverResults, map[string]any{
+				"role":         "tool",
+				"tool_call_id": blk.ToolUseID,
+				"content":      result,
+			})
+			continue
+		}
+		if (blk.Type != "tool_use" && blk.Type != "server_tool_use") || strings.TrimSpace(blk.ID) == "" || strings.TrimSpace(blk.Name) == "" {
 			continue
 		}
 		args := "{}"
 		if len(blk.Input) > 0 {
 			args = string(blk.Input)
 		}
+		if !conv.Capabilities.Tools {
+			if text != "" {
+				text += "\n"
+			}
+			text += renderEmulatedToolCall(blk.ID, blk.Name, blk.Input)
+			continue
+		}
 		toolCalls = append(toolCalls, map[string]any{
 			"id":   blk.ID,
 			"type": "function",
 			"function": map[string]any{
-				"name":      blk.Name,
+				"name":      conv.UpstreamToolName(blk.Name),
 				"arguments": args,
 			},
 		})
@@ -235,10 +353,59 @@ func convertAnthropicAssistantBlocksToOpenAIMessage(blocks []types.AnthropicCont
 	if len(toolCalls) > 0 {
 		msg["tool_calls"] = toolCalls
 	}
-	return msg, nil
+	return append([]any{msg}, serverResults...), nil
 }
 
-func convertToolChoice(v any) any {
+// renderWebSearchToolResult turns web_search_tool_result content back into the
+// text the model originally saw for that search.
+func renderWebSearchToolResult(raw json.RawMessage) string {
+	var results []struct {
+		Type             string `json:"type"`
+		Title            string `json:"title"`
+		URL              string `json:"url"`
+		EncryptedContent string `json:"encrypted_content"`
+		PageAge          string `json:"page_age"`
+	}
+	if err := json.Unmarshal(raw, &results); err != nil {
+		var failure struct {
+			ErrorCode string `json:"error_code"`
+		}
+		if err := json.Unmarshal(raw, &failure); err == nil && failure.ErrorCode != "" {
+			return "web search failed: " + failure.ErrorCode
+		}
+		return toolResultText(raw)
+	}
+	if len(results) == 0 {
+		return "no results"
+	}
+	var b strings.Builder
+	for i, r := range results {
+		if i > 0 {
+			b.WriteString("\n\n")
+		}
+		fmt.Fprintf(&b, "[%d] %s\n%s", i+1, r.Title, r.URL)
+		if r.PageAge != "" {
+			b.WriteString("\n" + r.PageAge)
+		}
+		if r.EncryptedContent != "" {
+			b.WriteString("\n" + r.EncryptedContent)
+		}
+	}
+	return b.String()
+}
+
+func toolResultText(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return string(raw)
+}
+
+func convertToolChoice(v any, conv *Conversion) any {
 	m, ok := v.(map[string]any)
 	if !ok {
 		return v
@@ -247,6 +414,8 @@ func convertToolChoice(v any) any {
 	switch typ {
 	case "auto", "none", "required":
 		return typ
+	case "any":
+		return "required"
 	case "tool":
 		name, _ := m["name"].(string)
 		if name == "" {
@@ -255,7 +424,7 @@ func convertToolChoice(v any) any {
 		return map[string]any{
 			"type": "function",
 			"function": map[string]any{
-				"name": name,
+				"name": conv.UpstreamToolName(name),
 			},
 		}
 	default:
@@ -263,23 +432,58 @@ func convertToolChoice(v any) any {
 	}
 }
 
-func ConvertOpenAIToAnthropic(resp types.OpenAIChatCompletionResponse) types.AnthropicMessageResponse {
+func ConvertOpenAIToAnthropic(resp types.OpenAIChatCompletionResponse, conv *Conversion) types.AnthropicMessageResponse {
+	if conv == nil {
+		conv = NewConversion(types.FullCapabilities())
+	}
 	content := make([]any, 0, 4)
+	toolCalls := 0
 
 	var finishReason string
 	if len(resp.Choices) > 0 {
 		ch := resp.Choices[0]
 		finishReason = ch.FinishReason
-		if ch.Message.Content != nil && *ch.Message.Content != "" {
+		if ch.Message.Content != nil && *ch.Message.Content != "" && conv.EmulateTools {
+			sawCall := false
+			for _, seg := range conv.ParseEmulatedToolCalls(*ch.Message.Content) {
+				if seg.Call != nil {
+					if !conv.KeepToolCall(toolCalls) {
+						continue
+					}
+					toolCalls++
+					sawCall = true
+					content = append(content, map[string]any{
+						"type":  "tool_use",
+						"id":    seg.Call.ID,
+						"name":  seg.Call.Name,
+						"input": seg.Call.Input,
+					})
+				} else if strings.TrimSpace(seg.Text) != "" {
+					content = append(content, map[string]any{
+						"type": "text",
+						"text": seg.Text,
+					})
+				}
+			}
+			if sawCall && finishReason == "stop" {
+				finishReason = "tool_calls"
+			}
+		} else if ch.Message.Content != nil && *ch.Message.Content != "" {
 			content = append(content, map[string]any{
 				"type": "text",
 				"text": *ch.Message.Content,
 			})
 		}
 		if len(ch.Message.ToolCalls) > 0 {
-			for _, tc := range ch.Message.ToolCalls {
+			toolIDs := map[string]bool{}
+			for i, tc := range ch.Message.ToolCalls {
+				if !conv.KeepToolCall(toolCalls) {
+					continue
+				}
+				toolCalls++
 				input := map[string]any{}
 				switch v := tc.Function.Arguments.(type) {
+				case nil:
 				case string:
 					_ = json.Unmarshal([]byte(v), &input)
 				case map[string]any:
@@ -287,28 +491,24 @@ func ConvertOpenAIToAnthropic(resp types.OpenAIChatCompletionResponse) types.Ant
 				default:
 					input = map[string]any{"text": fmt.Sprintf("%v", v)}
 				}
+				if input == nil {
+					input = map[string]any{}
+				}
+				id := strings.TrimSpace(tc.ID)
+				if id == "" || toolIDs[id] {
+					id = NewToolUseID()
+				}
+				toolIDs[id] = true
 				content = append(content, map[string]any{
 					"type":  "tool_use",
-					"id":    tc.ID,
-					"name":  tc.Function.Name,
+					"id":    id,
+					"name":  conv.ResponseToolName(tc.Function.Name, i),
 					"input": input,
 				})
 			}
 		}
 	}
 
-	inputTokens := 0
-	outputTokens := 0
-	cacheRead := 0
-	if resp.Usage != nil {
-		cacheRead = 0
-		if resp.Usage.PromptTokensDetails != nil {
-			cacheRead = resp.Usage.PromptTokensDetails.CachedTokens
-		}
-		inputTokens = resp.Usage.PromptTokens - cacheRead
-		outputTokens = resp.Usage.CompletionTokens
-	}
-
 	return types.AnthropicMessageResponse{
 		ID:           resp.ID,
 		Type:         "message",
@@ -317,12 +517,45 @@ func ConvertOpenAIToAnthropic(resp types.OpenAIChatCompletionResponse) types.Ant
 		Content:      content,
 		StopReason:   MapFinishReason(finishReason),
 		StopSequence: nil,
-		Usage: map[string]any{
-			"input_tokens":            inputTokens,
-			"output_tokens":           outputTokens,
-			"cache_read_input_tokens": cacheRead,
-		},
+		Usage:        ConvertUsage(resp.Usage),
+	}
+}
+
+// ConvertUsage maps OpenAI token usage to Anthropic usage fields. Cached
+// prompt tokens are reported as cache reads and excluded from input_tokens.
+func ConvertUsage(u *types.OpenAIUsage) map[string]any {
+	inputTokens := 0
+	outputTokens := 0
+	cacheRead := 0
+	if u != nil {
+		if u.PromptTokensDetails != nil {
+			cacheRead = u.PromptTokensDetails.CachedTokens
+		}
+		inputTokens = u.PromptTokens - cacheRead
+		outputTokens = u.CompletionTokens
+	}
+	return map[string]any{
+		"input_tokens":                inputTokens,
+		"output_tokens":               outputTokens,
+		"cache_read_input_tokens":     cacheRead,
+		"cache_creation_input_tokens": 0,
+	}
+}
+
+// ApplyEmulatedCacheUsage moves estimated cache read and creation tokens out
+// of input_tokens when the upstream reported no cached tokens itself. The
+// estimates are capped at the prompt size the upstream did report.
+func ApplyEmulatedCacheUsage(usage map[string]any, read, creation int) bool {
+	if reported, _ := usage["cache_read_input_tokens"].(int); reported > 0 {
+		return false
 	}
+	input, _ := usage["input_tokens"].(int)
+	read = min(read, input)
+	creation = min(creation, input-read)
+	usage["input_tokens"] = input - read - creation
+	usage["cache_read_input_tokens"] = read
+	usage["cache_creation_input_tokens"] = creation
+	return true
 }
 
 func MapFinishReason(finish string) string {
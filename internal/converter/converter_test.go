@@ -0,0 +1,200 @@
+package converter
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"claude-nvidia-proxy/internal/types"
+)
+
+// convert decodes an Anthropic request and converts it with caps, failing
+// the test on any error.
+func convert(t *testing.T, caps types.ModelCapabilities, body string) (types.OpenAIChatCompletionRequest, *Conversion) {
+	t.Helper()
+	var req types.AnthropicMessageRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+	conv := NewConversion(caps)
+	out, err := ConvertAnthropicToOpenAI(&req, conv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out, conv
+}
+
+// roundTrip marshals v and decodes it into generic JSON values.
+func roundTrip(t *testing.T, v any) any {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out any
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestConvertDegradesPerCapabilities(t *testing.T) {
+	const image = `{"type":"image","source":{"type":"base64","media_type":"image/png","data":"iVBORw0KGgo="}}`
+	without := func(edit func(*types.ModelCapabilities)) types.ModelCapabilities {
+		caps := types.FullCapabilities()
+		edit(&caps)
+		return caps
+	}
+	tests := []struct {
+		name          string
+		caps          types.ModelCapabilities
+		body          string
+		wantMessages  string
+		wantDowngrade string
+	}{
+		{
+			name:         "full capabilities",
+			caps:         types.FullCapabilities(),
+			body:         `{"model":"m","system":"Be brief.","messages":[{"role":"user","content":[{"type":"text","text":"what is this?"},` + image + `]}]}`,
+			wantMessages: `[{"role":"system","content":"Be brief."},{"role":"user","content":[{"type":"text","text":"what is this?"},{"type":"image_url","image_url":{"url":"data:image/png;base64,iVBORw0KGgo="}}]}]`,
+		},
+		{
+			name:          "no vision",
+			caps:          without(func(c *types.ModelCapabilities) { c.Vision = false }),
+			body:          `{"model":"m","messages":[{"role":"user","content":[{"type":"text","text":"what is this?"},` + image + `]}]}`,
+			wantMessages:  `[{"role":"user","content":[{"type":"text","text":"what is this?"},{"type":"text","text":"` + imagePlaceholder + `"}]}]`,
+			wantDowngrade: "image input replaced with text placeholder",
+		},
+		{
+			name:          "no system role",
+			caps:          without(func(c *types.ModelCapabilities) { c.SystemRole = false }),
+			body:          `{"model":"m","system":"Be brief.","messages":[{"role":"user","content":"hi"}]}`,
+			wantMessages:  `[{"role":"user","content":"Be brief.\n\nhi"}]`,
+			wantDowngrade: "system prompt folded into first user message",
+		},
+		{
+			name:          "no system role without a user message",
+			caps:          without(func(c *types.ModelCapabilities) { c.SystemRole = false }),
+			body:          `{"model":"m","system":"Be brief.","messages":[{"role":"assistant","content":"ok"}]}`,
+			wantMessages:  `[{"role":"user","content":"Be brief."},{"role":"assistant","content":"ok"}]`,
+			wantDowngrade: "system prompt folded into first user message",
+		},
+		{
+			name:          "no thinking",
+			caps:          without(func(c *types.ModelCapabilities) { c.Thinking = false }),
+			body:          `{"model":"m","thinking":{"type":"enabled","budget_tokens":1024},"messages":[{"role":"user","content":"hi"}]}`,
+			wantMessages:  `[{"role":"user","content":"hi"}]`,
+			wantDowngrade: "extended thinking not supported; thinking request ignored",
+		},
+		{
+			name:         "thinking disabled needs no downgrade",
+			caps:         without(func(c *types.ModelCapabilities) { c.Thinking = false }),
+			body:         `{"model":"m","thinking":{"type":"disabled"},"messages":[{"role":"user","content":"hi"}]}`,
+			wantMessages: `[{"role":"user","content":"hi"}]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, conv := convert(t, tt.caps, tt.body)
+			var want any
+			if err := json.Unmarshal([]byte(tt.wantMessages), &want); err != nil {
+				t.Fatal(err)
+			}
+			if got := roundTrip(t, out.Messages); !reflect.DeepEqual(got, want) {
+				t.Errorf("messages\n got: %v\nwant: %v", got, want)
+			}
+			var wantDowngrades []string
+			if tt.wantDowngrade != "" {
+				wantDowngrades = []string{tt.wantDowngrade}
+			}
+			if !reflect.DeepEqual(conv.Downgrades, wantDowngrades) {
+				t.Errorf("downgrades = %q, want %q", conv.Downgrades, wantDowngrades)
+			}
+		})
+	}
+}
+
+func TestConvertParallelToolsCapability(t *testing.T) {
+	const body = `{"model":"m","messages":[{"role":"user","content":"hi"}],"tools":[{"name":"get_time","input_schema":{"type":"object"}}]}`
+	out, _ := convert(t, types.FullCapabilities(), body)
+	if out.ParallelToolCalls != nil {
+		t.Errorf("parallel_tool_calls = %v with parallel tool support", *out.ParallelToolCalls)
+	}
+
+	caps := types.FullCapabilities()
+	caps.ParallelTools = false
+	out, _ = convert(t, caps, body)
+	if out.ParallelToolCalls == nil || *out.ParallelToolCalls {
+		t.Errorf("parallel_tool_calls = %v, want false", out.ParallelToolCalls)
+	}
+	if len(out.Tools) != 1 {
+		t.Errorf("tools = %v", out.Tools)
+	}
+}
+
+func TestConvertRepeatedImageDowngradeRecordedOnce(t *testing.T) {
+	const image = `{"type":"image","source":{"type":"url","url":"https://example.com/a.png"}}`
+	caps := types.FullCapabilities()
+	caps.Vision = false
+	_, conv := convert(t, caps, `{"model":"m","messages":[{"role":"user","content":[`+image+`,`+image+`]}]}`)
+	if len(conv.Downgrades) != 1 || !strings.HasPrefix(conv.Downgrades[0], "image input") {
+		t.Errorf("downgrades = %q", conv.Downgrades)
+	}
+}
+
+func TestConvertOpenAIToAnthropicToolCalls(t *testing.T) {
+	var resp types.OpenAIChatCompletionResponse
+	if err := json.Unmarshal([]byte(`{"choices":[{"finish_reason":"tool_calls","message":{"role":"assistant","tool_calls":[
+		{"id":"call_a","type":"function","function":{"name":"f","arguments":"{\"n\":1}"}},
+		{"id":"call_a","type":"function","function":{"name":"f","arguments":{"n":2}}},
+		{"id":" ","type":"function","function":{"name":"f","arguments":null}},
+		{"type":"function","function":{"name":"f","arguments":"null"}},
+		{"id":"call_e","type":"function","function":{"name":"f","arguments":"{\"n\":"}},
+		{"id":"call_f","type":"function","function":{"name":"f"}}]}}]}`), &resp); err != nil {
+		t.Fatal(err)
+	}
+	out := ConvertOpenAIToAnthropic(resp, NewConversion(types.FullCapabilities()))
+
+	wantInputs := []any{
+		map[string]any{"n": 1.0},
+		map[string]any{"n": 2.0},
+		map[string]any{},
+		map[string]any{},
+		map[string]any{},
+		map[string]any{},
+	}
+	if len(out.Content) != len(wantInputs) {
+		t.Fatalf("content = %v", out.Content)
+	}
+	seen := map[string]bool{}
+	for i, c := range out.Content {
+		b := c.(map[string]any)
+		id, _ := b["id"].(string)
+		if seen[id] {
+			t.Errorf("block %d reuses id %q", i, id)
+		}
+		seen[id] = true
+		if got := roundTrip(t, b["input"]); !reflect.DeepEqual(got, wantInputs[i]) {
+			t.Errorf("block %d input = %v, want %v", i, got, wantInputs[i])
+		}
+	}
+	wantIDs := []string{"call_a", GeneratedToolIDPrefix, GeneratedToolIDPrefix, GeneratedToolIDPrefix, "call_e", "call_f"}
+	for i, want := range wantIDs {
+		id := out.Content[i].(map[string]any)["id"].(string)
+		if want == GeneratedToolIDPrefix && !strings.HasPrefix(id, want) || want != GeneratedToolIDPrefix && id != want {
+			t.Errorf("block %d id = %q, want %q", i, id, want)
+		}
+	}
+}
+
+func TestNewToolUseID(t *testing.T) {
+	seen := map[string]bool{}
+	for range 1000 {
+		id := NewToolUseID()
+		if !strings.HasPrefix(id, GeneratedToolIDPrefix) || seen[id] {
+			t.Fatalf("id %q repeated or unprefixed", id)
+		}
+		seen[id] = true
+	}
+}
@@ -0,0 +1,217 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"claude-nvidia-proxy/internal/types"
+)
+
+const (
+	toolCallOpenTag  = "<tool_call>"
+	toolCallCloseTag = "</tool_call>"
+)
+
+// EmulatedToolCall is a tool call parsed from model text in tool emulation mode.
+type EmulatedToolCall struct {
+	ID    string
+	Name  string
+	Input json.RawMessage
+}
+
+// EmulatedSegment is either plain text or a parsed tool call.
+type EmulatedSegment struct {
+	Text string
+	Call *EmulatedToolCall
+}
+
+// renderToolEmulationPrompt describes the tools and the strict call format the
+// model must use when the upstream has no native function calling.
+func renderToolEmulationPrompt(tools []types.AnthropicTool, toolChoice any) string {
+	var b strings.Builder
+	b.WriteString("# Tools\n\n")
+	b.WriteString("You have access to the tools listed below. To call a tool, reply with one block per call in exactly this format and nothing else inside the block:\n\n")
+	b.WriteString(toolCallOpenTag + "\n{\"name\": \"<tool name>\", \"input\": {<arguments matching the tool's input schema>}}\n" + toolCallCloseTag + "\n\n")
+	b.WriteString("After your tool calls, stop and wait. Results arrive in the next user message as <tool_result> blocks. Never write a <tool_result> block yourself.\n\n")
+	b.WriteString("Earlier calls in the conversation carry an \"id\" assigned by the system, and each <tool_result> names the call it answers with a matching tool_use_id attribute. Leave \"id\" out of new calls; one is assigned for you.\n\n")
+	b.WriteString("Available tools:\n")
+	for _, t := range tools {
+		b.WriteString("\n## " + t.Name + "\n")
+		if desc := strings.TrimSpace(t.Description); desc != "" {
+			b.WriteString(desc + "\n")
+		}
+		if len(t.InputSchema) > 0 {
+			b.WriteString("Input schema: " + compactJSON(t.InputSchema) + "\n")
+		}
+	}
+
+	if m, ok := toolChoice.(map[string]any); ok {
+		switch m["type"] {
+		case "any":
+			b.WriteString("\nYou must call at least one tool in your reply.\n")
+		case "tool":
+			if name, _ := m["name"].(string); name != "" {
+				b.WriteString("\nYou must call the tool \"" + name + "\" in your reply.\n")
+			}
+		case "none":
+			b.WriteString("\nDo not call any tools in your reply.\n")
+		}
+	}
+	if disableParallelToolUse(toolChoice) {
+		b.WriteString("\nCall at most one tool per reply.\n")
+	}
+	return b.String()
+}
+
+// renderEmulatedToolCall renders a tool call from the history with its id,
+// so the model can pair it with the <tool_result> that answers it.
+func renderEmulatedToolCall(id, name string, input json.RawMessage) string {
+	in := "{}"
+	if len(input) > 0 {
+		in = compactJSON(input)
+	}
+	idJSON, _ := json.Marshal(id)
+	nameJSON, _ := json.Marshal(name)
+	return toolCallOpenTag + "\n{\"id\": " + string(idJSON) + ", \"name\": " + string(nameJSON) + ", \"input\": " + in + "}\n" + toolCallCloseTag
+}
+
+func renderEmulatedToolResult(toolUseID, content string) string {
+	return fmt.Sprintf("<tool_result tool_use_id=%q>\n%s\n</tool_result>", toolUseID, content)
+}
+
+func compactJSON(raw json.RawMessage) string {
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return string(raw)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return string(raw)
+	}
+	return string(b)
+}
+
+// ParseEmulatedToolCalls splits a complete model reply into text and tool calls.
+func (c *Conversion) ParseEmulatedToolCalls(text string) []EmulatedSegment {
+	s := c.NewToolCallScanner()
+	return append(s.Feed(text), s.Flush()...)
+}
+
+// ToolCallScanner incrementally extracts emulated tool calls from streamed
+// text, holding back any suffix that might be the start of a call block.
+type ToolCallScanner struct {
+	conv   *Conversion
+	buf    strings.Builder
+	inCall bool
+	// afterCall drops the newline that follows a closing tag, even when it
+	// arrives in a later fragment.
+	afterCall bool
+}
+
+func (c *Conversion) NewToolCallScanner() *ToolCallScanner {
+	return &ToolCallScanner{conv: c}
+}
+
+// Feed consumes the next text fragment and returns the segments that are complete.
+func (s *ToolCallScanner) Feed(text string) []EmulatedSegment {
+	s.buf.WriteString(text)
+	pending := s.buf.String()
+	var out []EmulatedSegment
+	if s.afterCall && pending != "" {
+		pending = strings.TrimPrefix(pending, "\n")
+		s.afterCall = false
+	}
+
+	for {
+		if !s.inCall {
+			i := strings.Index(pending, toolCallOpenTag)
+			if i < 0 {
+				keep := partialTagSuffix(pending, toolCallOpenTag)
+				if emit := pending[:len(pending)-keep]; emit != "" {
+					out = append(out, EmulatedSegment{Text: emit})
+				}
+				pending = pending[len(pending)-keep:]
+				break
+			}
+			if i > 0 {
+				out = append(out, EmulatedSegment{Text: pending[:i]})
+			}
+			pending = pending[i+len(toolCallOpenTag):]
+			s.inCall = true
+			continue
+		}
+
+		j := strings.Index(pending, toolCallCloseTag)
+		if j < 0 {
+			break
+		}
+		out = append(out, s.conv.parseCallBody(pending[:j]))
+		pending = pending[j+len(toolCallCloseTag):]
+		s.inCall = false
+		if pending == "" {
+			s.afterCall = true
+		}
+		pending = strings.TrimPrefix(pending, "\n")
+	}
+
+	s.buf.Reset()
+	s.buf.WriteString(pending)
+	return out
+}
+
+// Flush returns whatever is still buffered once the stream has ended. An
+// unterminated call block is still parsed, since models often stop right
+// before the closing tag.
+func (s *ToolCallScanner) Flush() []EmulatedSegment {
+	pending := s.buf.String()
+	s.buf.Reset()
+	s.afterCall = false
+	if !s.inCall {
+		if pending == "" {
+			return nil
+		}
+		return []EmulatedSegment{{Text: pending}}
+	}
+	s.inCall = false
+	return []EmulatedSegment{s.conv.parseCallBody(pending)}
+}
+
+func (c *Conversion) parseCallBody(body string) EmulatedSegment {
+	var call struct {
+		Name  string          `json:"name"`
+		Input json.RawMessage `json:"input"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(body)), &call); err != nil || strings.TrimSpace(call.Name) == "" {
+		return EmulatedSegment{Text: toolCallOpenTag + body + toolCallCloseTag}
+	}
+	input := call.Input
+	if len(input) == 0 || string(input) == "null" {
+		input = json.RawMessage("{}")
+	}
+	var obj map[string]any
+	if json.Unmarshal(input, &obj) != nil {
+		// tool_use input must be an object.
+		return EmulatedSegment{Text: toolCallOpenTag + body + toolCallCloseTag}
+	}
+	return EmulatedSegment{Call: &EmulatedToolCall{
+		ID:    NewToolUseID(),
+		Name:  strings.TrimSpace(call.Name),
+		Input: input,
+	}}
+}
+
+// partialTagSuffix returns the length of the longest suffix of s that is a
+// proper prefix of tag.
+func partialTagSuffix(s, tag string) int {
+	n := len(tag) - 1
+	if n > len(s) {
+		n = len(s)
+	}
+	for ; n > 0; n-- {
+		if strings.HasSuffix(s, tag[:n]) {
+			return n
+		}
+	}
+	return 0
+}
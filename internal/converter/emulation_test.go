@@ -0,0 +1,184 @@
+package converter
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"claude-nvidia-proxy/internal/types"
+)
+
+func TestParseEmulatedToolCalls(t *testing.T) {
+	type seg struct {
+		text, name, input string
+	}
+	tests := []struct {
+		name  string
+		reply string
+		want  []seg
+	}{
+		{
+			name:  "plain text",
+			reply: "No tools needed.",
+			want:  []seg{{text: "No tools needed."}},
+		},
+		{
+			name:  "text then call",
+			reply: "Reading it.\n<tool_call>\n{\"name\": \"Read\", \"input\": {\"file_path\": \"a.go\"}}\n</tool_call>\n",
+			want:  []seg{{text: "Reading it.\n"}, {name: "Read", input: `{"file_path": "a.go"}`}},
+		},
+		{
+			name:  "two calls",
+			reply: "<tool_call>{\"name\":\"a\",\"input\":{}}</tool_call><tool_call>{\"name\":\"b\"}</tool_call>",
+			want:  []seg{{name: "a", input: "{}"}, {name: "b", input: "{}"}},
+		},
+		{
+			name:  "unterminated call at the end",
+			reply: "<tool_call>\n{\"name\": \"Bash\", \"input\": {\"command\": \"ls\"}}\n",
+			want:  []seg{{name: "Bash", input: `{"command": "ls"}`}},
+		},
+		{
+			name:  "invalid body kept as text",
+			reply: "<tool_call>not json</tool_call>",
+			want:  []seg{{text: "<tool_call>not json</tool_call>"}},
+		},
+		{
+			name:  "missing name kept as text",
+			reply: "<tool_call>{\"input\":{}}</tool_call>",
+			want:  []seg{{text: "<tool_call>{\"input\":{}}</tool_call>"}},
+		},
+		{
+			name:  "non-object input kept as text",
+			reply: "<tool_call>{\"name\":\"a\",\"input\":[1]}</tool_call>",
+			want:  []seg{{text: "<tool_call>{\"name\":\"a\",\"input\":[1]}</tool_call>"}},
+		},
+		{
+			name:  "string input kept as text",
+			reply: "<tool_call>{\"name\":\"a\",\"input\":\"ls\"}</tool_call>",
+			want:  []seg{{text: "<tool_call>{\"name\":\"a\",\"input\":\"ls\"}</tool_call>"}},
+		},
+		{
+			name:  "number input kept as text",
+			reply: "<tool_call>{\"name\":\"a\",\"input\":3}</tool_call>",
+			want:  []seg{{text: "<tool_call>{\"name\":\"a\",\"input\":3}</tool_call>"}},
+		},
+		{
+			name:  "null input becomes an empty object",
+			reply: "<tool_call>{\"name\":\"a\",\"input\":null}</tool_call>",
+			want:  []seg{{name: "a", input: "{}"}},
+		},
+		{
+			name:  "model-written id ignored",
+			reply: "<tool_call>{\"id\":\"toolu_1\",\"name\":\"a\",\"input\":{\"x\":1}}</tool_call>",
+			want:  []seg{{name: "a", input: `{"x":1}`}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conv := NewConversion(types.FullCapabilities())
+			var got []seg
+			ids := map[string]bool{}
+			for _, s := range conv.ParseEmulatedToolCalls(tt.reply) {
+				if s.Call == nil {
+					got = append(got, seg{text: s.Text})
+					continue
+				}
+				if !strings.HasPrefix(s.Call.ID, GeneratedToolIDPrefix) || ids[s.Call.ID] {
+					t.Errorf("call id %q is not generated or repeated", s.Call.ID)
+				}
+				ids[s.Call.ID] = true
+				got = append(got, seg{name: s.Call.Name, input: string(s.Call.Input)})
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("segments = %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("segment %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// Splitting a reply at every byte must yield the same segments as parsing
+// it whole, once adjacent text segments are merged.
+func TestToolCallScannerFragments(t *testing.T) {
+	const reply = "Let me check.<tool_call>\n{\"name\": \"Read\", \"input\": {\"p\": \"</tool\"}}\n</tool_call>\nDone <tool_"
+	conv := NewConversion(types.FullCapabilities())
+	s := conv.NewToolCallScanner()
+	var segs []EmulatedSegment
+	for i := range reply {
+		segs = append(segs, s.Feed(reply[i:i+1])...)
+	}
+	segs = append(segs, s.Flush()...)
+
+	var text strings.Builder
+	var calls []string
+	for _, seg := range segs {
+		if seg.Call != nil {
+			calls = append(calls, seg.Call.Name+" "+string(seg.Call.Input))
+			continue
+		}
+		text.WriteString(seg.Text)
+	}
+	if got, want := text.String(), "Let me check.Done <tool_"; got != want {
+		t.Errorf("text = %q, want %q", got, want)
+	}
+	if len(calls) != 1 || calls[0] != `Read {"p": "</tool"}` {
+		t.Errorf("calls = %q", calls)
+	}
+}
+
+// Emulated history pairs each rendered call with its result by id.
+func TestEmulatedHistoryCarriesToolUseIDs(t *testing.T) {
+	caps := types.FullCapabilities()
+	caps.Tools = false
+	out, conv := convert(t, caps, `{"model":"m","tools":[{"name":"Read","input_schema":{"type":"object"}}],"messages":[
+		{"role":"user","content":"read both"},
+		{"role":"assistant","content":[
+			{"type":"tool_use","id":"toolu_a","name":"Read","input":{"file_path":"a.go"}},
+			{"type":"tool_use","id":"toolu_b","name":"Read","input":{"file_path":"b.go"}}]},
+		{"role":"user","content":[
+			{"type":"tool_result","tool_use_id":"toolu_b","content":"package b"},
+			{"type":"tool_result","tool_use_id":"toolu_a","content":"package a"}]}]}`)
+	if !conv.EmulateTools {
+		t.Fatal("tools were not emulated")
+	}
+	b, _ := json.Marshal(out.Messages)
+	for _, want := range []string{
+		`{\"id\": \"toolu_a\", \"name\": \"Read\", \"input\": {\"file_path\":\"a.go\"}}`,
+		`{\"id\": \"toolu_b\", \"name\": \"Read\", \"input\": {\"file_path\":\"b.go\"}}`,
+		`\u003ctool_result tool_use_id=\"toolu_b\"\u003e\npackage b`,
+		`\u003ctool_result tool_use_id=\"toolu_a\"\u003e\npackage a`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("messages do not contain %s:\n%s", want, b)
+		}
+	}
+
+	system, _ := out.Messages[0].(map[string]any)["content"].(string)
+	for _, want := range []string{"## Read", "tool_use_id", `Leave "id" out of new calls`} {
+		if !strings.Contains(system, want) {
+			t.Errorf("emulation prompt does not contain %q:\n%s", want, system)
+		}
+	}
+}
+
+func TestToolEmulationPromptToolChoice(t *testing.T) {
+	tools := []types.AnthropicTool{{Name: "a"}}
+	tests := []struct {
+		choice any
+		want   string
+	}{
+		{map[string]any{"type": "any"}, "You must call at least one tool"},
+		{map[string]any{"type": "tool", "name": "a"}, `You must call the tool "a"`},
+		{map[string]any{"type": "none"}, "Do not call any tools"},
+		{map[string]any{"type": "auto", "disable_parallel_tool_use": true}, "Call at most one tool per reply"},
+	}
+	for _, tt := range tests {
+		if got := renderToolEmulationPrompt(tools, tt.choice); !strings.Contains(got, tt.want) {
+			t.Errorf("tool_choice %v: prompt does not contain %q", tt.choice, tt.want)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package converter
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"claude-nvidia-proxy/internal/types"
+)
+
+// The fuzz targets are seeded with requests and responses shaped like real
+// Claude Code traffic from testdata/claude_code. Run one with
+//
+//	go test ./internal/converter -run '^$' -fuzz FuzzConvertAnthropicToOpenAI
+
+// addSeeds adds every testdata/claude_code file matching pattern to f.
+func addSeeds(f *testing.F, pattern string) {
+	files, err := filepath.Glob(filepath.Join("testdata", "claude_code", pattern))
+	if err != nil || len(files) == 0 {
+		f.Fatalf("no seed files for %s", pattern)
+	}
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b)
+	}
+}
+
+// fuzzCapabilities covers native tools and the emulated, folded-system path.
+var fuzzCapabilities = []types.ModelCapabilities{
+	types.FullCapabilities(),
+	{Vision: false, Tools: false, SystemRole: false},
+}
+
+func FuzzConvertAnthropicToOpenAI(f *testing.F) {
+	addSeeds(f, "request_*.json")
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, caps := range fuzzCapabilities {
+			var req types.AnthropicMessageRequest
+			if err := json.Unmarshal(data, &req); err != nil {
+				return
+			}
+			conv := NewConversion(caps)
+			out, err := ConvertAnthropicToOpenAI(&req, conv)
+			if err != nil {
+				continue
+			}
+			b, err := json.Marshal(out)
+			if err != nil || !json.Valid(b) {
+				t.Fatalf("converted request does not marshal: %v", err)
+			}
+			checkToolNames(t, &req, conv, out)
+			checkToolIDs(t, &req, caps, out)
+		}
+	})
+}
+
+// checkToolNames requires every declared tool name to survive the trip to the
+// upstream pattern and back.
+func checkToolNames(t *testing.T, req *types.AnthropicMessageRequest, conv *Conversion, out types.OpenAIChatCompletionRequest) {
+	t.Helper()
+	for _, tool := range out.Tools {
+		fn, _ := tool.(map[string]any)["function"].(map[string]any)
+		if name, _ := fn["name"].(string); !validUpstreamToolName.MatchString(name) {
+			t.Errorf("upstream tool name %q is not valid", name)
+		}
+	}
+	for _, tool := range req.Tools {
+		if got := conv.OriginalToolName(conv.UpstreamToolName(tool.Name)); got != tool.Name {
+			t.Errorf("tool name %q round-trips to %q", tool.Name, got)
+		}
+	}
+}
+
+// checkToolIDs requires every tool_use id in the history to reach the
+// upstream unchanged, each tool call to be answered by a tool message, and
+// every tool message to answer a call.
+func checkToolIDs(t *testing.T, req *types.AnthropicMessageRequest, caps types.ModelCapabilities, out types.OpenAIChatCompletionRequest) {
+	t.Helper()
+	sent := map[string]bool{}
+	pending := map[string]bool{}
+	for i, m := range out.Messages {
+		mm, ok := m.(map[string]any)
+		if !ok {
+			t.Fatalf("message %d is %T", i, m)
+		}
+		if mm["role"] == "tool" {
+			id, _ := mm["tool_call_id"].(string)
+			if !pending[id] {
+				t.Errorf("message %d answers tool call %q, which is not pending", i, id)
+			}
+			delete(pending, id)
+			continue
+		}
+		if len(pending) > 0 {
+			t.Errorf("message %d (%v) follows unanswered tool calls %v", i, mm["role"], pending)
+			pending = map[string]bool{}
+		}
+		for _, id := range orderedToolCallIDs(mm) {
+			sent[id] = true
+			pending[id] = true
+		}
+	}
+	if len(pending) > 0 {
+		t.Errorf("history ends with unanswered tool calls %v", pending)
+	}
+	if !caps.Tools {
+		if len(sent) > 0 {
+			t.Errorf("tool calls %v sent to a model without tool support", sent)
+		}
+		return
+	}
+	for _, m := range req.Messages {
+		if m.Role != "assistant" {
+			continue
+		}
+		var blocks []types.AnthropicContentBlock
+		if json.Unmarshal(m.Content, &blocks) != nil {
+			continue
+		}
+		for _, blk := range blocks {
+			if blk.Type == "tool_use" && strings.TrimSpace(blk.ID) != "" && strings.TrimSpace(blk.Name) != "" && !sent[blk.ID] {
+				t.Errorf("tool_use id %q was not sent upstream", blk.ID)
+			}
+		}
+	}
+}
+
+func FuzzConvertOpenAIToAnthropic(f *testing.F) {
+	addSeeds(f, "response_*.json")
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, emulate := range []bool{false, true} {
+			var resp types.OpenAIChatCompletionResponse
+			if err := json.Unmarshal(data, &resp); err != nil {
+				return
+			}
+			conv := NewConversion(types.FullCapabilities())
+			conv.EmulateTools = emulate
+			for _, name := range []string{"mcp__github__create_pull_request_review_comment_with_suggested_changes", "mcp__my.server__search docs"} {
+				conv.UpstreamToolName(name)
+			}
+			checkResponse(t, resp, conv)
+		}
+	})
+}
+
+// checkResponse converts resp and requires valid JSON, a known stop reason,
+// object tool inputs and unique tool_use ids matching the upstream's. Native
+// tool calls come last, after text and emulated calls parsed from it.
+func checkResponse(t *testing.T, resp types.OpenAIChatCompletionResponse, conv *Conversion) {
+	t.Helper()
+	type upstreamCall struct{ id, name string }
+	var calls []upstreamCall
+	if len(resp.Choices) > 0 {
+		for _, tc := range resp.Choices[0].Message.ToolCalls {
+			calls = append(calls, upstreamCall{tc.ID, tc.Function.Name})
+		}
+	}
+	out := ConvertOpenAIToAnthropic(resp, conv)
+
+	b, err := json.Marshal(out)
+	if err != nil || !json.Valid(b) {
+		t.Fatalf("response does not marshal: %v", err)
+	}
+	var decoded struct {
+		Content []struct {
+			Type  string          `json:"type"`
+			ID    string          `json:"id"`
+			Name  string          `json:"name"`
+			Input json.RawMessage `json:"input"`
+		} `json:"content"`
+	}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !validStopReason[out.StopReason] {
+		t.Errorf("stop_reason %q", out.StopReason)
+	}
+
+	native := len(decoded.Content) - len(calls)
+	if native < 0 {
+		t.Fatalf("%d content blocks for %d upstream tool calls", len(decoded.Content), len(calls))
+	}
+	ids := map[string]bool{}
+	for i, c := range decoded.Content {
+		switch c.Type {
+		case "text":
+			if i >= native {
+				t.Fatalf("text block %d among the native tool calls", i)
+			}
+		case "tool_use":
+			if i < native && !conv.EmulateTools {
+				t.Fatalf("tool_use block %d without an upstream tool call", i)
+			}
+			if i >= native {
+				tc := calls[i-native]
+				if id := strings.TrimSpace(tc.id); id != "" && !ids[id] && c.ID != id {
+					t.Errorf("tool_use id %q, upstream sent %q", c.ID, id)
+				}
+				if want := conv.OriginalToolName(strings.TrimSpace(tc.name)); want != "" && c.Name != want {
+					t.Errorf("tool_use name %q, want %q", c.Name, want)
+				}
+			}
+			if ids[c.ID] {
+				t.Errorf("duplicate tool_use id %q", c.ID)
+			}
+			ids[c.ID] = true
+			if c.ID == "" || c.Name == "" {
+				t.Errorf("tool_use block without id or name: %+v", c)
+			}
+			var input map[string]any
+			if json.Unmarshal(c.Input, &input) != nil || input == nil {
+				t.Errorf("tool_use input %s is not an object", c.Input)
+			}
+		default:
+			t.Errorf("unexpected content block type %q", c.Type)
+		}
+	}
+}
+
+var validStopReason = map[string]bool{"end_turn": true, "max_tokens": true, "stop_sequence": true, "tool_use": true}
@@ -0,0 +1,205 @@
+package converter
+
+import "fmt"
+
+// missingToolResult is sent for tool calls the client never answered.
+const missingToolResult = "[no result was provided for this tool call]"
+
+// normalizeMessages makes the converted history acceptable to strict
+// upstreams: empty messages are dropped, adjacent user/assistant/system turns
+// are merged, every tool call gets a tool message and tool messages without a
+// matching call are turned into user text.
+func normalizeMessages(messages []any, conv *Conversion) []any {
+	dropped, merged, synthesized, orphaned := 0, 0, 0, 0
+
+	var compact []map[string]any
+	for _, m := range messages {
+		mm, ok := m.(map[string]any)
+		if !ok {
+			continue
+		}
+		if isEmptyMessage(mm) {
+			dropped++
+			continue
+		}
+		if n := len(compact); n > 0 && mergeable(compact[n-1], mm) {
+			compact[n-1] = mergeMessages(compact[n-1], mm)
+			merged++
+			continue
+		}
+		compact = append(compact, mm)
+	}
+
+	out := make([]any, 0, len(compact))
+	for i := 0; i < len(compact); i++ {
+		mm := compact[i]
+		if mm["role"] == "tool" {
+			// Reached only when no preceding assistant message claimed it.
+			orphaned++
+			out = appendUserText(out, fmt.Sprintf("[tool result for %v]\n%v", mm["tool_call_id"], mm["content"]))
+			continue
+		}
+		if n := len(out); n > 0 && mm["role"] == "user" {
+			// An orphaned tool result may already have opened a user turn.
+			if prev, ok := out[n-1].(map[string]any); ok && prev["role"] == "user" {
+				out[n-1] = mergeMessages(prev, mm)
+				continue
+			}
+		}
+		out = append(out, mm)
+
+		calls := toolCallIDs(mm)
+		if len(calls) == 0 {
+			continue
+		}
+		answered := map[string]bool{}
+		var orphanTexts []string
+		for i+1 < len(compact) && compact[i+1]["role"] == "tool" {
+			i++
+			id, _ := compact[i]["tool_call_id"].(string)
+			if !calls[id] || answered[id] {
+				orphaned++
+				orphanTexts = append(orphanTexts, fmt.Sprintf("[tool result for %s]\n%v", id, compact[i]["content"]))
+				continue
+			}
+			answered[id] = true
+			out = append(out, compact[i])
+		}
+		for _, id := range orderedToolCallIDs(mm) {
+			if !answered[id] {
+				synthesized++
+				out = append(out, map[string]any{
+					"role":         "tool",
+					"tool_call_id": id,
+					"content":      missingToolResult,
+				})
+			}
+		}
+		for _, text := range orphanTexts {
+			out = appendUserText(out, text)
+		}
+	}
+
+	if dropped > 0 {
+		conv.normalized("dropped %d empty message(s)", dropped)
+	}
+	if merged > 0 {
+		conv.normalized("merged %d adjacent same-role message(s)", merged)
+	}
+	if synthesized > 0 {
+		conv.normalized("synthesized %d missing tool result(s)", synthesized)
+	}
+	if orphaned > 0 {
+		conv.normalized("converted %d orphaned tool result(s) to user text", orphaned)
+	}
+	return out
+}
+
+func isEmptyMessage(m map[string]any) bool {
+	if m["role"] == "tool" {
+		return false
+	}
+	if calls, ok := m["tool_calls"].([]any); ok && len(calls) > 0 {
+		return false
+	}
+	switch c := m["content"].(type) {
+	case nil:
+		return true
+	case string:
+		return c == ""
+	case []any:
+		return len(c) == 0
+	}
+	return false
+}
+
+// mergeable reports whether next can be folded into prev. Tool messages are
+// never merged since each answers exactly one tool call.
+func mergeable(prev, next map[string]any) bool {
+	if prev["role"] != next["role"] {
+		return false
+	}
+	switch prev["role"] {
+	case "user", "assistant", "system":
+		return true
+	}
+	return false
+}
+
+func mergeMessages(prev, next map[string]any) map[string]any {
+	out := map[string]any{}
+	for k, v := range prev {
+		out[k] = v
+	}
+	out["content"] = mergeContent(prev["content"], next["content"])
+	prevCalls, _ := prev["tool_calls"].([]any)
+	nextCalls, _ := next["tool_calls"].([]any)
+	if len(prevCalls)+len(nextCalls) > 0 {
+		out["tool_calls"] = append(append([]any{}, prevCalls...), nextCalls...)
+	}
+	return out
+}
+
+func mergeContent(a, b any) any {
+	as, aIsString := a.(string)
+	bs, bIsString := b.(string)
+	switch {
+	case a == nil || (aIsString && as == ""):
+		return b
+	case b == nil || (bIsString && bs == ""):
+		return a
+	case aIsString && bIsString:
+		return as + "\n\n" + bs
+	}
+	return append(contentParts(a), contentParts(b)...)
+}
+
+func contentParts(c any) []any {
+	switch v := c.(type) {
+	case string:
+		return []any{map[string]any{"type": "text", "text": v}}
+	case []any:
+		return append([]any{}, v...)
+	}
+	return nil
+}
+
+func appendUserText(out []any, text string) []any {
+	if n := len(out); n > 0 {
+		if prev, ok := out[n-1].(map[string]any); ok && prev["role"] == "user" {
+			prev["content"] = mergeContent(prev["content"], text)
+			return out
+		}
+	}
+	return append(out, map[string]any{"role": "user", "content": text})
+}
+
+func toolCallIDs(m map[string]any) map[string]bool {
+	ids := orderedToolCallIDs(m)
+	if len(ids) == 0 {
+		return nil
+	}
+	out := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		out[id] = true
+	}
+	return out
+}
+
+func orderedToolCallIDs(m map[string]any) []string {
+	if m["role"] != "assistant" {
+		return nil
+	}
+	calls, _ := m["tool_calls"].([]any)
+	var ids []string
+	for _, c := range calls {
+		cm, ok := c.(map[string]any)
+		if !ok {
+			continue
+		}
+		if id, _ := cm["id"].(string); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
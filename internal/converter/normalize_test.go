@@ -0,0 +1,82 @@
+package converter
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"claude-nvidia-proxy/internal/types"
+)
+
+func TestNormalizeMessages(t *testing.T) {
+	const call = `{"id":"c1","type":"function","function":{"name":"f","arguments":"{}"}}`
+	const call2 = `{"id":"c2","type":"function","function":{"name":"f","arguments":"{}"}}`
+	tests := []struct {
+		name  string
+		in    string
+		want  string
+		notes []string
+	}{
+		{
+			name: "already valid",
+			in:   `[{"role":"user","content":"hi"},{"role":"assistant","tool_calls":[` + call + `]},{"role":"tool","tool_call_id":"c1","content":"ok"}]`,
+			want: `[{"role":"user","content":"hi"},{"role":"assistant","tool_calls":[` + call + `]},{"role":"tool","tool_call_id":"c1","content":"ok"}]`,
+		},
+		{
+			name:  "empty messages dropped",
+			in:    `[{"role":"user","content":""},{"role":"assistant","content":[]},{"role":"assistant"},{"role":"user","content":"hi"}]`,
+			want:  `[{"role":"user","content":"hi"}]`,
+			notes: []string{"dropped 3 empty message(s)"},
+		},
+		{
+			name:  "same-role messages merged",
+			in:    `[{"role":"user","content":"a"},{"role":"user","content":"b"},{"role":"assistant","content":"c"},{"role":"assistant","content":[{"type":"text","text":"d"}]}]`,
+			want:  `[{"role":"user","content":"a\n\nb"},{"role":"assistant","content":[{"type":"text","text":"c"},{"type":"text","text":"d"}]}]`,
+			notes: []string{"merged 2 adjacent same-role message(s)"},
+		},
+		{
+			name:  "merged assistant turns keep every tool call",
+			in:    `[{"role":"assistant","content":"x","tool_calls":[` + call + `]},{"role":"assistant","tool_calls":[` + call2 + `]},{"role":"tool","tool_call_id":"c2","content":"2"},{"role":"tool","tool_call_id":"c1","content":"1"}]`,
+			want:  `[{"role":"assistant","content":"x","tool_calls":[` + call + `,` + call2 + `]},{"role":"tool","tool_call_id":"c2","content":"2"},{"role":"tool","tool_call_id":"c1","content":"1"}]`,
+			notes: []string{"merged 1 adjacent same-role message(s)"},
+		},
+		{
+			name:  "missing result synthesized",
+			in:    `[{"role":"assistant","tool_calls":[` + call + `,` + call2 + `]},{"role":"tool","tool_call_id":"c2","content":"2"},{"role":"user","content":"next"}]`,
+			want:  `[{"role":"assistant","tool_calls":[` + call + `,` + call2 + `]},{"role":"tool","tool_call_id":"c2","content":"2"},{"role":"tool","tool_call_id":"c1","content":"` + missingToolResult + `"},{"role":"user","content":"next"}]`,
+			notes: []string{"synthesized 1 missing tool result(s)"},
+		},
+		{
+			name:  "orphaned result becomes user text",
+			in:    `[{"role":"user","content":"hi"},{"role":"tool","tool_call_id":"cx","content":"lost"},{"role":"user","content":"again"}]`,
+			want:  `[{"role":"user","content":"hi\n\n[tool result for cx]\nlost\n\nagain"}]`,
+			notes: []string{"converted 1 orphaned tool result(s) to user text"},
+		},
+		{
+			name:  "duplicate and unknown results after a call",
+			in:    `[{"role":"assistant","tool_calls":[` + call + `]},{"role":"tool","tool_call_id":"c1","content":"1"},{"role":"tool","tool_call_id":"c1","content":"again"},{"role":"tool","tool_call_id":"c9","content":"9"}]`,
+			want:  `[{"role":"assistant","tool_calls":[` + call + `]},{"role":"tool","tool_call_id":"c1","content":"1"},{"role":"user","content":"[tool result for c1]\nagain\n\n[tool result for c9]\n9"}]`,
+			notes: []string{"converted 2 orphaned tool result(s) to user text"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var in, want []any
+			if err := json.Unmarshal([]byte(tt.in), &in); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			conv := NewConversion(types.FullCapabilities())
+			got := normalizeMessages(in, conv)
+			if got := roundTrip(t, got); !reflect.DeepEqual(got, any(want)) {
+				b, _ := json.Marshal(got)
+				t.Errorf("messages\n got: %s\nwant: %s", b, tt.want)
+			}
+			if !reflect.DeepEqual(conv.Normalizations, tt.notes) {
+				t.Errorf("normalizations = %q, want %q", conv.Normalizations, tt.notes)
+			}
+		})
+	}
+}
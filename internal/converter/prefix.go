@@ -0,0 +1,89 @@
+package converter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+
+	"claude-nvidia-proxy/internal/types"
+)
+
+// CacheBreakpoint is the state of the prompt prefix at a block marked with
+// cache_control: a hash of everything up to and including that block and an
+// estimate of its size in tokens.
+type CacheBreakpoint struct {
+	Hash   string
+	Tokens int
+}
+
+// prefixHasher accumulates the prompt in Anthropic cache order (tools, then
+// system, then messages) and snapshots it at every cache_control marker.
+type prefixHasher struct {
+	h           hash.Hash
+	chars       int
+	breakpoints []CacheBreakpoint
+}
+
+func (p *prefixHasher) add(kind string, v any, marked bool) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	p.h.Write([]byte(kind))
+	p.h.Write([]byte{0})
+	p.h.Write(b)
+	p.h.Write([]byte{0})
+	p.chars += len(b)
+	if marked {
+		p.breakpoints = append(p.breakpoints, CacheBreakpoint{
+			Hash:   hex.EncodeToString(p.h.Sum(nil)),
+			Tokens: EstimateTokens(p.chars),
+		})
+	}
+}
+
+// computeCacheBreakpoints hashes the request prefix at each cache_control
+// breakpoint. Requests without markers have no breakpoints.
+func computeCacheBreakpoints(req *types.AnthropicMessageRequest, system []types.AnthropicContentBlock) []CacheBreakpoint {
+	p := &prefixHasher{h: sha256.New()}
+	p.add("model", req.Model, false)
+
+	for _, t := range req.Tools {
+		cp := t
+		cp.CacheControl = nil
+		p.add("tool", cp, t.CacheControl != nil)
+	}
+	for _, blk := range system {
+		cp := blk
+		cp.CacheControl = nil
+		p.add("system", cp, blk.CacheControl != nil)
+	}
+	for _, m := range req.Messages {
+		var blocks []types.AnthropicContentBlock
+		if err := json.Unmarshal(m.Content, &blocks); err != nil {
+			p.add(m.Role, m.Content, false)
+			continue
+		}
+		for _, blk := range blocks {
+			cp := blk
+			cp.CacheControl = nil
+			p.add(m.Role, cp, blk.CacheControl != nil)
+		}
+	}
+	return p.breakpoints
+}
+
+// EstimateTokens approximates a token count from a character count.
+func EstimateTokens(chars int) int {
+	return (chars + 3) / 4
+}
+
+// PrefixHash returns the hash of the longest cache_control-marked prefix, or
+// "" when the request marks none.
+func (c *Conversion) PrefixHash() string {
+	if len(c.CacheBreakpoints) == 0 {
+		return ""
+	}
+	return c.CacheBreakpoints[len(c.CacheBreakpoints)-1].Hash
+}
@@ -0,0 +1,121 @@
+package converter
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"claude-nvidia-proxy/internal/types"
+)
+
+func TestSystemBlocksPreserved(t *testing.T) {
+	const body = `{"model":"m","system":[
+		{"type":"text","text":"You are terse.","cache_control":{"type":"ephemeral"}},
+		{"type":"text","text":""},
+		{"type":"text","text":"Project rules."}],"messages":[{"role":"user","content":"hi"}]}`
+	tests := []struct {
+		name string
+		caps func(*types.ModelCapabilities)
+		want string
+	}{
+		{"blocks", func(c *types.ModelCapabilities) { c.SystemBlocks = true }, `[{"type":"text","text":"You are terse."},{"type":"text","text":"Project rules."}]`},
+		{"joined", func(c *types.ModelCapabilities) { c.SystemBlocks = false }, `"You are terse.\nProject rules."`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caps := types.FullCapabilities()
+			tt.caps(&caps)
+			out, _ := convert(t, caps, body)
+			var want any
+			_ = json.Unmarshal([]byte(tt.want), &want)
+			sys := out.Messages[0].(map[string]any)
+			if got := roundTrip(t, sys["content"]); sys["role"] != "system" || !reflect.DeepEqual(got, want) {
+				t.Errorf("system message = %v, want content %s", sys, tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheBreakpoints(t *testing.T) {
+	request := func(system, marked, tail string) string {
+		return `{"model":"m","system":[{"type":"text","text":"` + system + `"}],"messages":[
+			{"role":"user","content":[{"type":"text","text":"` + marked + `","cache_control":{"type":"ephemeral"}}]},
+			{"role":"user","content":"` + tail + `"}]}`
+	}
+	breakpoints := func(body string) []CacheBreakpoint {
+		_, conv := convert(t, types.FullCapabilities(), body)
+		return conv.CacheBreakpoints
+	}
+
+	base := breakpoints(request("sys", "doc", "question one"))
+	if len(base) != 1 || len(base[0].Hash) != 64 || base[0].Tokens == 0 {
+		t.Fatalf("breakpoints = %+v", base)
+	}
+	if got := breakpoints(request("sys", "doc", "question two")); !reflect.DeepEqual(got, base) {
+		t.Errorf("text after the breakpoint changed it: %+v", got)
+	}
+	for _, body := range []string{request("other", "doc", "question one"), request("sys", "doc2", "question one")} {
+		if got := breakpoints(body); len(got) != 1 || got[0].Hash == base[0].Hash {
+			t.Errorf("prefix change kept the hash: %+v", got)
+		}
+	}
+	if got := breakpoints(`{"model":"m","messages":[{"role":"user","content":"hi"}]}`); got != nil {
+		t.Errorf("unmarked request has breakpoints: %+v", got)
+	}
+
+	// Tools come first in cache order, and every marker gets a breakpoint.
+	got := breakpoints(`{"model":"m","tools":[{"name":"a","input_schema":{"type":"object"},"cache_control":{"type":"ephemeral"}}],
+		"system":[{"type":"text","text":"` + strings.Repeat("s", 400) + `","cache_control":{"type":"ephemeral"}}],"messages":[]}`)
+	if len(got) != 2 || got[0].Hash == got[1].Hash || got[1].Tokens <= got[0].Tokens+99 {
+		t.Errorf("breakpoints = %+v", got)
+	}
+}
+
+func TestPromptCacheKey(t *testing.T) {
+	const body = `{"model":"m","system":[{"type":"text","text":"s","cache_control":{"type":"ephemeral"}}],"messages":[{"role":"user","content":"hi"}]}`
+	caps := types.FullCapabilities()
+	caps.PromptCacheKey = true
+	out, conv := convert(t, caps, body)
+	if out.PromptCacheKey == "" || out.PromptCacheKey != conv.PrefixHash() {
+		t.Errorf("prompt_cache_key = %q, prefix hash %q", out.PromptCacheKey, conv.PrefixHash())
+	}
+	caps.PromptCacheKey = false
+	if out, _ := convert(t, caps, body); out.PromptCacheKey != "" {
+		t.Errorf("prompt_cache_key sent without the capability: %q", out.PromptCacheKey)
+	}
+}
+
+func TestApplyEmulatedCacheUsage(t *testing.T) {
+	tests := []struct {
+		name           string
+		usage          map[string]any
+		read, creation int
+		want           map[string]any
+	}{
+		{
+			name:  "split input tokens",
+			usage: map[string]any{"input_tokens": 500, "output_tokens": 7},
+			read:  100, creation: 300,
+			want: map[string]any{"input_tokens": 100, "output_tokens": 7, "cache_read_input_tokens": 100, "cache_creation_input_tokens": 300},
+		},
+		{
+			name:  "estimates capped by input",
+			usage: map[string]any{"input_tokens": 150},
+			read:  100, creation: 300,
+			want: map[string]any{"input_tokens": 0, "cache_read_input_tokens": 100, "cache_creation_input_tokens": 50},
+		},
+		{
+			name:  "upstream reported cache reads",
+			usage: map[string]any{"input_tokens": 500, "cache_read_input_tokens": 200},
+			read:  100, creation: 300,
+			want: map[string]any{"input_tokens": 500, "cache_read_input_tokens": 200},
+		},
+	}
+	for _, tt := range tests {
+		ApplyEmulatedCacheUsage(tt.usage, tt.read, tt.creation)
+		if !reflect.DeepEqual(tt.usage, tt.want) {
+			t.Errorf("%s: usage = %v, want %v", tt.name, tt.usage, tt.want)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package converter
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"claude-nvidia-proxy/internal/types"
+)
+
+// maxRefExpansions bounds $ref inlining so recursive schemas terminate.
+const maxRefExpansions = 64
+
+// schemaSanitizer rewrites one tool's input schema according to SchemaRules,
+// recording every change under the tool's name.
+type schemaSanitizer struct {
+	rules      types.SchemaRules
+	root       map[string]any
+	tool       string
+	changes    []string
+	expansions int
+}
+
+// SanitizeSchema returns a copy of schema rewritten for the upstream provider
+// together with a description of each transformation applied.
+func SanitizeSchema(tool string, schema any, rules types.SchemaRules) (any, []string) {
+	root, ok := schema.(map[string]any)
+	if !ok || !rules.Enabled {
+		return schema, nil
+	}
+	s := &schemaSanitizer{rules: rules, root: root, tool: tool}
+	return s.walk(root, "#", 0, nil), s.changes
+}
+
+func (s *schemaSanitizer) note(path, format string, args ...any) {
+	s.changes = append(s.changes, fmt.Sprintf("%s %s: %s", s.tool, path, fmt.Sprintf(format, args...)))
+}
+
+func (s *schemaSanitizer) walk(node map[string]any, path string, depth int, refStack []string) map[string]any {
+	if s.rules.MaxDepth > 0 && depth > s.rules.MaxDepth {
+		s.note(path, "schema deeper than %d levels replaced with {}", s.rules.MaxDepth)
+		return map[string]any{}
+	}
+
+	if ref, ok := node["$ref"].(string); ok && s.rules.ResolveRefs {
+		if resolved, ok := s.resolveRef(ref, path, refStack); ok {
+			merged := make(map[string]any, len(resolved)+len(node))
+			for k, v := range resolved {
+				merged[k] = v
+			}
+			for k, v := range node {
+				if k != "$ref" {
+					merged[k] = v
+				}
+			}
+			return s.walk(merged, path, depth, append(refStack, ref))
+		}
+	}
+
+	out := make(map[string]any, len(node))
+	for k, v := range node {
+		if slices.Contains(s.rules.StripKeywords, k) {
+			s.note(path, "removed %s", k)
+			continue
+		}
+		out[k] = v
+	}
+
+	if f, ok := out["format"].(string); ok && slices.Contains(s.rules.StripFormats, f) {
+		delete(out, "format")
+		s.note(path, "removed format %q", f)
+	}
+
+	if _, ok := out["additionalProperties"]; ok && depth > 0 && s.rules.StripNestedAdditionalProperties {
+		delete(out, "additionalProperties")
+		s.note(path, "removed nested additionalProperties")
+	}
+
+	if s.rules.CollapseNullable {
+		s.collapseNullable(out, path)
+		// An unwrapped alternative may itself be a reference.
+		if _, isRef := out["$ref"]; isRef && s.rules.ResolveRefs {
+			if _, wasRef := node["$ref"]; !wasRef {
+				return s.walk(out, path, depth, refStack)
+			}
+		}
+	}
+
+	if s.rules.ResolveRefs {
+		// References resolve against the original root, so definitions at
+		// any level are no longer needed once inlined.
+		for _, key := range []string{"$defs", "definitions"} {
+			if _, ok := out[key]; ok {
+				delete(out, key)
+				s.note(path, "removed %s after inlining references", key)
+			}
+		}
+	}
+
+	for _, key := range []string{"properties", "patternProperties", "$defs", "definitions"} {
+		props, ok := out[key].(map[string]any)
+		if !ok {
+			continue
+		}
+		cp := make(map[string]any, len(props))
+		for name, sub := range props {
+			if m, ok := sub.(map[string]any); ok {
+				cp[name] = s.walk(m, path+"/"+key+"/"+name, depth+1, refStack)
+			} else {
+				cp[name] = sub
+			}
+		}
+		out[key] = cp
+	}
+
+	for _, key := range []string{"items", "additionalProperties", "not", "contains", "if", "then", "else"} {
+		if m, ok := out[key].(map[string]any); ok {
+			out[key] = s.walk(m, path+"/"+key, depth+1, refStack)
+		}
+	}
+
+	for _, key := range []string{"anyOf", "oneOf", "allOf", "prefixItems", "items"} {
+		list, ok := out[key].([]any)
+		if !ok {
+			continue
+		}
+		cp := make([]any, 0, len(list))
+		for i, sub := range list {
+			if m, ok := sub.(map[string]any); ok {
+				cp = append(cp, s.walk(m, fmt.Sprintf("%s/%s/%d", path, key, i), depth+1, refStack))
+			} else {
+				cp = append(cp, sub)
+			}
+		}
+		out[key] = cp
+	}
+
+	return out
+}
+
+// resolveRef looks up a local JSON pointer reference. Remote references and
+// cycles are left untouched.
+func (s *schemaSanitizer) resolveRef(ref, path string, refStack []string) (map[string]any, bool) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, false
+	}
+	if slices.Contains(refStack, ref) || s.expansions >= maxRefExpansions {
+		s.note(path, "recursive $ref %q replaced with {}", ref)
+		return map[string]any{}, true
+	}
+
+	var cur any = s.root
+	for _, tok := range strings.Split(strings.TrimPrefix(strings.TrimPrefix(ref, "#"), "/"), "/") {
+		if tok == "" {
+			continue
+		}
+		tok = strings.ReplaceAll(strings.ReplaceAll(tok, "~1", "/"), "~0", "~")
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[tok]; !ok {
+			return nil, false
+		}
+	}
+	resolved, ok := cur.(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	s.expansions++
+	s.note(path, "inlined $ref %q", ref)
+	return resolved, true
+}
+
+// collapseNullable drops null alternatives from anyOf/oneOf and type arrays,
+// unwrapping single remaining alternatives.
+func (s *schemaSanitizer) collapseNullable(node map[string]any, path string) {
+	if typeList, ok := node["type"].([]any); ok {
+		kept := make([]any, 0, len(typeList))
+		for _, t := range typeList {
+			if t != "null" {
+				kept = append(kept, t)
+			}
+		}
+		if len(kept) != len(typeList) && len(kept) > 0 {
+			if len(kept) == 1 {
+				node["type"] = kept[0]
+			} else {
+				node["type"] = kept
+			}
+			s.note(path, "removed null from type list")
+		}
+	}
+
+	for _, key := range []string{"anyOf", "oneOf"} {
+		list, ok := node[key].([]any)
+		if !ok {
+			continue
+		}
+		kept := make([]any, 0, len(list))
+		for _, alt := range list {
+			if m, ok := alt.(map[string]any); ok && m["type"] == "null" && len(m) == 1 {
+				continue
+			}
+			kept = append(kept, alt)
+		}
+		if len(kept) == len(list) || len(kept) == 0 {
+			continue
+		}
+		s.note(path, "removed null alternative from %s", key)
+		if len(kept) > 1 {
+			node[key] = kept
+			continue
+		}
+		delete(node, key)
+		if m, ok := kept[0].(map[string]any); ok {
+			for k, v := range m {
+				if _, exists := node[k]; !exists {
+					node[k] = v
+				}
+			}
+		}
+	}
+}
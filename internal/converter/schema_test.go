@@ -0,0 +1,146 @@
+package converter
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"claude-nvidia-proxy/internal/types"
+)
+
+var testSchemaRules = types.SchemaRules{
+	Enabled:                         true,
+	StripKeywords:                   []string{"$schema", "$id", "$comment"},
+	StripFormats:                    []string{"uri", "uri-reference"},
+	StripNestedAdditionalProperties: true,
+	CollapseNullable:                true,
+	ResolveRefs:                     true,
+	MaxDepth:                        32,
+}
+
+func TestSanitizeSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   func(*types.SchemaRules)
+		schema  string
+		want    string
+		changes int
+	}{
+		{
+			name:   "already clean",
+			schema: `{"type":"object","properties":{"a":{"type":"string"}},"required":["a"],"additionalProperties":false}`,
+			want:   `{"type":"object","properties":{"a":{"type":"string"}},"required":["a"],"additionalProperties":false}`,
+		},
+		{
+			name:    "keywords and formats",
+			schema:  `{"$schema":"https://json-schema.org/draft/2020-12/schema","type":"object","properties":{"u":{"type":"string","format":"uri","$comment":"x"},"d":{"type":"string","format":"date-time"}}}`,
+			want:    `{"type":"object","properties":{"u":{"type":"string"},"d":{"type":"string","format":"date-time"}}}`,
+			changes: 3,
+		},
+		{
+			name:    "nested additionalProperties",
+			schema:  `{"type":"object","additionalProperties":false,"properties":{"o":{"type":"object","additionalProperties":false}}}`,
+			want:    `{"type":"object","additionalProperties":false,"properties":{"o":{"type":"object"}}}`,
+			changes: 1,
+		},
+		{
+			name:    "nullable",
+			schema:  `{"type":"object","properties":{"a":{"type":["string","null"]},"b":{"anyOf":[{"type":"integer"},{"type":"null"}]},"c":{"oneOf":[{"type":"string"},{"type":"number"},{"type":"null"}]}}}`,
+			want:    `{"type":"object","properties":{"a":{"type":"string"},"b":{"type":"integer"},"c":{"oneOf":[{"type":"string"},{"type":"number"}]}}}`,
+			changes: 3,
+		},
+		{
+			name:    "refs inlined and definitions dropped",
+			schema:  `{"type":"object","properties":{"p":{"$ref":"#/$defs/Point"}},"$defs":{"Point":{"type":"object","properties":{"x":{"type":"number"}}}}}`,
+			want:    `{"type":"object","properties":{"p":{"type":"object","properties":{"x":{"type":"number"}}}}}`,
+			changes: 2,
+		},
+		{
+			name:    "nullable ref",
+			schema:  `{"type":"object","properties":{"p":{"anyOf":[{"$ref":"#/definitions/P"},{"type":"null"}]}},"definitions":{"P":{"type":"string"}}}`,
+			want:    `{"type":"object","properties":{"p":{"type":"string"}}}`,
+			changes: 3,
+		},
+		{
+			name:    "nested definitions",
+			schema:  `{"type":"object","properties":{"o":{"type":"object","properties":{"v":{"$ref":"#/properties/o/$defs/V"}},"$defs":{"V":{"type":"string","format":"uri"}}}}}`,
+			want:    `{"type":"object","properties":{"o":{"type":"object","properties":{"v":{"type":"string"}}}}}`,
+			changes: 3,
+		},
+		{
+			name:    "recursive ref",
+			schema:  `{"type":"object","properties":{"n":{"$ref":"#/$defs/Node"}},"$defs":{"Node":{"type":"object","properties":{"next":{"$ref":"#/$defs/Node"}}}}}`,
+			want:    `{"type":"object","properties":{"n":{"type":"object","properties":{"next":{}}}}}`,
+			changes: 3,
+		},
+		{
+			name:    "refs kept when not resolving",
+			rules:   func(r *types.SchemaRules) { r.ResolveRefs = false },
+			schema:  `{"type":"object","properties":{"p":{"$ref":"#/$defs/P"}},"$defs":{"P":{"type":"string","format":"uri"}}}`,
+			want:    `{"type":"object","properties":{"p":{"$ref":"#/$defs/P"}},"$defs":{"P":{"type":"string"}}}`,
+			changes: 1,
+		},
+		{
+			name:    "max depth",
+			rules:   func(r *types.SchemaRules) { r.MaxDepth = 2 },
+			schema:  `{"type":"object","properties":{"a":{"type":"object","properties":{"b":{"type":"array","items":{"type":"string"}}}}}}`,
+			want:    `{"type":"object","properties":{"a":{"type":"object","properties":{"b":{"type":"array","items":{}}}}}}`,
+			changes: 1,
+		},
+		{
+			name:   "disabled",
+			rules:  func(r *types.SchemaRules) { r.Enabled = false },
+			schema: `{"$schema":"x","type":"object"}`,
+			want:   `{"$schema":"x","type":"object"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := testSchemaRules
+			if tt.rules != nil {
+				tt.rules(&rules)
+			}
+			var schema, want any
+			if err := json.Unmarshal([]byte(tt.schema), &schema); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			got, changes := SanitizeSchema("tool", schema, rules)
+			if got := roundTrip(t, got); !reflect.DeepEqual(got, want) {
+				t.Errorf("schema\n got: %v\nwant: %v", got, want)
+			}
+			if len(changes) != tt.changes {
+				t.Errorf("changes = %q, want %d", changes, tt.changes)
+			}
+		})
+	}
+}
+
+// A schema nested far deeper than any real tool needs is cut off by the
+// default depth limit instead of being forwarded.
+func TestSanitizeSchemaDeepNesting(t *testing.T) {
+	var schema any = map[string]any{"type": "string"}
+	for i := 0; i < 500; i++ {
+		schema = map[string]any{"type": "array", "items": schema}
+	}
+	got, changes := SanitizeSchema("deep", schema, testSchemaRules)
+	depth := 0
+	for node, _ := got.(map[string]any); node != nil; node, _ = node["items"].(map[string]any) {
+		depth++
+	}
+	if depth > testSchemaRules.MaxDepth+2 || len(changes) != 1 {
+		t.Errorf("depth = %d, changes = %q", depth, changes)
+	}
+}
+
+func TestSanitizeSchemaLeavesInputUnchanged(t *testing.T) {
+	const raw = `{"$schema":"x","type":"object","properties":{"p":{"$ref":"#/$defs/P"}},"$defs":{"P":{"type":["string","null"]}}}`
+	var schema any
+	_ = json.Unmarshal([]byte(raw), &schema)
+	SanitizeSchema("tool", schema, testSchemaRules)
+	if b, _ := json.Marshal(schema); string(b) != `{"$defs":{"P":{"type":["string","null"]}},"$schema":"x","properties":{"p":{"$ref":"#/$defs/P"}},"type":"object"}` {
+		t.Errorf("input schema was modified: %s", b)
+	}
+}
@@ -0,0 +1,94 @@
+package converter
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"claude-nvidia-proxy/internal/types"
+)
+
+func TestConvertToolChoice(t *testing.T) {
+	tests := []struct {
+		name   string
+		choice string
+		want   string
+	}{
+		{"auto", `{"type":"auto"}`, `"auto"`},
+		{"none", `{"type":"none"}`, `"none"`},
+		{"any", `{"type":"any","disable_parallel_tool_use":true}`, `"required"`},
+		{"tool", `{"type":"tool","name":"get_weather"}`, `{"type":"function","function":{"name":"get_weather"}}`},
+		{"tool with a renamed name", `{"type":"tool","name":"mcp.search"}`, `{"type":"function","function":{"name":"mcp_search"}}`},
+		{"tool without a name", `{"type":"tool"}`, `"auto"`},
+		{"string passed through", `"auto"`, `"auto"`},
+		{"unknown type passed through", `{"type":"custom"}`, `{"type":"custom"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var choice, want any
+			_ = json.Unmarshal([]byte(tt.choice), &choice)
+			_ = json.Unmarshal([]byte(tt.want), &want)
+			got := convertToolChoice(choice, NewConversion(types.FullCapabilities()))
+			if got := roundTrip(t, got); !reflect.DeepEqual(got, want) {
+				t.Errorf("convertToolChoice(%s) = %v, want %v", tt.choice, got, want)
+			}
+		})
+	}
+}
+
+func TestDisableParallelToolUse(t *testing.T) {
+	const tools = `"tools":[{"name":"a","input_schema":{"type":"object"}}]`
+	tests := []struct {
+		name         string
+		body         string
+		wantSingle   bool
+		wantParallel *bool
+	}{
+		{"default", `{"model":"m",` + tools + `,"messages":[]}`, false, nil},
+		{"disabled", `{"model":"m",` + tools + `,"tool_choice":{"type":"auto","disable_parallel_tool_use":true},"messages":[]}`, true, new(bool)},
+		{"explicitly enabled", `{"model":"m",` + tools + `,"tool_choice":{"type":"any","disable_parallel_tool_use":false},"messages":[]}`, false, nil},
+		{"no tools", `{"model":"m","tool_choice":{"type":"auto","disable_parallel_tool_use":true},"messages":[]}`, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, conv := convert(t, types.FullCapabilities(), tt.body)
+			if conv.SingleToolCall != tt.wantSingle {
+				t.Errorf("SingleToolCall = %v, want %v", conv.SingleToolCall, tt.wantSingle)
+			}
+			if !reflect.DeepEqual(out.ParallelToolCalls, tt.wantParallel) {
+				t.Errorf("parallel_tool_calls = %v, want %v", out.ParallelToolCalls, tt.wantParallel)
+			}
+		})
+	}
+}
+
+// With parallel tool use disabled only the first call of a reply reaches the
+// client; the rest are counted as dropped.
+func TestSingleToolCallDropsExtraCalls(t *testing.T) {
+	var resp types.OpenAIChatCompletionResponse
+	if err := json.Unmarshal([]byte(`{"choices":[{"finish_reason":"tool_calls","message":{"role":"assistant","tool_calls":[
+		{"id":"call_a","type":"function","function":{"name":"a","arguments":"{\"n\":1}"}},
+		{"id":"call_b","type":"function","function":{"name":"a","arguments":"{\"n\":2}"}},
+		{"id":"call_c","type":"function","function":{"name":"a","arguments":"{\"n\":3}"}}]}}]}`), &resp); err != nil {
+		t.Fatal(err)
+	}
+	for _, single := range []bool{false, true} {
+		conv := NewConversion(types.FullCapabilities())
+		conv.SingleToolCall = single
+		out := ConvertOpenAIToAnthropic(resp, conv)
+
+		want, dropped := 3, 0
+		if single {
+			want, dropped = 1, 2
+		}
+		if len(out.Content) != want || conv.DroppedToolCalls != dropped {
+			t.Errorf("single %v: %d blocks, %d dropped; want %d, %d", single, len(out.Content), conv.DroppedToolCalls, want, dropped)
+		}
+		if id := out.Content[0].(map[string]any)["id"]; id != "call_a" {
+			t.Errorf("single %v: first call id = %v", single, id)
+		}
+		if out.StopReason != "tool_use" {
+			t.Errorf("single %v: stop_reason = %q", single, out.StopReason)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package converter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"claude-nvidia-proxy/internal/types"
+)
+
+// maxUpstreamToolNameLen is the function name limit enforced by
+// OpenAI-compatible backends.
+const maxUpstreamToolNameLen = 64
+
+var validUpstreamToolName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// UpstreamToolName returns the name to send upstream for a client tool name.
+// Names that already satisfy the upstream pattern are passed through; others
+// are sanitized and, when too long, shortened with a hash suffix. The mapping
+// is remembered so OriginalToolName can reverse it.
+func (c *Conversion) UpstreamToolName(name string) string {
+	if validUpstreamToolName.MatchString(name) {
+		return name
+	}
+	if mapped, ok := c.toolNamesUp[name]; ok {
+		return mapped
+	}
+
+	mapped := sanitizeToolName(name)
+	if len(mapped) > maxUpstreamToolNameLen {
+		mapped = shortenToolName(mapped, name)
+	}
+	if prev, taken := c.toolNamesDown[mapped]; taken && prev != name {
+		mapped = shortenToolName(mapped, name)
+	}
+
+	if c.toolNamesUp == nil {
+		c.toolNamesUp = map[string]string{}
+		c.toolNamesDown = map[string]string{}
+	}
+	c.toolNamesUp[name] = mapped
+	c.toolNamesDown[mapped] = name
+	return mapped
+}
+
+// reserveToolNames claims the declared names that pass through unchanged,
+// so a sanitized name cannot collide with one of them.
+func (c *Conversion) reserveToolNames(tools []types.AnthropicTool) {
+	for _, t := range tools {
+		if !validUpstreamToolName.MatchString(t.Name) {
+			continue
+		}
+		if c.toolNamesDown == nil {
+			c.toolNamesUp = map[string]string{}
+			c.toolNamesDown = map[string]string{}
+		}
+		c.toolNamesDown[t.Name] = t.Name
+	}
+}
+
+// OriginalToolName maps a tool name returned by the upstream back to the name
+// the client declared.
+func (c *Conversion) OriginalToolName(name string) string {
+	if orig, ok := c.toolNamesDown[name]; ok {
+		return orig
+	}
+	return name
+}
+
+// ResponseToolName is OriginalToolName for a name in an upstream reply; a
+// call that came back without a name is called tool_<index>.
+func (c *Conversion) ResponseToolName(name string, index int) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "tool_" + strconv.Itoa(index)
+	}
+	return c.OriginalToolName(name)
+}
+
+// MappedToolNames lists "original -> upstream" pairs for logging.
+func (c *Conversion) MappedToolNames() []string {
+	out := make([]string, 0, len(c.toolNamesUp))
+	for orig, mapped := range c.toolNamesUp {
+		out = append(out, orig+" -> "+mapped)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func sanitizeToolName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if r == '_' || r == '-' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "tool"
+	}
+	return b.String()
+}
+
+// shortenToolName keeps a readable prefix and appends a hash of the original
+// name so distinct long names stay distinct.
+func shortenToolName(sanitized, original string) string {
+	sum := sha256.Sum256([]byte(original))
+	suffix := "_" + hex.EncodeToString(sum[:])[:8]
+	keep := maxUpstreamToolNameLen - len(suffix)
+	if len(sanitized) > keep {
+		sanitized = sanitized[:keep]
+	}
+	return sanitized + suffix
+}
@@ -0,0 +1,113 @@
+package converter
+
+import (
+	"strings"
+	"testing"
+
+	"claude-nvidia-proxy/internal/types"
+)
+
+func TestUpstreamToolName(t *testing.T) {
+	long := "mcp__github__" + strings.Repeat("create_pull_request_", 4)
+	tests := []struct {
+		name string
+		want string // "" when the result is a hashed name
+	}{
+		{"Read", "Read"},
+		{"get-weather_v2", "get-weather_v2"},
+		{"mcp__my.server__search docs", "mcp__my_server__search_docs"},
+		{"überTool", "_berTool"},
+		{"!!!", "___"},
+		{"", "tool"},
+		{long, ""},
+	}
+	conv := NewConversion(types.FullCapabilities())
+	for _, tt := range tests {
+		got := conv.UpstreamToolName(tt.name)
+		if !validUpstreamToolName.MatchString(got) {
+			t.Errorf("UpstreamToolName(%q) = %q, not a valid upstream name", tt.name, got)
+		}
+		if tt.want != "" && got != tt.want {
+			t.Errorf("UpstreamToolName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+		if tt.want == "" && (len(got) != maxUpstreamToolNameLen || !strings.HasPrefix(got, long[:20])) {
+			t.Errorf("UpstreamToolName(%q) = %q, want a %d-character hashed name", tt.name, got, maxUpstreamToolNameLen)
+		}
+		if back := conv.OriginalToolName(got); back != tt.name {
+			t.Errorf("OriginalToolName(%q) = %q, want %q", got, back, tt.name)
+		}
+		if again := conv.UpstreamToolName(tt.name); again != got {
+			t.Errorf("UpstreamToolName(%q) changed from %q to %q", tt.name, got, again)
+		}
+	}
+}
+
+// Distinct client names must never share an upstream name, whichever order
+// the colliding names are declared in.
+func TestToolNameCollisions(t *testing.T) {
+	tests := []struct {
+		name  string
+		tools []string
+	}{
+		{"sanitized names collide", []string{"a.b", "a b"}},
+		{"sanitized name after the valid name", []string{"a_b", "a.b"}},
+		{"sanitized name before the valid name", []string{"a.b", "a_b"}},
+		{"long names with a common prefix", []string{strings.Repeat("x", 70) + "1", strings.Repeat("x", 70) + "2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &types.AnthropicMessageRequest{Model: "m"}
+			for _, name := range tt.tools {
+				req.Tools = append(req.Tools, types.AnthropicTool{Name: name, InputSchema: []byte(`{"type":"object"}`)})
+			}
+			conv := NewConversion(types.FullCapabilities())
+			out, err := ConvertAnthropicToOpenAI(req, conv)
+			if err != nil {
+				t.Fatal(err)
+			}
+			seen := map[string]string{}
+			for i, tool := range out.Tools {
+				name, _ := tool.(map[string]any)["function"].(map[string]any)["name"].(string)
+				if prev, ok := seen[name]; ok {
+					t.Fatalf("%q and %q both map to %q", prev, tt.tools[i], name)
+				}
+				seen[name] = tt.tools[i]
+				if back := conv.OriginalToolName(name); back != tt.tools[i] {
+					t.Errorf("upstream name %q maps back to %q, want %q", name, back, tt.tools[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMappedToolNames(t *testing.T) {
+	conv := NewConversion(types.FullCapabilities())
+	for _, name := range []string{"b.tool", "Read", "a tool"} {
+		conv.UpstreamToolName(name)
+	}
+	got := strings.Join(conv.MappedToolNames(), ", ")
+	if want := "a tool -> a_tool, b.tool -> b_tool"; got != want {
+		t.Errorf("MappedToolNames = %q, want %q", got, want)
+	}
+}
+
+func TestResponseToolName(t *testing.T) {
+	conv := NewConversion(types.FullCapabilities())
+	conv.UpstreamToolName("mcp__my.server__search")
+	tests := []struct {
+		name  string
+		index int
+		want  string
+	}{
+		{"mcp__my_server__search", 0, "mcp__my.server__search"},
+		{" mcp__my_server__search\n", 0, "mcp__my.server__search"},
+		{"Read", 1, "Read"},
+		{"", 2, "tool_2"},
+		{"  ", 3, "tool_3"},
+	}
+	for _, tt := range tests {
+		if got := conv.ResponseToolName(tt.name, tt.index); got != tt.want {
+			t.Errorf("ResponseToolName(%q, %d) = %q, want %q", tt.name, tt.index, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"claude-nvidia-proxy/internal/types"
+)
+
+// Kinds of Anthropic-defined tools, derived from the versioned tool type.
+const (
+	ToolKindCustom     = "custom"
+	ToolKindBash       = "bash"
+	ToolKindTextEditor = "text_editor"
+	ToolKindComputer   = "computer"
+	ToolKindWebSearch  = "web_search"
+)
+
+const bashToolSchema = `{
+  "type": "object",
+  "properties": {
+    "command": {"type": "string", "description": "The bash command to run."},
+    "restart": {"type": "boolean", "description": "Set to true to restart the bash session."}
+  }
+}`
+
+const textEditorToolSchema = `{
+  "type": "object",
+  "properties": {
+    "command": {"type": "string", "enum": ["view", "create", "str_replace", "insert", "undo_edit"], "description": "The editing operation to perform."},
+    "path": {"type": "string", "description": "Absolute path to the file or directory."},
+    "file_text": {"type": "string", "description": "Content of the file to create (create)."},
+    "old_str": {"type": "string", "description": "Exact text to replace (str_replace)."},
+    "new_str": {"type": "string", "description": "Replacement or inserted text (str_replace, insert)."},
+    "insert_line": {"type": "integer", "description": "Line number after which to insert new_str (insert)."},
+    "view_range": {"type": "array", "items": {"type": "integer"}, "description": "Optional [start, end] line range (view)."}
+  },
+  "required": ["command", "path"]
+}`
+
+const computerToolSchema = `{
+  "type": "object",
+  "properties": {
+    "action": {"type": "string", "enum": ["key", "type", "mouse_move", "left_click", "left_click_drag", "right_click", "middle_click", "double_click", "triple_click", "scroll", "hold_key", "wait", "screenshot", "cursor_position", "left_mouse_down", "left_mouse_up"], "description": "The action to perform."},
+    "coordinate": {"type": "array", "items": {"type": "integer"}, "description": "[x, y] pixel coordinate."},
+    "start_coordinate": {"type": "array", "items": {"type": "integer"}, "description": "[x, y] start coordinate for left_click_drag."},
+    "text": {"type": "string", "description": "Text to type or key combination to press."},
+    "scroll_direction": {"type": "string", "enum": ["up", "down", "left", "right"]},
+    "scroll_amount": {"type": "integer"},
+    "duration": {"type": "number", "description": "Seconds to hold a key or wait."}
+  },
+  "required": ["action"]
+}`
+
+const webSearchToolSchema = `{
+  "type": "object",
+  "properties": {
+    "query": {"type": "string", "description": "The search query."}
+  },
+  "required": ["query"]
+}`
+
+// ToolKind classifies a tool by its versioned type, e.g. "bash_20250124".
+func ToolKind(t types.AnthropicTool) string {
+	typ := strings.TrimSpace(t.Type)
+	if typ == "" || typ == ToolKindCustom {
+		return ToolKindCustom
+	}
+	for _, kind := range []string{ToolKindBash, ToolKindTextEditor, ToolKindComputer, ToolKindWebSearch} {
+		if strings.HasPrefix(typ, kind+"_") {
+			return kind
+		}
+	}
+	return typ
+}
+
+// resolveTools expands Anthropic-defined client tools into equivalent function
+// schemas, registers server tools that have a local implementation and drops
+// the rest with a downgrade note.
+func (c *Conversion) resolveTools(tools []types.AnthropicTool) []types.AnthropicTool {
+	out := make([]types.AnthropicTool, 0, len(tools))
+	for _, t := range tools {
+		kind := ToolKind(t)
+		switch kind {
+		case ToolKindCustom:
+			out = append(out, t)
+		case ToolKindBash:
+			out = append(out, expandTypedTool(t, "Run commands in a persistent bash shell.", bashToolSchema))
+		case ToolKindTextEditor:
+			out = append(out, expandTypedTool(t, "View, create and edit files.", textEditorToolSchema))
+		case ToolKindComputer:
+			desc := "Control the computer with the mouse and keyboard and take screenshots."
+			if t.DisplayWidthPx > 0 && t.DisplayHeightPx > 0 {
+				desc += fmt.Sprintf(" The display is %dx%d pixels.", t.DisplayWidthPx, t.DisplayHeightPx)
+			}
+			out = append(out, expandTypedTool(t, desc, computerToolSchema))
+		case ToolKindWebSearch:
+			if !c.WebSearchEnabled {
+				c.downgrade("server tool %q (%s) dropped: no web search backend configured", t.Name, t.Type)
+				continue
+			}
+			if c.serverTools == nil {
+				c.serverTools = map[string]types.AnthropicTool{}
+			}
+			c.serverTools[t.Name] = t
+			out = append(out, expandTypedTool(t, "Search the web and return the most relevant results.", webSearchToolSchema))
+		default:
+			c.downgrade("tool %q of unsupported type %q dropped", t.Name, t.Type)
+		}
+	}
+	return out
+}
+
+func expandTypedTool(t types.AnthropicTool, description, schema string) types.AnthropicTool {
+	if strings.TrimSpace(t.Description) != "" {
+		description = t.Description
+	}
+	return types.AnthropicTool{
+		Name:        t.Name,
+		Description: description,
+		InputSchema: json.RawMessage(schema),
+	}
+}
+
+// ServerTool returns the server tool declared under name, if any. Calls to
+// server tools are executed by the proxy rather than returned to the client.
+func (c *Conversion) ServerTool(name string) (types.AnthropicTool, bool) {
+	t, ok := c.serverTools[name]
+	return t, ok
+}
+
+// HasServerTools reports whether the request declared a server tool the proxy
+// will execute itself.
+func (c *Conversion) HasServerTools() bool {
+	return len(c.serverTools) > 0
+}
@@ -0,0 +1,75 @@
+package converter
+
+import (
+	"reflect"
+	"testing"
+
+	"claude-nvidia-proxy/internal/types"
+)
+
+func TestToolKind(t *testing.T) {
+	tests := map[string]string{
+		"":                     ToolKindCustom,
+		"custom":               ToolKindCustom,
+		"bash_20250124":        ToolKindBash,
+		"text_editor_20250728": ToolKindTextEditor,
+		"computer_20250124":    ToolKindComputer,
+		"web_search_20250305":  ToolKindWebSearch,
+		"code_execution_2025":  "code_execution_2025",
+	}
+	for typ, want := range tests {
+		if got := ToolKind(types.AnthropicTool{Type: typ}); got != want {
+			t.Errorf("ToolKind(%q) = %q, want %q", typ, got, want)
+		}
+	}
+}
+
+func TestResolveTools(t *testing.T) {
+	tools := []types.AnthropicTool{
+		{Name: "Read", InputSchema: []byte(`{"type":"object"}`)},
+		{Type: "bash_20250124", Name: "bash"},
+		{Type: "text_editor_20250728", Name: "str_replace_based_edit_tool", Description: "Custom description."},
+		{Type: "computer_20250124", Name: "computer", DisplayWidthPx: 1024, DisplayHeightPx: 768},
+		{Type: "web_search_20250305", Name: "web_search", MaxUses: 2},
+		{Type: "code_execution_20250522", Name: "code_execution"},
+	}
+
+	for _, webSearch := range []bool{false, true} {
+		conv := NewConversion(types.FullCapabilities())
+		conv.WebSearchEnabled = webSearch
+		out := conv.resolveTools(tools)
+
+		var names []string
+		for _, tool := range out {
+			names = append(names, tool.Name)
+			if tool.Type != "" || len(tool.InputSchema) == 0 {
+				t.Errorf("%s was not expanded into a function tool: %+v", tool.Name, tool)
+			}
+		}
+		want := []string{"Read", "bash", "str_replace_based_edit_tool", "computer"}
+		if webSearch {
+			want = append(want, "web_search")
+		}
+		if !reflect.DeepEqual(names, want) {
+			t.Errorf("web search %v: tools = %q, want %q", webSearch, names, want)
+		}
+		if out[2].Description != "Custom description." {
+			t.Errorf("client description replaced: %q", out[2].Description)
+		}
+		if out[3].Description != "Control the computer with the mouse and keyboard and take screenshots. The display is 1024x768 pixels." {
+			t.Errorf("computer description = %q", out[3].Description)
+		}
+
+		st, ok := conv.ServerTool("web_search")
+		if ok != webSearch || conv.HasServerTools() != webSearch || (ok && st.MaxUses != 2) {
+			t.Errorf("web search %v: ServerTool = %+v, %v", webSearch, st, ok)
+		}
+		wantDowngrades := 1
+		if !webSearch {
+			wantDowngrades = 2
+		}
+		if len(conv.Downgrades) != wantDowngrades {
+			t.Errorf("web search %v: downgrades = %q", webSearch, conv.Downgrades)
+		}
+	}
+}
@@ -3,13 +3,27 @@ package logging
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"strings"
 
 	"claude-nvidia-proxy/internal/config"
 	"claude-nvidia-proxy/internal/types"
 )
 
+// Setup installs the default slog logger in the configured format and
+// level, and the redaction rules for logged bodies. Output from the
+// standard log package goes through the logger too.
+func Setup(cfg *config.ServerConfig, w io.Writer) {
+	setupRedactor(cfg)
+	opts := &slog.HandlerOptions{Level: cfg.LogLevel}
+	var h slog.Handler = slog.NewTextHandler(w, opts)
+	if cfg.LogFormat == "json" {
+		h = slog.NewJSONHandler(w, opts)
+	}
+	slog.SetDefault(slog.New(h))
+}
+
 func LogForwardedRequest(reqID string, cfg *config.ServerConfig, anthropicReq types.AnthropicMessageRequest, openaiReq types.OpenAIChatCompletionRequest) {
 	inSummary := map[string]any{
 		"model":      anthropicReq.Model,
@@ -18,26 +32,25 @@ func LogForwardedRequest(reqID string, cfg *config.ServerConfig, anthropicReq ty
 		"messages":   len(anthropicReq.Messages),
 		"tools":      len(anthropicReq.Tools),
 	}
-	log.Printf("[%s] inbound summary=%s", reqID, mustJSONTrunc(inSummary, cfg.LogBodyMax))
+	slog.Info("inbound summary", "req_id", reqID, "model", anthropicReq.Model, "summary", mustJSONTrunc(inSummary, cfg.LogBodyMax))
 
 	out := sanitizeOpenAIRequest(openaiReq)
-	log.Printf("[%s] forward url=%s", reqID, cfg.UpstreamURL)
-	log.Printf("[%s] forward headers=%s", reqID, mustJSONTrunc(map[string]any{
+	slog.Debug("forward request", "req_id", reqID, "url", cfg.UpstreamURL, "headers", mustJSONTrunc(map[string]any{
 		"Content-Type":  "application/json",
 		"Authorization": "Bearer <redacted>",
 	}, cfg.LogBodyMax))
-	log.Printf("[%s] forward body=%s", reqID, mustJSONTrunc(out, cfg.LogBodyMax))
+	slog.Info("forward body", "req_id", reqID, "body", mustJSONTrunc(out, cfg.LogBodyMax))
 }
 
 func LogForwardedUpstreamBody(reqID string, cfg *config.ServerConfig, body []byte) {
 	if cfg.LogBodyMax == 0 {
 		return
 	}
-	s := string(body)
+	s := Redact(string(body))
 	if len([]rune(s)) > cfg.LogBodyMax {
 		s = string([]rune(s)[:cfg.LogBodyMax]) + "...(truncated)"
 	}
-	log.Printf("[%s] upstream body=%s", reqID, s)
+	slog.Info("upstream body", "req_id", reqID, "body", s)
 }
 
 func mustJSONTrunc(v any, maxChars int) string {
@@ -45,10 +58,11 @@ func mustJSONTrunc(v any, maxChars int) string {
 	if err != nil {
 		return fmt.Sprintf(`{"_error":"json_marshal_failed","detail":%q}`, err.Error())
 	}
-	s := string(b)
 	if maxChars == 0 {
 		return "(disabled)"
 	}
+	// Redact before truncating so a cut cannot hide part of a secret.
+	s := Redact(string(b))
 	if len([]rune(s)) > maxChars {
 		return string([]rune(s)[:maxChars]) + "...(truncated)"
 	}
@@ -0,0 +1,171 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"claude-nvidia-proxy/internal/config"
+	"claude-nvidia-proxy/internal/types"
+)
+
+// capture runs Setup with cfg, writing into the returned buffer, and
+// restores the previous logger and redactor when the test ends.
+func capture(t *testing.T, cfg *config.ServerConfig) *bytes.Buffer {
+	t.Helper()
+	prevLogger, prevRedactor := slog.Default(), redactor
+	t.Cleanup(func() {
+		slog.SetDefault(prevLogger)
+		redactor = prevRedactor
+	})
+	var buf bytes.Buffer
+	Setup(cfg, &buf)
+	return &buf
+}
+
+func TestSetup(t *testing.T) {
+	t.Run("json at warn", func(t *testing.T) {
+		buf := capture(t, &config.ServerConfig{LogFormat: "json", LogLevel: slog.LevelWarn})
+		slog.Info("hidden")
+		slog.Warn("shown", "req_id", "r1")
+		var rec map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+			t.Fatalf("want one JSON record, got %q: %v", buf, err)
+		}
+		if rec["msg"] != "shown" || rec["level"] != "WARN" || rec["req_id"] != "r1" {
+			t.Errorf("record = %v", rec)
+		}
+	})
+
+	t.Run("text at debug", func(t *testing.T) {
+		buf := capture(t, &config.ServerConfig{LogFormat: "text", LogLevel: slog.LevelDebug})
+		slog.Debug("detail", "n", 3)
+		if got := buf.String(); !strings.Contains(got, "level=DEBUG") || !strings.Contains(got, "msg=detail n=3") {
+			t.Errorf("output = %q", got)
+		}
+	})
+
+	t.Run("standard log goes through slog", func(t *testing.T) {
+		buf := capture(t, &config.ServerConfig{LogFormat: "json"})
+		log.Print("from log package")
+		var rec map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &rec); err != nil || rec["msg"] != "from log package" {
+			t.Errorf("output = %q", buf)
+		}
+	})
+}
+
+// records decodes the JSON lines in buf keyed by message.
+func records(t *testing.T, buf *bytes.Buffer) map[string]map[string]any {
+	t.Helper()
+	out := map[string]map[string]any{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("decode %q: %v", line, err)
+		}
+		out[rec["msg"].(string)] = rec
+	}
+	return out
+}
+
+func TestLogForwardedRequest(t *testing.T) {
+	in := types.AnthropicMessageRequest{Model: "claude-x", MaxTokens: 64, Messages: make([]types.AnthropicMsg, 2)}
+	out := types.OpenAIChatCompletionRequest{
+		Model: "upstream-x",
+		Messages: []any{
+			map[string]any{"role": "user", "content": []any{
+				map[string]any{"type": "text", "text": "my key is sk-abcdefghijklmnopqrstuvwx"},
+				map[string]any{"type": "image_url", "image_url": map[string]any{"url": "data:image/png;base64,iVBORw0KGgo="}},
+				map[string]any{"type": "image_url", "image_url": map[string]any{"url": "https://example.com/a.png"}},
+			}},
+		},
+	}
+
+	cfg := &config.ServerConfig{LogFormat: "json", LogLevel: slog.LevelDebug, LogBodyMax: 4096, LogRedactBuiltin: true, UpstreamURL: "http://upstream"}
+	buf := capture(t, cfg)
+	LogForwardedRequest("r1", cfg, in, out)
+	recs := records(t, buf)
+
+	summary, _ := recs["inbound summary"]["summary"].(string)
+	if !strings.Contains(summary, `"messages":2`) || !strings.Contains(summary, `"model":"claude-x"`) {
+		t.Errorf("summary = %q", summary)
+	}
+	if h, _ := recs["forward request"]["headers"].(string); !strings.Contains(h, `Bearer \u003credacted\u003e`) {
+		t.Errorf("headers = %q", h)
+	}
+	body, _ := recs["forward body"]["body"].(string)
+	for _, want := range []string{"[REDACTED:api_key]", `data:\u003credacted\u003e`, "https://example.com/a.png"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body missing %q: %s", want, body)
+		}
+	}
+	for _, leak := range []string{"sk-abcdefghijklmnopqrstuvwx", "iVBORw0KGgo="} {
+		if strings.Contains(body, leak) {
+			t.Errorf("body leaks %q: %s", leak, body)
+		}
+	}
+	// Sanitizing copies; the request that goes upstream keeps its data.
+	parts := out.Messages[0].(map[string]any)["content"].([]any)
+	if url := parts[1].(map[string]any)["image_url"].(map[string]any)["url"]; url != "data:image/png;base64,iVBORw0KGgo=" {
+		t.Errorf("outbound image url modified to %v", url)
+	}
+}
+
+func TestLogBodyLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		max     int
+		body    string
+		want    string
+		wantLog bool
+	}{
+		{name: "disabled", max: 0, body: `{"a":1}`},
+		{name: "fits", max: 100, body: `{"a":1}`, want: `{"a":1}`, wantLog: true},
+		{name: "truncated by runes", max: 3, body: `äöüß`, want: `äöü...(truncated)`, wantLog: true},
+		{name: "redacted before truncation", max: 12, body: `sk-abcdefghijklmnopqrstuvwx`, want: `[REDACTED:ap...(truncated)`, wantLog: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.ServerConfig{LogFormat: "json", LogBodyMax: tt.max, LogRedactBuiltin: true}
+			buf := capture(t, cfg)
+			LogForwardedUpstreamBody("r1", cfg, []byte(tt.body))
+			rec, ok := records(t, buf)["upstream body"]
+			if ok != tt.wantLog {
+				t.Fatalf("logged = %v, want %v", ok, tt.wantLog)
+			}
+			if ok && rec["body"] != tt.want {
+				t.Errorf("body = %q, want %q", rec["body"], tt.want)
+			}
+		})
+	}
+
+	if got := mustJSONTrunc(map[string]int{"a": 1}, 0); got != "(disabled)" {
+		t.Errorf("mustJSONTrunc with max 0 = %q", got)
+	}
+}
+
+func TestTakeFirstRunes(t *testing.T) {
+	tests := []struct {
+		s    string
+		max  int
+		want string
+	}{
+		{"hello", 0, ""},
+		{"hello", -1, ""},
+		{"", 3, ""},
+		{"hello", 10, "hello"},
+		{"héllo", 2, "hé"},
+	}
+	for _, tt := range tests {
+		if got := TakeFirstRunes(tt.s, tt.max); got != tt.want {
+			t.Errorf("TakeFirstRunes(%q, %d) = %q, want %q", tt.s, tt.max, got, tt.want)
+		}
+	}
+}
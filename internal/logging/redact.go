@@ -0,0 +1,67 @@
+package logging
+
+import (
+	"regexp"
+	"sort"
+
+	"claude-nvidia-proxy/internal/config"
+)
+
+type redactRule struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// builtinRedactRules run in order, so private keys and JWTs are masked
+// before the broader API key patterns see them.
+var builtinRedactRules = []redactRule{
+	{"private_key", regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`)},
+	{"jwt", regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{5,}\.eyJ[A-Za-z0-9_-]{5,}\.[A-Za-z0-9_-]{10,}`)},
+	{"aws_access_key", regexp.MustCompile(`\b(?:AKIA|ASIA|ABIA|ACCA)[0-9A-Z]{16}\b`)},
+	{"aws_secret_key", regexp.MustCompile(`(?i)aws_?secret_?access_?key[\\"']*\s*[:=]\s*[\\"']*[A-Za-z0-9/+=]{40}`)},
+	{"api_key", regexp.MustCompile(`\b(?:sk-[A-Za-z0-9_-]{20,}|nvapi-[A-Za-z0-9_-]{20,}|gh[pousr]_[A-Za-z0-9]{36,}|xox[abprs]-[A-Za-z0-9-]{10,}|AIza[0-9A-Za-z_-]{35})|(?i:bearer)\s+[A-Za-z0-9._~+/-]{20,}=*`)},
+	{"email", regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`)},
+}
+
+// Redactor replaces secrets in log text with [REDACTED:<rule>].
+type Redactor struct {
+	rules []redactRule
+}
+
+// NewRedactor uses the built-in detectors when builtin is set, followed by
+// custom patterns in name order.
+func NewRedactor(builtin bool, custom map[string]*regexp.Regexp) *Redactor {
+	r := &Redactor{}
+	if builtin {
+		r.rules = append(r.rules, builtinRedactRules...)
+	}
+	names := make([]string, 0, len(custom))
+	for name := range custom {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r.rules = append(r.rules, redactRule{name, custom[name]})
+	}
+	return r
+}
+
+// Redact masks every match in s.
+func (r *Redactor) Redact(s string) string {
+	for _, rule := range r.rules {
+		s = rule.re.ReplaceAllLiteralString(s, "[REDACTED:"+rule.name+"]")
+	}
+	return s
+}
+
+// redactor is replaced by Setup; until then the built-in detectors apply.
+var redactor = NewRedactor(true, nil)
+
+func setupRedactor(cfg *config.ServerConfig) {
+	redactor = NewRedactor(cfg.LogRedactBuiltin, cfg.LogRedactPatterns)
+}
+
+// Redact masks secrets in s using the configured rules.
+func Redact(s string) string {
+	return redactor.Redact(s)
+}
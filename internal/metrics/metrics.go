@@ -0,0 +1,253 @@
+// Package metrics is a small Prometheus text-format registry with counters,
+// gauges and histograms keyed by label values.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds metrics in registration order.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// Write writes every metric in the Prometheus text exposition format.
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Handler serves the registry at a /metrics endpoint.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+// vec stores one value per combination of label values.
+type vec[T any] struct {
+	name, help, kind string
+	labels           []string
+
+	mu     sync.Mutex
+	series map[string]*T
+	values map[string][]string
+}
+
+func newVec[T any](name, help, kind string, labels []string) *vec[T] {
+	return &vec[T]{name: name, help: help, kind: kind, labels: labels, series: map[string]*T{}, values: map[string][]string{}}
+}
+
+// get returns the series for lvs, creating it with init. The caller holds v.mu.
+func (v *vec[T]) get(lvs []string, init func() *T) *T {
+	if len(lvs) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", v.name, len(v.labels), len(lvs)))
+	}
+	key := strings.Join(lvs, "\xff")
+	s := v.series[key]
+	if s == nil {
+		s = init()
+		v.series[key] = s
+		v.values[key] = append([]string(nil), lvs...)
+	}
+	return s
+}
+
+// sorted returns the series keys in a stable order. The caller holds v.mu.
+func (v *vec[T]) sorted() []string {
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (v *vec[T]) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, v.kind)
+}
+
+// labelString renders {a="x",b="y"} plus any extra pairs.
+func labelString(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	write := func(n, v string) {
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		b.WriteString(n)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(v))
+		b.WriteByte('"')
+	}
+	for i, n := range names {
+		write(n, values[i])
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		write(extra[i], extra[i+1])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// CounterVec is a monotonically increasing value per label set.
+type CounterVec struct {
+	v *vec[float64]
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{v: newVec[float64](name, help, "counter", labels)}
+	r.register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(lvs ...string) {
+	c.Add(1, lvs...)
+}
+
+func (c *CounterVec) Add(delta float64, lvs ...string) {
+	if delta < 0 {
+		return
+	}
+	c.v.mu.Lock()
+	defer c.v.mu.Unlock()
+	*c.v.get(lvs, func() *float64 { return new(float64) }) += delta
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.v.mu.Lock()
+	defer c.v.mu.Unlock()
+	c.v.header(w)
+	if len(c.v.labels) == 0 && len(c.v.series) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.v.name)
+	}
+	for _, k := range c.v.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", c.v.name, labelString(c.v.labels, c.v.values[k]), formatFloat(*c.v.series[k]))
+	}
+}
+
+// GaugeVec is a value per label set that can go up and down.
+type GaugeVec struct {
+	v *vec[float64]
+}
+
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{v: newVec[float64](name, help, "gauge", labels)}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeVec) Add(delta float64, lvs ...string) {
+	g.v.mu.Lock()
+	defer g.v.mu.Unlock()
+	*g.v.get(lvs, func() *float64 { return new(float64) }) += delta
+}
+
+func (g *GaugeVec) Inc(lvs ...string) { g.Add(1, lvs...) }
+func (g *GaugeVec) Dec(lvs ...string) { g.Add(-1, lvs...) }
+
+func (g *GaugeVec) write(w io.Writer) {
+	g.v.mu.Lock()
+	defer g.v.mu.Unlock()
+	g.v.header(w)
+	if len(g.v.labels) == 0 && len(g.v.series) == 0 {
+		fmt.Fprintf(w, "%s 0\n", g.v.name)
+	}
+	for _, k := range g.v.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", g.v.name, labelString(g.v.labels, g.v.values[k]), formatFloat(*g.v.series[k]))
+	}
+}
+
+// DefaultLatencyBuckets suit request latencies in seconds, from 50ms to
+// five minutes.
+var DefaultLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// HistogramVec counts observations into fixed buckets per label set.
+type HistogramVec struct {
+	v       *vec[histogram]
+	buckets []float64
+}
+
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{v: newVec[histogram](name, help, "histogram", labels), buckets: buckets}
+	r.register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(value float64, lvs ...string) {
+	h.v.mu.Lock()
+	defer h.v.mu.Unlock()
+	s := h.v.get(lvs, func() *histogram { return &histogram{counts: make([]uint64, len(h.buckets))} })
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += value
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.v.mu.Lock()
+	defer h.v.mu.Unlock()
+	h.v.header(w)
+	for _, k := range h.v.sorted() {
+		s, lvs := h.v.series[k], h.v.values[k]
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.v.name, labelString(h.v.labels, lvs, "le", formatFloat(le)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.v.name, labelString(h.v.labels, lvs, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.v.name, labelString(h.v.labels, lvs), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.v.name, labelString(h.v.labels, lvs), s.count)
+	}
+}
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("requests_total", "Requests.", "model", "status")
+	empty := r.NewCounterVec("retries_total", "Unlabelled counter.")
+	g := r.NewGaugeVec("inflight", "In flight.")
+	h := r.NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "model")
+
+	c.Inc("b", "200")
+	c.Add(2, "a", "500")
+	c.Add(-5, "a", "500") // counters never go down
+	c.Inc(`we"ird\`+"\n", "200")
+	g.Inc()
+	g.Inc()
+	g.Dec()
+	h.Observe(0.1, "m")
+	h.Observe(0.5, "m")
+	h.Observe(3, "m")
+	_ = empty
+
+	var b strings.Builder
+	r.Write(&b)
+	want := `# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{model="a",status="500"} 2
+requests_total{model="b",status="200"} 1
+requests_total{model="we\"ird\\\n",status="200"} 1
+# HELP retries_total Unlabelled counter.
+# TYPE retries_total counter
+retries_total 0
+# HELP inflight In flight.
+# TYPE inflight gauge
+inflight 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{model="m",le="0.1"} 1
+latency_seconds_bucket{model="m",le="1"} 2
+latency_seconds_bucket{model="m",le="+Inf"} 3
+latency_seconds_sum{model="m"} 3.6
+latency_seconds_count{model="m"} 3
+`
+	if b.String() != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestRegistryHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("x_total", "X.").Inc()
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if !strings.Contains(rec.Body.String(), "x_total 1\n") {
+		t.Errorf("body = %s", rec.Body)
+	}
+}
+
+func TestLabelCountMismatchPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("wrong label count did not panic")
+		}
+	}()
+	NewRegistry().NewCounterVec("x_total", "X.", "a").Inc("1", "2")
+}
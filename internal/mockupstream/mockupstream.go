@@ -0,0 +1,369 @@
+// Package mockupstream is a scripted OpenAI-compatible chat completions
+// server for testing the proxy without a real upstream. Each request is
+// answered by the first fixture that matches it.
+package mockupstream
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fixture scripts one kind of response.
+type Fixture struct {
+	Name  string `json:"name"`
+	Match Match  `json:"match,omitempty"`
+
+	// Status defaults to 200. Body, when set, is sent verbatim instead of a
+	// generated response: an error payload, malformed JSON or hand-written
+	// SSE frames.
+	Status int    `json:"status,omitempty"`
+	Body   string `json:"body,omitempty"`
+
+	// DelayMs waits before the response headers; ChunkDelayMs between
+	// stream events.
+	DelayMs      int `json:"delay_ms,omitempty"`
+	ChunkDelayMs int `json:"chunk_delay_ms,omitempty"`
+
+	Message      Message `json:"message"`
+	FinishReason string  `json:"finish_reason,omitempty"`
+	Usage        *Usage  `json:"usage,omitempty"`
+
+	// ChunkRunes splits content, reasoning and tool arguments into deltas
+	// of this many runes; 0 sends each in a single delta.
+	ChunkRunes int `json:"chunk_runes,omitempty"`
+	// BreakAfter drops the connection after this many stream events.
+	BreakAfter int `json:"break_after,omitempty"`
+	// OmitDone ends the stream without "data: [DONE]".
+	OmitDone bool `json:"omit_done,omitempty"`
+}
+
+// Match selects requests by model and by a substring of the request body.
+// An empty Match matches everything.
+type Match struct {
+	Model    string `json:"model,omitempty"`
+	Contains string `json:"contains,omitempty"`
+}
+
+type Message struct {
+	Content          string     `json:"content,omitempty"`
+	ReasoningContent string     `json:"reasoning_content,omitempty"`
+	ToolCalls        []ToolCall `json:"tool_calls,omitempty"`
+}
+
+type ToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	CachedTokens     int `json:"cached_tokens,omitempty"`
+}
+
+// Request is a request the server received.
+type Request struct {
+	Model  string
+	Stream bool
+	Header http.Header
+	Body   []byte
+}
+
+// Server answers chat completion requests from its fixtures.
+type Server struct {
+	mu       sync.Mutex
+	fixtures []Fixture
+	requests []Request
+}
+
+// New serves fixtures in order. Requests that match none of them get a
+// plain text reply.
+func New(fixtures ...Fixture) *Server {
+	return &Server{fixtures: fixtures}
+}
+
+// DefaultFixture answers every request with a short text reply.
+var DefaultFixture = Fixture{
+	Name:         "default",
+	Message:      Message{Content: "Hello from the mock upstream."},
+	FinishReason: "stop",
+	Usage:        &Usage{PromptTokens: 10, CompletionTokens: 7},
+}
+
+// Load reads fixtures from a JSON file holding one fixture or an array of
+// them, or from every .json file in a directory, in name order.
+func Load(path string) ([]Fixture, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		if files, err = filepath.Glob(filepath.Join(path, "*.json")); err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+	}
+	var out []Fixture
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		var many []Fixture
+		if err := json.Unmarshal(b, &many); err == nil {
+			out = append(out, many...)
+			continue
+		}
+		var one Fixture
+		if err := json.Unmarshal(b, &one); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", f, err)
+		}
+		out = append(out, one)
+	}
+	return out, nil
+}
+
+// Requests returns the requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Model         string `json:"model"`
+		Stream        bool   `json:"stream"`
+		StreamOptions *struct {
+			IncludeUsage bool `json:"include_usage"`
+		} `json:"stream_options"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Model: req.Model, Stream: req.Stream, Header: r.Header.Clone(), Body: body})
+	f := DefaultFixture
+	for _, candidate := range s.fixtures {
+		if candidate.matches(req.Model, body) {
+			f = candidate
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	if !sleep(r, f.DelayMs) {
+		return
+	}
+	status := f.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	streaming := req.Stream && status/100 == 2
+	if streaming {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+
+	if f.Body != "" {
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, f.Body)
+		return
+	}
+	if status/100 != 2 {
+		writeError(w, status, fmt.Sprintf("mock upstream error (fixture %s)", f.Name))
+		return
+	}
+	if !streaming {
+		_ = json.NewEncoder(w).Encode(f.completion(req.Model))
+		return
+	}
+	includeUsage := req.StreamOptions != nil && req.StreamOptions.IncludeUsage
+	s.stream(w, r, f, req.Model, includeUsage)
+}
+
+func (f Fixture) matches(model string, body []byte) bool {
+	if f.Match.Model != "" && f.Match.Model != model {
+		return false
+	}
+	return f.Match.Contains == "" || strings.Contains(string(body), f.Match.Contains)
+}
+
+func (f Fixture) finishReason() string {
+	switch {
+	case f.FinishReason != "":
+		return f.FinishReason
+	case len(f.Message.ToolCalls) > 0:
+		return "tool_calls"
+	default:
+		return "stop"
+	}
+}
+
+func (u *Usage) json() map[string]any {
+	out := map[string]any{
+		"prompt_tokens":     u.PromptTokens,
+		"completion_tokens": u.CompletionTokens,
+		"total_tokens":      u.PromptTokens + u.CompletionTokens,
+	}
+	if u.CachedTokens > 0 {
+		out["prompt_tokens_details"] = map[string]any{"cached_tokens": u.CachedTokens}
+	}
+	return out
+}
+
+// completion builds a non-streaming chat completion response.
+func (f Fixture) completion(model string) map[string]any {
+	msg := map[string]any{"role": "assistant", "content": f.Message.Content}
+	if f.Message.ReasoningContent != "" {
+		msg["reasoning_content"] = f.Message.ReasoningContent
+	}
+	if len(f.Message.ToolCalls) > 0 {
+		calls := make([]any, 0, len(f.Message.ToolCalls))
+		for _, tc := range f.Message.ToolCalls {
+			calls = append(calls, map[string]any{
+				"id":       tc.ID,
+				"type":     "function",
+				"function": map[string]any{"name": tc.Name, "arguments": tc.Arguments},
+			})
+		}
+		msg["tool_calls"] = calls
+	}
+	out := map[string]any{
+		"id":      "chatcmpl-mock",
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   model,
+		"choices": []any{map[string]any{"index": 0, "message": msg, "finish_reason": f.finishReason()}},
+	}
+	if f.Usage != nil {
+		out["usage"] = f.Usage.json()
+	}
+	return out
+}
+
+// chunks builds the stream as chat.completion.chunk deltas.
+func (f Fixture) chunks(model string, includeUsage bool) []map[string]any {
+	chunk := func(delta map[string]any, finish any) map[string]any {
+		return map[string]any{
+			"id":      "chatcmpl-mock",
+			"object":  "chat.completion.chunk",
+			"created": time.Now().Unix(),
+			"model":   model,
+			"choices": []any{map[string]any{"index": 0, "delta": delta, "finish_reason": finish}},
+		}
+	}
+	out := []map[string]any{chunk(map[string]any{"role": "assistant", "content": ""}, nil)}
+	for _, piece := range splitRunes(f.Message.ReasoningContent, f.ChunkRunes) {
+		out = append(out, chunk(map[string]any{"reasoning_content": piece}, nil))
+	}
+	for _, piece := range splitRunes(f.Message.Content, f.ChunkRunes) {
+		out = append(out, chunk(map[string]any{"content": piece}, nil))
+	}
+	for i, tc := range f.Message.ToolCalls {
+		out = append(out, chunk(map[string]any{"tool_calls": []any{map[string]any{
+			"index":    i,
+			"id":       tc.ID,
+			"type":     "function",
+			"function": map[string]any{"name": tc.Name, "arguments": ""},
+		}}}, nil))
+		for _, piece := range splitRunes(tc.Arguments, f.ChunkRunes) {
+			out = append(out, chunk(map[string]any{"tool_calls": []any{map[string]any{
+				"index":    i,
+				"function": map[string]any{"arguments": piece},
+			}}}, nil))
+		}
+	}
+	out = append(out, chunk(map[string]any{}, f.finishReason()))
+	if includeUsage && f.Usage != nil {
+		out = append(out, map[string]any{
+			"id":      "chatcmpl-mock",
+			"object":  "chat.completion.chunk",
+			"created": time.Now().Unix(),
+			"model":   model,
+			"choices": []any{},
+			"usage":   f.Usage.json(),
+		})
+	}
+	return out
+}
+
+func (s *Server) stream(w http.ResponseWriter, r *http.Request, f Fixture, model string, includeUsage bool) {
+	flusher, _ := w.(http.Flusher)
+	w.WriteHeader(http.StatusOK)
+	for i, c := range f.chunks(model, includeUsage) {
+		if f.BreakAfter > 0 && i == f.BreakAfter {
+			// Abort the connection mid-stream, as a crashed upstream would.
+			panic(http.ErrAbortHandler)
+		}
+		if i > 0 && !sleep(r, f.ChunkDelayMs) {
+			return
+		}
+		b, _ := json.Marshal(c)
+		fmt.Fprintf(w, "data: %s\n\n", b)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	if !f.OmitDone {
+		_, _ = io.WriteString(w, "data: [DONE]\n\n")
+	}
+}
+
+// splitRunes splits s into pieces of n runes; n <= 0 keeps it whole.
+func splitRunes(s string, n int) []string {
+	if s == "" {
+		return nil
+	}
+	r := []rune(s)
+	if n <= 0 || n >= len(r) {
+		return []string{s}
+	}
+	var out []string
+	for len(r) > 0 {
+		k := min(n, len(r))
+		out = append(out, string(r[:k]))
+		r = r[k:]
+	}
+	return out
+}
+
+// sleep waits ms milliseconds and reports false if the client went away.
+func sleep(r *http.Request, ms int) bool {
+	if ms <= 0 {
+		return true
+	}
+	select {
+	case <-time.After(time.Duration(ms) * time.Millisecond):
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{"message": message, "type": "mock_error", "code": status},
+	})
+}
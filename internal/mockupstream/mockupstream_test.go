@@ -0,0 +1,202 @@
+package mockupstream
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func post(t *testing.T, url, body string) *http.Response {
+	t.Helper()
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// readEvents returns the data payloads of an SSE stream and any read error.
+func readEvents(r io.Reader) ([]string, error) {
+	var out []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		if data, ok := strings.CutPrefix(sc.Text(), "data: "); ok {
+			out = append(out, data)
+		}
+	}
+	return out, sc.Err()
+}
+
+func TestLoadTestdata(t *testing.T) {
+	fixtures, err := Load("testdata/fixtures.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) == 0 {
+		t.Fatal("no fixtures loaded")
+	}
+	dirFixtures, err := Load("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirFixtures) != len(fixtures) {
+		t.Fatalf("directory load = %d fixtures, want %d", len(dirFixtures), len(fixtures))
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	srv := httptest.NewServer(New(Fixture{
+		Message: Message{
+			Content:   "checking",
+			ToolCalls: []ToolCall{{ID: "call_1", Name: "lookup", Arguments: `{"q":"x"}`}},
+		},
+		Usage: &Usage{PromptTokens: 3, CompletionTokens: 4},
+	}))
+	defer srv.Close()
+
+	resp := post(t, srv.URL, `{"model":"m","messages":[]}`)
+	var got struct {
+		Model   string `json:"model"`
+		Choices []struct {
+			Message struct {
+				Content   string `json:"content"`
+				ToolCalls []struct {
+					ID       string `json:"id"`
+					Function struct {
+						Name      string `json:"name"`
+						Arguments string `json:"arguments"`
+					} `json:"function"`
+				} `json:"tool_calls"`
+			} `json:"message"`
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+		Usage struct {
+			TotalTokens int `json:"total_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Model != "m" || len(got.Choices) != 1 {
+		t.Fatalf("unexpected response: %+v", got)
+	}
+	c := got.Choices[0]
+	if c.Message.Content != "checking" || c.FinishReason != "tool_calls" {
+		t.Errorf("content=%q finish_reason=%q", c.Message.Content, c.FinishReason)
+	}
+	if len(c.Message.ToolCalls) != 1 || c.Message.ToolCalls[0].ID != "call_1" || c.Message.ToolCalls[0].Function.Arguments != `{"q":"x"}` {
+		t.Errorf("tool_calls = %+v", c.Message.ToolCalls)
+	}
+	if got.Usage.TotalTokens != 7 {
+		t.Errorf("total_tokens = %d, want 7", got.Usage.TotalTokens)
+	}
+}
+
+func TestStream(t *testing.T) {
+	srv := httptest.NewServer(New(Fixture{
+		Message:    Message{ReasoningContent: "hmm", Content: "abcdef"},
+		ChunkRunes: 2,
+		Usage:      &Usage{PromptTokens: 1, CompletionTokens: 2},
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name      string
+		body      string
+		wantUsage bool
+	}{
+		{"without usage", `{"model":"m","stream":true}`, false},
+		{"with usage", `{"model":"m","stream":true,"stream_options":{"include_usage":true}}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := post(t, srv.URL, tt.body)
+			if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+				t.Fatalf("Content-Type = %q", ct)
+			}
+			events, err := readEvents(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if events[len(events)-1] != "[DONE]" {
+				t.Fatalf("last event = %q, want [DONE]", events[len(events)-1])
+			}
+			var content, reasoning strings.Builder
+			sawUsage := false
+			for _, e := range events[:len(events)-1] {
+				var chunk struct {
+					Choices []struct {
+						Delta struct {
+							Content          string `json:"content"`
+							ReasoningContent string `json:"reasoning_content"`
+						} `json:"delta"`
+					} `json:"choices"`
+					Usage *struct{} `json:"usage"`
+				}
+				if err := json.Unmarshal([]byte(e), &chunk); err != nil {
+					t.Fatalf("bad chunk %q: %v", e, err)
+				}
+				for _, c := range chunk.Choices {
+					content.WriteString(c.Delta.Content)
+					reasoning.WriteString(c.Delta.ReasoningContent)
+				}
+				sawUsage = sawUsage || chunk.Usage != nil
+			}
+			if content.String() != "abcdef" || reasoning.String() != "hmm" {
+				t.Errorf("content=%q reasoning=%q", content.String(), reasoning.String())
+			}
+			if sawUsage != tt.wantUsage {
+				t.Errorf("usage chunk = %v, want %v", sawUsage, tt.wantUsage)
+			}
+		})
+	}
+}
+
+func TestBrokenStream(t *testing.T) {
+	srv := httptest.NewServer(New(Fixture{Message: Message{Content: "abcdef"}, ChunkRunes: 1, BreakAfter: 3}))
+	defer srv.Close()
+
+	resp := post(t, srv.URL, `{"model":"m","stream":true}`)
+	events, err := readEvents(resp.Body)
+	if err == nil {
+		t.Error("expected a read error from the aborted connection")
+	}
+	if len(events) != 3 {
+		t.Errorf("got %d events before the break, want 3", len(events))
+	}
+}
+
+func TestErrorsAndMatching(t *testing.T) {
+	s := New(
+		Fixture{Name: "limited", Match: Match{Contains: "trigger-429"}, Status: http.StatusTooManyRequests},
+		Fixture{Name: "raw", Match: Match{Model: "raw"}, Status: http.StatusBadGateway, Body: "upstream exploded"},
+	)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	tests := []struct {
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{`{"model":"m","messages":[{"content":"trigger-429"}]}`, http.StatusTooManyRequests, "fixture limited"},
+		{`{"model":"raw","stream":true}`, http.StatusBadGateway, "upstream exploded"},
+		{`{"model":"m"}`, http.StatusOK, DefaultFixture.Message.Content},
+		{`not json`, http.StatusBadRequest, "invalid JSON"},
+	}
+	for _, tt := range tests {
+		resp := post(t, srv.URL, tt.body)
+		b, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != tt.wantStatus || !strings.Contains(string(b), tt.wantBody) {
+			t.Errorf("%s: status=%d body=%q, want %d containing %q", tt.body, resp.StatusCode, b, tt.wantStatus, tt.wantBody)
+		}
+	}
+	if got := len(s.Requests()); got != 3 {
+		t.Errorf("recorded %d requests, want 3", got)
+	}
+}
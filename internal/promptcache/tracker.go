@@ -0,0 +1,78 @@
+package promptcache
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"claude-nvidia-proxy/internal/converter"
+)
+
+// Tracker remembers which cache_control prefixes each client has sent
+// recently, so the proxy can report Anthropic-style cache creation and read
+// token counts for upstreams that don't report prompt caching.
+type Tracker struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	seen       map[string]time.Time
+}
+
+func NewTracker(ttl time.Duration, maxEntries int) *Tracker {
+	return &Tracker{ttl: ttl, maxEntries: maxEntries, seen: map[string]time.Time{}}
+}
+
+// Observe returns the estimated cache read and creation tokens for a request
+// from client and records its breakpoints. The longest previously seen prefix
+// counts as read; the rest of the longest marked prefix counts as created.
+func (t *Tracker) Observe(client string, breakpoints []converter.CacheBreakpoint) (read, creation int) {
+	if len(breakpoints) == 0 {
+		return 0, 0
+	}
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i := len(breakpoints) - 1; i >= 0; i-- {
+		if exp, ok := t.seen[client+"|"+breakpoints[i].Hash]; ok && now.Before(exp) {
+			read = breakpoints[i].Tokens
+			break
+		}
+	}
+	if last := breakpoints[len(breakpoints)-1].Tokens; last > read {
+		creation = last - read
+	}
+
+	if n := len(breakpoints); n > t.maxEntries {
+		breakpoints = breakpoints[n-t.maxEntries:]
+	}
+	if len(t.seen)+len(breakpoints) > t.maxEntries {
+		t.evict(now, len(breakpoints))
+	}
+	for _, bp := range breakpoints {
+		t.seen[client+"|"+bp.Hash] = now.Add(t.ttl)
+	}
+	return read, creation
+}
+
+// evict makes room for incoming prefixes by dropping expired ones and then
+// those closest to expiry, down to 90% of the cap so that a full table is
+// not rescanned on every request.
+func (t *Tracker) evict(now time.Time, incoming int) {
+	for k, exp := range t.seen {
+		if !now.Before(exp) {
+			delete(t.seen, k)
+		}
+	}
+	keep := max(t.maxEntries*9/10-incoming, 0)
+	if len(t.seen) <= keep {
+		return
+	}
+	keys := slices.Collect(maps.Keys(t.seen))
+	slices.SortFunc(keys, func(a, b string) int { return t.seen[a].Compare(t.seen[b]) })
+	for _, k := range keys[:len(keys)-keep] {
+		delete(t.seen, k)
+	}
+}
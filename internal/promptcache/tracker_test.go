@@ -0,0 +1,99 @@
+package promptcache
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"claude-nvidia-proxy/internal/converter"
+)
+
+func TestTrackerObserve(t *testing.T) {
+	sys := converter.CacheBreakpoint{Hash: "sys", Tokens: 100}
+	doc := converter.CacheBreakpoint{Hash: "doc", Tokens: 400}
+	other := converter.CacheBreakpoint{Hash: "other", Tokens: 250}
+
+	tr := NewTracker(time.Minute, 100)
+	tests := []struct {
+		name           string
+		client         string
+		breakpoints    []converter.CacheBreakpoint
+		read, creation int
+	}{
+		{"no breakpoints", "a", nil, 0, 0},
+		{"first request creates", "a", []converter.CacheBreakpoint{sys}, 0, 100},
+		{"repeat reads", "a", []converter.CacheBreakpoint{sys}, 100, 0},
+		{"longer prefix reads the known part", "a", []converter.CacheBreakpoint{sys, doc}, 100, 300},
+		{"longest known prefix wins", "a", []converter.CacheBreakpoint{sys, doc}, 400, 0},
+		{"diverging suffix", "a", []converter.CacheBreakpoint{sys, other}, 100, 150},
+		{"clients are separate", "b", []converter.CacheBreakpoint{sys}, 0, 100},
+	}
+	for _, tt := range tests {
+		read, creation := tr.Observe(tt.client, tt.breakpoints)
+		if read != tt.read || creation != tt.creation {
+			t.Errorf("%s: read %d, creation %d; want %d, %d", tt.name, read, creation, tt.read, tt.creation)
+		}
+	}
+}
+
+func TestTrackerExpiry(t *testing.T) {
+	bp := []converter.CacheBreakpoint{{Hash: "p", Tokens: 50}}
+	tr := NewTracker(-time.Second, 100)
+	tr.Observe("a", bp)
+	if read, creation := tr.Observe("a", bp); read != 0 || creation != 50 {
+		t.Errorf("expired prefix: read %d, creation %d", read, creation)
+	}
+}
+
+func TestTrackerEviction(t *testing.T) {
+	tr := NewTracker(time.Minute, 3)
+	for _, h := range []string{"a", "b", "c", "d", "e"} {
+		tr.Observe("client", []converter.CacheBreakpoint{{Hash: h, Tokens: 10}})
+		if len(tr.seen) > 3 {
+			t.Fatalf("tracker holds %d prefixes, max 3", len(tr.seen))
+		}
+	}
+	if read, _ := tr.Observe("client", []converter.CacheBreakpoint{{Hash: "e", Tokens: 10}}); read != 10 {
+		t.Error("most recent prefix was evicted")
+	}
+}
+
+func TestTrackerEvictionBatch(t *testing.T) {
+	tr := NewTracker(time.Minute, 10)
+	for i := 0; i < 30; i++ {
+		var bps []converter.CacheBreakpoint
+		for j := 0; j < 4; j++ {
+			bps = append(bps, converter.CacheBreakpoint{Hash: strconv.Itoa(i) + "/" + strconv.Itoa(j), Tokens: 10 * (j + 1)})
+		}
+		tr.Observe("client", bps)
+		if len(tr.seen) > 10 {
+			t.Fatalf("request %d: tracker holds %d prefixes, max 10", i, len(tr.seen))
+		}
+		if read, _ := tr.Observe("client", bps); read != 40 {
+			t.Fatalf("request %d: its own prefixes were evicted", i)
+		}
+	}
+
+	// A full table is trimmed in batches, not on every request.
+	tr = NewTracker(time.Minute, 100)
+	evictions := 0
+	for i := 0; i < 300; i++ {
+		before := len(tr.seen)
+		tr.Observe("client", []converter.CacheBreakpoint{{Hash: strconv.Itoa(i), Tokens: 10}})
+		if len(tr.seen) <= before {
+			evictions++
+		}
+	}
+	if evictions > 25 {
+		t.Errorf("evicted on %d of 300 requests", evictions)
+	}
+
+	tr = NewTracker(time.Minute, 2)
+	tr.Observe("client", []converter.CacheBreakpoint{{Hash: "a", Tokens: 1}, {Hash: "b", Tokens: 2}, {Hash: "c", Tokens: 3}})
+	if len(tr.seen) > 2 {
+		t.Errorf("tracker holds %d prefixes, max 2", len(tr.seen))
+	}
+	if read, _ := tr.Observe("client", []converter.CacheBreakpoint{{Hash: "c", Tokens: 3}}); read != 3 {
+		t.Error("longest prefix was not kept")
+	}
+}
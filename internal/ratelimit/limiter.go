@@ -0,0 +1,261 @@
+// Package ratelimit enforces per-scope token-bucket limits on requests and
+// tokens per minute plus a cap on concurrent streams.
+package ratelimit
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Limits caps one scope (an API key or a model). Zero means unlimited.
+type Limits struct {
+	RequestsPerMinute    int `json:"requests_per_minute,omitempty"`
+	TokensPerMinute      int `json:"tokens_per_minute,omitempty"`
+	MaxConcurrentStreams int `json:"max_concurrent_streams,omitempty"`
+}
+
+func (l Limits) IsZero() bool {
+	return l.RequestsPerMinute <= 0 && l.TokensPerMinute <= 0 && l.MaxConcurrentStreams <= 0
+}
+
+// Scope names a bucket set, e.g. "key:3f2a..." or "model:z-ai/glm4.7".
+type Scope struct {
+	Name   string
+	Limits Limits
+}
+
+// maxIdleScopes is the scope count above which idle scopes are dropped.
+const maxIdleScopes = 10000
+
+// bucket holds up to capacity units and refills at capacity per minute. Its
+// level may go negative when actual usage exceeds what was reserved.
+type bucket struct {
+	level float64
+	last  time.Time
+}
+
+func (b *bucket) refill(capacity int, now time.Time) {
+	if b.last.IsZero() {
+		b.level, b.last = float64(capacity), now
+		return
+	}
+	b.level += now.Sub(b.last).Minutes() * float64(capacity)
+	if b.level > float64(capacity) {
+		b.level = float64(capacity)
+	}
+	b.last = now
+}
+
+// wait is how long until the bucket holds n units.
+func (b *bucket) wait(capacity int, n float64) time.Duration {
+	if b.level >= n {
+		return 0
+	}
+	return time.Duration((n - b.level) / float64(capacity) * float64(time.Minute))
+}
+
+type scopeState struct {
+	limits   Limits
+	requests bucket
+	tokens   bucket
+	streams  int
+}
+
+func (st *scopeState) idle(now time.Time) bool {
+	st.requests.refill(st.limits.RequestsPerMinute, now)
+	st.tokens.refill(st.limits.TokensPerMinute, now)
+	return st.streams == 0 &&
+		st.requests.level >= float64(st.limits.RequestsPerMinute) &&
+		st.tokens.level >= float64(st.limits.TokensPerMinute)
+}
+
+// Limiter tracks every scope's buckets. It is safe for concurrent use.
+type Limiter struct {
+	mu     sync.Mutex
+	scopes map[string]*scopeState
+	now    func() time.Time
+}
+
+func New() *Limiter {
+	return &Limiter{scopes: map[string]*scopeState{}, now: time.Now}
+}
+
+// Denied reports the scope that rejected a request and when to retry.
+type Denied struct {
+	Scope      string
+	Reason     string
+	RetryAfter time.Duration
+	Status     []Status
+}
+
+func (d *Denied) Error() string {
+	return fmt.Sprintf("rate limit exceeded for %s: %s", d.Scope, d.Reason)
+}
+
+// Status is a scope's remaining allowance.
+type Status struct {
+	Scope             string
+	Limits            Limits
+	RequestsRemaining int
+	RequestsReset     time.Duration
+	TokensRemaining   int
+	TokensReset       time.Duration
+	Streams           int
+}
+
+// Lease is an admitted request. Settle it with the actual token count and
+// Release it when the request finishes.
+type Lease struct {
+	l        *Limiter
+	scopes   []string
+	reserved int
+	stream   bool
+	settled  bool
+	released bool
+	status   []Status
+}
+
+// Acquire admits a request estimated at tokens against every scope, or
+// returns *Denied without charging any of them.
+func (l *Limiter) Acquire(scopes []Scope, tokens int, stream bool) (*Lease, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+
+	states := make([]*scopeState, len(scopes))
+	for i, sc := range scopes {
+		st := l.scopes[sc.Name]
+		if st == nil {
+			if len(l.scopes) >= maxIdleScopes {
+				l.evictIdle(now)
+			}
+			st = &scopeState{}
+			l.scopes[sc.Name] = st
+		}
+		st.limits = sc.Limits
+		st.requests.refill(sc.Limits.RequestsPerMinute, now)
+		st.tokens.refill(sc.Limits.TokensPerMinute, now)
+		states[i] = st
+	}
+
+	for i, st := range states {
+		lim := st.limits
+		if lim.RequestsPerMinute > 0 && st.requests.level < 1 {
+			return nil, &Denied{Scope: scopes[i].Name, Reason: "requests per minute", RetryAfter: st.requests.wait(lim.RequestsPerMinute, 1), Status: l.status(scopes)}
+		}
+		if lim.TokensPerMinute > 0 {
+			// A request larger than the whole bucket is let through once the
+			// bucket is full rather than rejected forever.
+			need := float64(min(tokens, lim.TokensPerMinute))
+			if st.tokens.level < need {
+				return nil, &Denied{Scope: scopes[i].Name, Reason: "tokens per minute", RetryAfter: st.tokens.wait(lim.TokensPerMinute, need), Status: l.status(scopes)}
+			}
+		}
+		if stream && lim.MaxConcurrentStreams > 0 && st.streams >= lim.MaxConcurrentStreams {
+			return nil, &Denied{Scope: scopes[i].Name, Reason: "concurrent streams", RetryAfter: time.Second, Status: l.status(scopes)}
+		}
+	}
+
+	lease := &Lease{l: l, reserved: tokens, stream: stream}
+	for i, st := range states {
+		if st.limits.RequestsPerMinute > 0 {
+			st.requests.level--
+		}
+		if st.limits.TokensPerMinute > 0 {
+			st.tokens.level -= float64(tokens)
+		}
+		if stream {
+			st.streams++
+		}
+		lease.scopes = append(lease.scopes, scopes[i].Name)
+	}
+	lease.status = l.status(scopes)
+	return lease, nil
+}
+
+// Status returns each scope's allowance right after the lease was granted.
+func (le *Lease) Status() []Status {
+	return le.status
+}
+
+// Settle charges the difference between the actual and reserved token counts.
+func (le *Lease) Settle(actual int) {
+	le.l.mu.Lock()
+	defer le.l.mu.Unlock()
+	if le.settled {
+		return
+	}
+	le.settled = true
+	for _, name := range le.scopes {
+		if st := le.l.scopes[name]; st != nil && st.limits.TokensPerMinute > 0 {
+			st.tokens.level -= float64(actual - le.reserved)
+			if st.tokens.level > float64(st.limits.TokensPerMinute) {
+				st.tokens.level = float64(st.limits.TokensPerMinute)
+			}
+		}
+	}
+}
+
+// Release frees the lease's stream slots.
+func (le *Lease) Release() {
+	le.l.mu.Lock()
+	defer le.l.mu.Unlock()
+	if le.released {
+		return
+	}
+	le.released = true
+	if !le.stream {
+		return
+	}
+	for _, name := range le.scopes {
+		if st := le.l.scopes[name]; st != nil && st.streams > 0 {
+			st.streams--
+		}
+	}
+}
+
+// Snapshot returns the state of every tracked scope, sorted by name.
+func (l *Limiter) Snapshot() []Status {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	scopes := make([]Scope, 0, len(l.scopes))
+	for name, st := range l.scopes {
+		scopes = append(scopes, Scope{Name: name, Limits: st.limits})
+	}
+	sort.Slice(scopes, func(i, j int) bool { return scopes[i].Name < scopes[j].Name })
+	return l.status(scopes)
+}
+
+func (l *Limiter) status(scopes []Scope) []Status {
+	now := l.now()
+	out := make([]Status, 0, len(scopes))
+	for _, sc := range scopes {
+		st := l.scopes[sc.Name]
+		if st == nil {
+			continue
+		}
+		lim := st.limits
+		st.requests.refill(lim.RequestsPerMinute, now)
+		st.tokens.refill(lim.TokensPerMinute, now)
+		out = append(out, Status{
+			Scope:             sc.Name,
+			Limits:            lim,
+			RequestsRemaining: max(int(st.requests.level), 0),
+			RequestsReset:     st.requests.wait(max(lim.RequestsPerMinute, 1), float64(lim.RequestsPerMinute)),
+			TokensRemaining:   max(int(st.tokens.level), 0),
+			TokensReset:       st.tokens.wait(max(lim.TokensPerMinute, 1), float64(lim.TokensPerMinute)),
+			Streams:           st.streams,
+		})
+	}
+	return out
+}
+
+func (l *Limiter) evictIdle(now time.Time) {
+	for name, st := range l.scopes {
+		if st.idle(now) {
+			delete(l.scopes, name)
+		}
+	}
+}
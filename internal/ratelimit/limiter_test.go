@@ -0,0 +1,146 @@
+package ratelimit
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Limiter clock advanced by hand.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestLimiter() (*Limiter, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := New()
+	l.now = clock.now
+	return l, clock
+}
+
+func TestLimiterBuckets(t *testing.T) {
+	key := Scope{Name: "key:a", Limits: Limits{RequestsPerMinute: 2, TokensPerMinute: 1000}}
+	type step struct {
+		advance time.Duration
+		tokens  int
+		settle  int // actual tokens; -1 leaves the reservation
+		denied  string
+		retry   time.Duration
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"requests per minute", []step{
+			{tokens: 1, settle: -1},
+			{tokens: 1, settle: -1},
+			{tokens: 1, denied: "requests per minute", retry: 30 * time.Second},
+			{advance: 30 * time.Second, tokens: 1, settle: -1},
+			{tokens: 1, denied: "requests per minute", retry: 30 * time.Second},
+		}},
+		{"tokens per minute", []step{
+			{tokens: 800, settle: -1},
+			{tokens: 300, denied: "tokens per minute", retry: 6 * time.Second},
+			{advance: 6 * time.Second, tokens: 300, settle: -1},
+		}},
+		{"settle refunds an overestimate", []step{
+			{tokens: 900, settle: 100},
+			{tokens: 900, settle: -1},
+		}},
+		{"settle charges an underestimate", []step{
+			{tokens: 100, settle: 1500},
+			{tokens: 1, denied: "tokens per minute", retry: 30*time.Second + 60*time.Millisecond},
+		}},
+		{"oversized request is charged in full, then waits for a full bucket", []step{
+			{tokens: 5000, settle: -1},
+			{advance: time.Minute, tokens: 5000, denied: "tokens per minute", retry: 4 * time.Minute},
+			{advance: 4 * time.Minute, tokens: 5000, settle: -1},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, clock := newTestLimiter()
+			for i, s := range tt.steps {
+				clock.advance(s.advance)
+				lease, err := l.Acquire([]Scope{key}, s.tokens, false)
+				var denied *Denied
+				if s.denied == "" {
+					if err != nil {
+						t.Fatalf("step %d: %v", i, err)
+					}
+					if s.settle >= 0 {
+						lease.Settle(s.settle)
+					}
+					continue
+				}
+				if !errors.As(err, &denied) || denied.Reason != s.denied {
+					t.Fatalf("step %d: err = %v, want %s", i, err, s.denied)
+				}
+				if s.retry > 0 && (denied.RetryAfter < s.retry-time.Second || denied.RetryAfter > s.retry+time.Second) {
+					t.Errorf("step %d: retry after %v, want about %v", i, denied.RetryAfter, s.retry)
+				}
+			}
+		})
+	}
+}
+
+func TestLimiterStreams(t *testing.T) {
+	l, _ := newTestLimiter()
+	scope := []Scope{{Name: "key:a", Limits: Limits{MaxConcurrentStreams: 1}}}
+	first, err := l.Acquire(scope, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Acquire(scope, 0, false); err != nil {
+		t.Errorf("non-streaming request limited by streams: %v", err)
+	}
+	var denied *Denied
+	if _, err := l.Acquire(scope, 0, true); !errors.As(err, &denied) || denied.Reason != "concurrent streams" {
+		t.Fatalf("second stream: err = %v", err)
+	}
+	first.Release()
+	first.Release()
+	if st := l.Snapshot(); st[0].Streams != 0 {
+		t.Errorf("streams after double release = %d", st[0].Streams)
+	}
+	if _, err := l.Acquire(scope, 0, true); err != nil {
+		t.Errorf("stream after release: %v", err)
+	}
+}
+
+// A request denied by one scope charges none of them.
+func TestLimiterDeniedChargesNothing(t *testing.T) {
+	l, _ := newTestLimiter()
+	key := Scope{Name: "key:a", Limits: Limits{RequestsPerMinute: 10, TokensPerMinute: 1000}}
+	model := Scope{Name: "model:m", Limits: Limits{RequestsPerMinute: 1}}
+	if _, err := l.Acquire([]Scope{key, model}, 100, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Acquire([]Scope{key, model}, 100, false); err == nil {
+		t.Fatal("model limit not enforced")
+	}
+	st := l.Snapshot()
+	if st[0].Scope != "key:a" || st[0].RequestsRemaining != 9 || st[0].TokensRemaining != 900 {
+		t.Errorf("key scope = %+v, want one request and 100 tokens used", st[0])
+	}
+	if st[1].Scope != "model:m" || st[1].RequestsRemaining != 0 || st[1].RequestsReset != time.Minute {
+		t.Errorf("model scope = %+v", st[1])
+	}
+}
+
+func TestLimiterEvictsIdleScopes(t *testing.T) {
+	l, clock := newTestLimiter()
+	lim := Limits{RequestsPerMinute: 1}
+	busy, _ := l.Acquire([]Scope{{Name: "busy", Limits: Limits{MaxConcurrentStreams: 1}}}, 0, true)
+	defer busy.Release()
+	for i := range maxIdleScopes {
+		_, _ = l.Acquire([]Scope{{Name: "idle" + strconv.Itoa(i), Limits: lim}}, 0, false)
+	}
+	clock.advance(time.Minute)
+	_, _ = l.Acquire([]Scope{{Name: "new", Limits: lim}}, 0, false)
+	if n := len(l.scopes); n != 2 {
+		t.Errorf("%d scopes tracked after eviction, want the busy and new ones", n)
+	}
+}
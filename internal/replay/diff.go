@@ -0,0 +1,146 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"claude-nvidia-proxy/internal/converter"
+	"claude-nvidia-proxy/internal/sse"
+)
+
+// maxDiffLines bounds the quadratic line diff; longer inputs are compared
+// up to their first differing line.
+const maxDiffLines = 4000
+
+// Diff returns the lines removed from a ("- ...") and added in b ("+ ...").
+// It is empty when a and b are equal.
+func Diff(a, b string) []string {
+	if a == b {
+		return nil
+	}
+	al, bl := strings.Split(a, "\n"), strings.Split(b, "\n")
+	if len(al) > maxDiffLines || len(bl) > maxDiffLines {
+		for i := 0; ; i++ {
+			if i >= len(al) || i >= len(bl) || al[i] != bl[i] {
+				var out []string
+				if i < len(al) {
+					out = append(out, "- "+al[i])
+				}
+				if i < len(bl) {
+					out = append(out, "+ "+bl[i])
+				}
+				return append(out, "  (diff stopped at the first difference)")
+			}
+		}
+	}
+
+	// lcs[i][j] is the longest common subsequence of al[i:] and bl[j:].
+	lcs := make([][]int, len(al)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bl)+1)
+	}
+	for i := len(al) - 1; i >= 0; i-- {
+		for j := len(bl) - 1; j >= 0; j-- {
+			if al[i] == bl[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var out []string
+	i, j := 0, 0
+	for i < len(al) || j < len(bl) {
+		switch {
+		case i < len(al) && j < len(bl) && al[i] == bl[j]:
+			i, j = i+1, j+1
+		case i < len(al) && (j == len(bl) || lcs[i+1][j] >= lcs[i][j+1]):
+			out = append(out, "- "+al[i])
+			i++
+		default:
+			out = append(out, "+ "+bl[j])
+			j++
+		}
+	}
+	return out
+}
+
+// normalizeJSON pretty-prints a JSON document with sorted keys, or returns
+// it unchanged if it is not JSON.
+func normalizeJSON(b []byte) string {
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return string(bytes.TrimSpace(b))
+	}
+	return pretty(v)
+}
+
+// normalizeResponse renders an Anthropic JSON body or SSE stream one field
+// per line, with generated IDs and cache usage masked.
+func normalizeResponse(s string) string {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "event:") && !strings.HasPrefix(s, "data:") {
+		var v any
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			return s
+		}
+		return pretty(maskGenerated(v))
+	}
+	// The stream was trimmed, so terminate its last event again. No event
+	// can be larger than the whole stream.
+	var out []string
+	d := sse.NewDecoder(strings.NewReader(s+"\n\n"), len(s)+1)
+	for {
+		ev, err := d.Next()
+		if err != nil {
+			break
+		}
+		out = append(out, "event: "+ev.Type)
+		var v any
+		if err := json.Unmarshal([]byte(ev.Data), &v); err != nil {
+			out = append(out, "data: "+ev.Data)
+			continue
+		}
+		out = append(out, "data: "+pretty(maskGenerated(v)))
+	}
+	return strings.Join(out, "\n")
+}
+
+// maskGenerated hides values that change on every run: the msg_<time> id of
+// a message, generated tool_use ids, and the cache usage fields, which
+// depend on prompt cache history rather than on the exchange itself.
+func maskGenerated(v any) any {
+	switch x := v.(type) {
+	case map[string]any:
+		if _, ok := x["id"].(string); ok && x["type"] == "message" {
+			x["id"] = "msg_*"
+		}
+		if id, ok := x["id"].(string); ok && x["type"] == "tool_use" && strings.HasPrefix(id, converter.GeneratedToolIDPrefix) {
+			x["id"] = converter.GeneratedToolIDPrefix + "*"
+		}
+		if usage, ok := x["usage"].(map[string]any); ok {
+			for _, k := range []string{"cache_creation_input_tokens", "cache_read_input_tokens"} {
+				if _, ok := usage[k]; ok {
+					usage[k] = "*"
+				}
+			}
+		}
+		for _, vv := range x {
+			maskGenerated(vv)
+		}
+	case []any:
+		for _, vv := range x {
+			maskGenerated(vv)
+		}
+	}
+	return v
+}
+
+func pretty(v any) string {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
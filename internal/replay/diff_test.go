@@ -0,0 +1,108 @@
+package replay
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []string
+	}{
+		{"equal", "a\nb", "a\nb", nil},
+		{"changed line", "a\nb\nc", "a\nx\nc", []string{"- b", "+ x"}},
+		{"added line", "a\nc", "a\nb\nc", []string{"+ b"}},
+		{"removed line", "a\nb\nc", "a\nc", []string{"- b"}},
+		{"from empty", "", "a", []string{"- ", "+ a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Diff(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("long input stops at first difference", func(t *testing.T) {
+		lines := make([]string, maxDiffLines+1)
+		for i := range lines {
+			lines[i] = strconv.Itoa(i)
+		}
+		a := strings.Join(lines, "\n")
+		lines[10] = "changed"
+		got := Diff(a, strings.Join(lines, "\n"))
+		want := []string{"- 10", "+ changed", "  (diff stopped at the first difference)"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Diff = %q, want %q", got, want)
+		}
+	})
+}
+
+func TestNormalizeResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		a, b   string
+		differ bool
+	}{
+		{
+			name: "message ids and key order",
+			a:    `{"id":"msg_1","type":"message","content":[]}`,
+			b:    `{"content":[],"type":"message","id":"msg_2"}`,
+		},
+		{
+			name: "generated tool ids",
+			a:    `{"type":"message","content":[{"type":"tool_use","id":"toolu_proxy_1700000000000000000_1","name":"f","input":{}},{"type":"tool_use","id":"toolu_proxy_1700000000000000000_2","name":"g","input":{}}]}`,
+			b:    `{"type":"message","content":[{"type":"tool_use","id":"toolu_proxy_1800000000000000000_1","name":"f","input":{}},{"type":"tool_use","id":"toolu_proxy_1800000000000000000_2","name":"g","input":{}}]}`,
+		},
+		{
+			name:   "upstream tool ids still compared",
+			a:      `{"type":"message","content":[{"type":"tool_use","id":"call_abc","name":"f","input":{}}]}`,
+			b:      `{"type":"message","content":[{"type":"tool_use","id":"call_def","name":"f","input":{}}]}`,
+			differ: true,
+		},
+		{
+			name: "cache usage",
+			a:    `{"type":"message","usage":{"input_tokens":3,"cache_creation_input_tokens":10,"cache_read_input_tokens":0}}`,
+			b:    `{"type":"message","usage":{"input_tokens":3,"cache_creation_input_tokens":0,"cache_read_input_tokens":10}}`,
+		},
+		{
+			name:   "other usage still compared",
+			a:      `{"type":"message","usage":{"input_tokens":3,"output_tokens":5}}`,
+			b:      `{"type":"message","usage":{"input_tokens":3,"output_tokens":6}}`,
+			differ: true,
+		},
+		{
+			name: "stream",
+			a: "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_1\",\"type\":\"message\",\"usage\":{\"input_tokens\":0}}}\n\n" +
+				"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"tool_use\",\"id\":\"toolu_proxy_1_1\",\"name\":\"f\",\"input\":{}}}\n\n" +
+				"event: message_delta\ndata: {\"type\":\"message_delta\",\"usage\":{\"output_tokens\":5,\"cache_read_input_tokens\":0}}\n\n",
+			b: "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_2\",\"type\":\"message\",\"usage\":{\"input_tokens\":0}}}\n\n" +
+				"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"tool_use\",\"id\":\"toolu_proxy_2_2\",\"name\":\"f\",\"input\":{}}}\n\n" +
+				"event: message_delta\ndata: {\"type\":\"message_delta\",\"usage\":{\"output_tokens\":5,\"cache_read_input_tokens\":10}}",
+		},
+		{
+			name:   "stream text",
+			a:      "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"hi\"}}\n\n",
+			b:      "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"ho\"}}\n\n",
+			differ: true,
+		},
+		{
+			name:   "not JSON",
+			a:      "upstream error",
+			b:      "upstream failure",
+			differ: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := Diff(normalizeResponse(tt.a), normalizeResponse(tt.b))
+			if got := len(d) > 0; got != tt.differ {
+				t.Errorf("differ = %v, want %v; diff %q", got, tt.differ, d)
+			}
+		})
+	}
+}
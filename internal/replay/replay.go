@@ -0,0 +1,202 @@
+// Package replay re-runs captured exchanges through the current proxy and
+// reports where the converted request or the Anthropic response changed.
+package replay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"claude-nvidia-proxy/internal/capture"
+	"claude-nvidia-proxy/internal/config"
+	"claude-nvidia-proxy/internal/ratelimit"
+	"claude-nvidia-proxy/internal/server"
+)
+
+// Result is the outcome of replaying one exchange. Skipped is set instead
+// of the diffs when the exchange could not be replayed.
+type Result struct {
+	Exchange      *capture.Exchange
+	Skipped       string
+	ConvertedDiff []string
+	ResponseDiff  []string
+}
+
+// Differs reports whether the replay produced different output.
+func (r Result) Differs() bool {
+	return len(r.ConvertedDiff) > 0 || len(r.ResponseDiff) > 0
+}
+
+// Replayer runs exchanges through a proxy server. Without a live upstream
+// it answers upstream calls with the captured response.
+type Replayer struct {
+	srv  *server.Server
+	live bool
+
+	upstream *http.Server
+	mu       sync.Mutex
+	current  *capture.Exchange
+	received []byte
+}
+
+// New builds a proxy from cfg with caching, dedup, auth, rate limits, usage
+// accounting, capture and tracing turned off, so each exchange is
+// converted exactly as the current code would convert it. With live set,
+// requests go to cfg.UpstreamURL.
+func New(cfg *config.ServerConfig, live bool) (*Replayer, error) {
+	c := *cfg
+	c.ResponseCache = "off"
+	c.RequestDedup = false
+	c.ServerAPIKey, c.KeysFile = "", ""
+	c.KeyRateLimits, c.ModelRateLimits = ratelimit.Limits{}, nil
+	c.UsageFile, c.CaptureDir, c.TraceEndpoint = "", "", ""
+
+	r := &Replayer{live: live}
+	if !live {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		r.upstream = &http.Server{Handler: http.HandlerFunc(r.serveCaptured)}
+		go func() { _ = r.upstream.Serve(ln) }()
+		c.UpstreamURL = "http://" + ln.Addr().String() + "/v1/chat/completions"
+	}
+	srv, err := server.New(&c)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	r.srv = srv
+	return r, nil
+}
+
+func (r *Replayer) Close() {
+	if r.upstream != nil {
+		_ = r.upstream.Shutdown(context.Background())
+	}
+}
+
+// serveCaptured answers with the captured upstream response and keeps the
+// request body for comparison.
+func (r *Replayer) serveCaptured(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	ex := r.current
+	r.received = body
+	r.mu.Unlock()
+	if ex == nil {
+		http.Error(w, "no exchange", http.StatusInternalServerError)
+		return
+	}
+	contentType := "application/json"
+	if strings.HasPrefix(strings.TrimSpace(ex.Upstream), "data:") || strings.HasPrefix(strings.TrimSpace(ex.Upstream), "event:") {
+		contentType = "text/event-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(ex.UpstreamStatus)
+	_, _ = io.WriteString(w, ex.Upstream)
+}
+
+// Replay sends ex.Inbound through the proxy and diffs the result against
+// the capture. Exchanges are replayed one at a time.
+func (r *Replayer) Replay(ex *capture.Exchange) Result {
+	res := Result{Exchange: ex}
+	switch {
+	case len(ex.Inbound) == 0:
+		res.Skipped = "no inbound request"
+		return res
+	case !r.live && ex.UpstreamStatus == 0:
+		res.Skipped = "no captured upstream response (cache hit or server tools)"
+		return res
+	}
+
+	r.mu.Lock()
+	r.current, r.received = ex, nil
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.current = nil
+		r.mu.Unlock()
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader(ex.Inbound))
+	if err != nil {
+		res.Skipped = err.Error()
+		return res
+	}
+	req.Header.Set("Content-Type", "application/json")
+	rec := newRecorder()
+	r.srv.HandleMessages(rec, req)
+
+	r.mu.Lock()
+	received := r.received
+	r.mu.Unlock()
+	if !r.live && len(ex.Converted) > 0 {
+		res.ConvertedDiff = Diff(normalizeJSON(ex.Converted), normalizeJSON(received))
+	}
+	res.ResponseDiff = Diff(normalizeResponse(ex.Response), normalizeResponse(rec.body.String()))
+	if rec.status != ex.Status {
+		res.ResponseDiff = append([]string{"- status " + strconv.Itoa(ex.Status), "+ status " + strconv.Itoa(rec.status)}, res.ResponseDiff...)
+	}
+	return res
+}
+
+// recorder is a minimal http.ResponseWriter that keeps the response.
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newRecorder() *recorder {
+	return &recorder{header: http.Header{}}
+}
+
+func (r *recorder) Header() http.Header { return r.header }
+
+func (r *recorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(b)
+}
+
+func (r *recorder) Flush() {}
+
+// Load reads exchanges from capture files and directories, in order.
+func Load(paths []string) ([]*capture.Exchange, error) {
+	var out []*capture.Exchange
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		var exs []*capture.Exchange
+		if info.IsDir() {
+			exs, err = capture.ReadDir(p)
+		} else {
+			exs, err = capture.ReadFile(p)
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, exs...)
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no captured exchanges found")
+	}
+	return out, nil
+}
@@ -0,0 +1,99 @@
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"claude-nvidia-proxy/internal/capture"
+	"claude-nvidia-proxy/internal/config"
+)
+
+func newTestReplayer(t *testing.T) *Replayer {
+	t.Helper()
+	r, err := New(&config.ServerConfig{
+		ProviderAPIKey:       "test-key",
+		Timeout:              10 * time.Second,
+		PromptCacheEmulation: true,
+		PromptCacheTTL:       time.Hour,
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(r.Close)
+	return r
+}
+
+// The upstream sends no tool call id, so the proxy generates one, and the
+// cache breakpoint makes the emulated cache usage differ between runs.
+const toolInbound = `{"model":"m","max_tokens":10,"stream":%s,"system":[{"type":"text","text":"long system prompt","cache_control":{"type":"ephemeral"}}],"messages":[{"role":"user","content":"what time is it?"}],"tools":[{"name":"get_time","input_schema":{"type":"object"}}]}`
+
+func toolExchange(stream bool) *capture.Exchange {
+	ex := &capture.Exchange{RequestID: "req-1", Model: "m", Stream: stream, UpstreamStatus: 200, Status: 200}
+	if stream {
+		ex.Inbound = json.RawMessage(fmt.Sprintf(toolInbound, "true"))
+		ex.Upstream = "data: {\"id\":\"c\",\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"tool_calls\":[{\"index\":0,\"type\":\"function\",\"function\":{\"name\":\"get_time\",\"arguments\":\"{}\"}}]}}]}\n\n" +
+			"data: {\"id\":\"c\",\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"tool_calls\"}],\"usage\":{\"prompt_tokens\":10,\"completion_tokens\":5}}\n\n" +
+			"data: [DONE]\n\n"
+		ex.Response = "event: message_start\ndata: {\"message\":{\"content\":[],\"id\":\"msg_1700000000000\",\"model\":\"m\",\"role\":\"assistant\",\"stop_reason\":null,\"stop_sequence\":null,\"type\":\"message\",\"usage\":{\"input_tokens\":0,\"output_tokens\":0}},\"type\":\"message_start\"}\n\n" +
+			"event: content_block_start\ndata: {\"content_block\":{\"id\":\"toolu_proxy_1700000000000000000_1\",\"input\":{},\"name\":\"get_time\",\"type\":\"tool_use\"},\"index\":0,\"type\":\"content_block_start\"}\n\n" +
+			"event: content_block_delta\ndata: {\"delta\":{\"partial_json\":\"{}\",\"type\":\"input_json_delta\"},\"index\":0,\"type\":\"content_block_delta\"}\n\n" +
+			"event: content_block_stop\ndata: {\"index\":0,\"type\":\"content_block_stop\"}\n\n" +
+			"event: message_delta\ndata: {\"delta\":{\"stop_reason\":\"tool_use\",\"stop_sequence\":null},\"type\":\"message_delta\",\"usage\":{\"cache_creation_input_tokens\":10,\"cache_read_input_tokens\":0,\"input_tokens\":0,\"output_tokens\":5}}\n\n" +
+			"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"
+		return ex
+	}
+	ex.Inbound = json.RawMessage(fmt.Sprintf(toolInbound, "false"))
+	ex.Upstream = `{"id":"c","object":"chat.completion","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":null,"tool_calls":[{"type":"function","function":{"name":"get_time","arguments":"{}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":10,"completion_tokens":5}}`
+	ex.Response = `{"id":"c","type":"message","role":"assistant","model":"m","content":[{"id":"toolu_proxy_1700000000000000000_1","input":{},"name":"get_time","type":"tool_use"}],"stop_reason":"tool_use","stop_sequence":null,"usage":{"cache_creation_input_tokens":10,"cache_read_input_tokens":0,"input_tokens":0,"output_tokens":5}}`
+	return ex
+}
+
+func TestReplayMatchesAcrossRuns(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		t.Run(map[bool]string{false: "json", true: "stream"}[stream], func(t *testing.T) {
+			r := newTestReplayer(t)
+			ex := toolExchange(stream)
+			// The second run reads the prefix the first one cached.
+			for run := 1; run <= 2; run++ {
+				res := r.Replay(ex)
+				if res.Skipped != "" || res.Differs() {
+					t.Fatalf("run %d: skipped %q, converted diff %q, response diff %q", run, res.Skipped, res.ConvertedDiff, res.ResponseDiff)
+				}
+			}
+		})
+	}
+}
+
+func TestReplayReportsDifferences(t *testing.T) {
+	r := newTestReplayer(t)
+
+	ex := toolExchange(false)
+	ex.Converted = json.RawMessage(`{"model":"m","messages":[]}`)
+	ex.Response = `{"id":"c","type":"message","role":"assistant","model":"m","content":[{"type":"text","text":"It is noon."}],"stop_reason":"end_turn","stop_sequence":null,"usage":{"input_tokens":0,"output_tokens":5}}`
+	ex.Status = 201
+	res := r.Replay(ex)
+	if len(res.ConvertedDiff) == 0 {
+		t.Error("converted request diff is empty")
+	}
+	if len(res.ResponseDiff) < 2 || res.ResponseDiff[0] != "- status 201" || res.ResponseDiff[1] != "+ status 200" {
+		t.Errorf("response diff = %q", res.ResponseDiff)
+	}
+}
+
+func TestReplaySkips(t *testing.T) {
+	r := newTestReplayer(t)
+	tests := []struct {
+		name string
+		ex   *capture.Exchange
+	}{
+		{"no inbound", &capture.Exchange{UpstreamStatus: 200}},
+		{"no upstream response", &capture.Exchange{Inbound: json.RawMessage(`{"model":"m"}`)}},
+	}
+	for _, tt := range tests {
+		if res := r.Replay(tt.ex); res.Skipped == "" {
+			t.Errorf("%s: not skipped", tt.name)
+		}
+	}
+}
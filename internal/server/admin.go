@@ -0,0 +1,239 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"claude-nvidia-proxy/internal/auth"
+	"claude-nvidia-proxy/internal/ratelimit"
+)
+
+// upstreamProvider names the single upstream the proxy forwards to.
+const upstreamProvider = "nvidia"
+
+// toggles are runtime switches flipped through the admin API. They live in
+// memory and reset on restart.
+type toggles struct {
+	mu               sync.RWMutex
+	disabledModels   map[string]bool
+	providerDisabled bool
+}
+
+// unavailable returns the error to send when model or the upstream has been
+// switched off, or "" when the request may proceed.
+func (t *toggles) unavailable(model string) (int, string, string) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.providerDisabled {
+		return http.StatusServiceUnavailable, "api_error", "upstream provider " + upstreamProvider + " is disabled"
+	}
+	if t.disabledModels[model] {
+		return http.StatusBadRequest, "invalid_request_error", "model " + model + " is disabled"
+	}
+	return 0, "", ""
+}
+
+// AdminHandler serves the /admin API, or returns nil when ADMIN_API_KEY is
+// not set.
+func (s *Server) AdminHandler() http.Handler {
+	if s.cfg.AdminAPIKey == "" {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/keys", s.adminListKeys)
+	mux.HandleFunc("POST /admin/keys", s.adminCreateKey)
+	mux.HandleFunc("POST /admin/keys/{name}/revoke", s.adminSetKeyEnabled(false))
+	mux.HandleFunc("POST /admin/keys/{name}/enable", s.adminSetKeyEnabled(true))
+	mux.HandleFunc("GET /admin/usage", s.adminUsage)
+	mux.HandleFunc("GET /admin/models", s.adminListModels)
+	mux.HandleFunc("PUT /admin/models/{model...}", s.adminSetModel)
+	mux.HandleFunc("GET /admin/providers", s.adminListProviders)
+	mux.HandleFunc("PUT /admin/providers/{name}", s.adminSetProvider)
+	mux.HandleFunc("GET /admin/ratelimits", s.adminRateLimits)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !checkInboundAuth(r, s.cfg.AdminAPIKey) {
+			slog.Warn("admin unauthorized", "method", r.Method, "path", r.URL.Path)
+			writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+type adminKeyView struct {
+	Name               string           `json:"name"`
+	Enabled            bool             `json:"enabled"`
+	AllowedModels      []string         `json:"allowed_models,omitempty"`
+	RateLimits         ratelimit.Limits `json:"rate_limits"`
+	DailyTokenBudget   int64            `json:"daily_token_budget,omitempty"`
+	MonthlyTokenBudget int64            `json:"monthly_token_budget,omitempty"`
+	DailyTokensUsed    int64            `json:"daily_tokens_used"`
+	MonthlyTokensUsed  int64            `json:"monthly_tokens_used"`
+}
+
+func (s *Server) keyView(k auth.Key) adminKeyView {
+	now := time.Now().UTC()
+	return adminKeyView{
+		Name:               k.Name,
+		Enabled:            k.IsEnabled(),
+		AllowedModels:      k.AllowedModels,
+		RateLimits:         k.RateLimits,
+		DailyTokenBudget:   k.DailyTokenBudget,
+		MonthlyTokenBudget: k.MonthlyTokenBudget,
+		DailyTokensUsed:    s.usage.Used(k.Name, now),
+		MonthlyTokensUsed:  s.usage.Used(k.Name, time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)),
+	}
+}
+
+func (s *Server) adminListKeys(w http.ResponseWriter, _ *http.Request) {
+	if s.keys == nil {
+		writeJSONError(w, http.StatusConflict, "no_key_registry")
+		return
+	}
+	keys := []adminKeyView{}
+	for _, k := range s.keys.Keys() {
+		keys = append(keys, s.keyView(k))
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]any{"keys": keys})
+}
+
+func (s *Server) adminCreateKey(w http.ResponseWriter, r *http.Request) {
+	if s.keys == nil {
+		writeJSONError(w, http.StatusConflict, "no_key_registry")
+		return
+	}
+	var k auth.Key
+	if err := json.NewDecoder(r.Body).Decode(&k); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_json")
+		return
+	}
+	secret, err := s.keys.Create(k)
+	switch {
+	case errors.Is(err, auth.ErrDuplicateName):
+		writeJSONError(w, http.StatusConflict, "duplicate_key_name")
+		return
+	case err != nil:
+		slog.Error("admin create key failed", "key_name", k.Name, "err", err)
+		writeJSONError(w, http.StatusBadRequest, "create_key_failed")
+		return
+	}
+	slog.Info("admin created key", "key_name", k.Name, "enabled", k.IsEnabled())
+	writeAdminJSON(w, http.StatusCreated, map[string]any{"key": s.keyView(k), "secret": secret})
+}
+
+func (s *Server) adminSetKeyEnabled(enabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.keys == nil {
+			writeJSONError(w, http.StatusConflict, "no_key_registry")
+			return
+		}
+		name := r.PathValue("name")
+		err := s.keys.SetEnabled(name, enabled)
+		switch {
+		case errors.Is(err, auth.ErrKeyNotFound):
+			writeJSONError(w, http.StatusNotFound, "key_not_found")
+			return
+		case err != nil:
+			slog.Error("admin update key failed", "key_name", name, "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "update_key_failed")
+			return
+		}
+		slog.Info("admin set key", "key_name", name, "enabled", enabled)
+		writeAdminJSON(w, http.StatusOK, map[string]any{"name": name, "enabled": enabled})
+	}
+}
+
+func (s *Server) adminListModels(w http.ResponseWriter, _ *http.Request) {
+	s.toggles.mu.RLock()
+	defer s.toggles.mu.RUnlock()
+	names := map[string]bool{}
+	for m := range s.cfg.Models {
+		names[m] = true
+	}
+	for m := range s.toggles.disabledModels {
+		names[m] = true
+	}
+	models := []map[string]any{}
+	for _, m := range slices.Sorted(maps.Keys(names)) {
+		models = append(models, map[string]any{"model": m, "enabled": !s.toggles.disabledModels[m]})
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]any{"models": models})
+}
+
+func (s *Server) adminSetModel(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_json")
+		return
+	}
+	model := r.PathValue("model")
+	s.toggles.mu.Lock()
+	if body.Enabled {
+		delete(s.toggles.disabledModels, model)
+	} else {
+		s.toggles.disabledModels[model] = true
+	}
+	s.toggles.mu.Unlock()
+	slog.Info("admin set model", "model", model, "enabled", body.Enabled)
+	writeAdminJSON(w, http.StatusOK, map[string]any{"model": model, "enabled": body.Enabled})
+}
+
+func (s *Server) adminListProviders(w http.ResponseWriter, _ *http.Request) {
+	s.toggles.mu.RLock()
+	defer s.toggles.mu.RUnlock()
+	writeAdminJSON(w, http.StatusOK, map[string]any{"providers": []map[string]any{{
+		"name":    upstreamProvider,
+		"url":     s.cfg.UpstreamURL,
+		"enabled": !s.toggles.providerDisabled,
+	}}})
+}
+
+func (s *Server) adminSetProvider(w http.ResponseWriter, r *http.Request) {
+	if r.PathValue("name") != upstreamProvider {
+		writeJSONError(w, http.StatusNotFound, "provider_not_found")
+		return
+	}
+	var body struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_json")
+		return
+	}
+	s.toggles.mu.Lock()
+	s.toggles.providerDisabled = !body.Enabled
+	s.toggles.mu.Unlock()
+	slog.Info("admin set provider", "provider", upstreamProvider, "enabled", body.Enabled)
+	writeAdminJSON(w, http.StatusOK, map[string]any{"name": upstreamProvider, "enabled": body.Enabled})
+}
+
+func (s *Server) adminRateLimits(w http.ResponseWriter, _ *http.Request) {
+	scopes := []map[string]any{}
+	for _, st := range s.limiter.Snapshot() {
+		scopes = append(scopes, map[string]any{
+			"scope":                 st.Scope,
+			"limits":                st.Limits,
+			"requests_remaining":    st.RequestsRemaining,
+			"requests_reset_in_sec": st.RequestsReset.Seconds(),
+			"tokens_remaining":      st.TokensRemaining,
+			"tokens_reset_in_sec":   st.TokensReset.Seconds(),
+			"active_streams":        st.Streams,
+		})
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]any{"rate_limits": scopes})
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"claude-nvidia-proxy/internal/config"
+)
+
+func TestAdminAPI(t *testing.T) {
+	s, _ := newTestServerWith(t, func(cfg *config.ServerConfig) {
+		cfg.AdminAPIKey = "admin-secret"
+		cfg.KeysFile = filepath.Join(t.TempDir(), "keys.json")
+	})
+	admin := s.AdminHandler()
+	call := func(method, path, body string) (int, map[string]any) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, req)
+		var out map[string]any
+		_ = json.Unmarshal(rec.Body.Bytes(), &out)
+		return rec.Code, out
+	}
+
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/keys", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("unauthenticated request: %d", rec.Code)
+	}
+
+	status, out := call(http.MethodPost, "/admin/keys", `{"name":"ci","daily_token_budget":100}`)
+	secret, _ := out["secret"].(string)
+	if status != http.StatusCreated || secret == "" {
+		t.Fatalf("create key: %d %v", status, out)
+	}
+	if status, _ := call(http.MethodPost, "/admin/keys", `{"name":"ci"}`); status != http.StatusConflict {
+		t.Errorf("duplicate key: %d", status)
+	}
+	if rec := postMessagesAs(s, secret, `{"model":"m","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`); rec.Code != http.StatusOK {
+		t.Errorf("new key rejected: %d %s", rec.Code, rec.Body)
+	}
+
+	status, out = call(http.MethodPost, "/admin/keys", `{"name":"off","enabled":false}`)
+	if key, _ := out["key"].(map[string]any); status != http.StatusCreated || key["enabled"] != false {
+		t.Errorf("create disabled key: %d %v", status, out)
+	}
+	if rec := postMessagesAs(s, out["secret"].(string), `{"model":"m","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("disabled key accepted: %d", rec.Code)
+	}
+	if status, out := call(http.MethodGet, "/admin/keys", ""); status != http.StatusOK || !strings.Contains(mustJSON(t, out), `"daily_tokens_used":17`) {
+		t.Errorf("list keys: %d %v", status, out)
+	}
+
+	if status, _ := call(http.MethodPost, "/admin/keys/ci/revoke", ""); status != http.StatusOK {
+		t.Errorf("revoke: %d", status)
+	}
+	if status, _ := call(http.MethodPost, "/admin/keys/nobody/revoke", ""); status != http.StatusNotFound {
+		t.Errorf("revoke unknown key: %d", status)
+	}
+	if rec := postMessagesAs(s, secret, `{"model":"m","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("revoked key: %d", rec.Code)
+	}
+	call(http.MethodPost, "/admin/keys/ci/enable", "")
+
+	tests := []struct {
+		method, path, body string
+		status             int // status of the following message request
+	}{
+		{http.MethodPut, "/admin/models/org/m", `{"enabled":false}`, http.StatusOK},
+		{http.MethodPut, "/admin/models/m", `{"enabled":false}`, http.StatusBadRequest},
+		{http.MethodPut, "/admin/models/m", `{"enabled":true}`, http.StatusOK},
+		{http.MethodPut, "/admin/providers/nvidia", `{"enabled":false}`, http.StatusServiceUnavailable},
+		{http.MethodPut, "/admin/providers/nvidia", `{"enabled":true}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if status, out := call(tt.method, tt.path, tt.body); status != http.StatusOK {
+			t.Fatalf("%s %s: %d %v", tt.method, tt.path, status, out)
+		}
+		if rec := postMessagesAs(s, secret, `{"model":"m","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`); rec.Code != tt.status {
+			t.Errorf("after %s %s: message status %d, want %d", tt.path, tt.body, rec.Code, tt.status)
+		}
+	}
+	if status, _ := call(http.MethodPut, "/admin/providers/other", `{"enabled":false}`); status != http.StatusNotFound {
+		t.Errorf("unknown provider: %d", status)
+	}
+	if _, out := call(http.MethodGet, "/admin/models", ""); !strings.Contains(mustJSON(t, out), `{"enabled":false,"model":"org/m"}`) {
+		t.Errorf("models = %v", out)
+	}
+}
+
+// postMessagesAs is postMessages with an inbound API key.
+func postMessagesAs(s *Server, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+	req.Header.Set("x-api-key", key)
+	rec := httptest.NewRecorder()
+	s.HandleMessages(rec, req)
+	return rec
+}
+
+func mustJSON(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
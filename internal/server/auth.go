@@ -0,0 +1,85 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"claude-nvidia-proxy/internal/auth"
+)
+
+// authenticate resolves the caller from the key registry, falling back to
+// SERVER_API_KEY. It writes the error response and returns false when the
+// caller is rejected.
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request, reqID string) (auth.Identity, bool) {
+	client := clientKey(r)
+	if secret := inboundSecret(r); s.keys != nil && secret != "" {
+		key, found, err := s.keys.Lookup(secret)
+		if err != nil {
+			slog.Error("key registry reload failed, using previous keys", "req_id", reqID, "err", err)
+		}
+		if found {
+			if !key.IsEnabled() {
+				slog.Warn("inbound key is disabled", "req_id", reqID, "key_name", key.Name)
+				writeJSONError(w, http.StatusUnauthorized, "key_disabled")
+				return auth.Identity{}, false
+			}
+			return auth.Identity{Name: key.Name, Client: client, Policy: &key}, true
+		}
+	}
+	if s.cfg.ServerAPIKey != "" {
+		if !checkInboundAuth(r, s.cfg.ServerAPIKey) {
+			slog.Warn("inbound unauthorized", "req_id", reqID)
+			writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+			return auth.Identity{}, false
+		}
+		return auth.Identity{Name: "default", Client: client}, true
+	}
+	if s.keys != nil {
+		slog.Warn("inbound unauthorized", "req_id", reqID)
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+		return auth.Identity{}, false
+	}
+	return auth.Identity{Name: "anonymous", Client: client}, true
+}
+
+// authorizeModel applies the caller's model allowlist and token budgets.
+func (s *Server) authorizeModel(w http.ResponseWriter, reqID string, id auth.Identity, model string) bool {
+	if id.Policy == nil {
+		return true
+	}
+	if !id.Policy.AllowsModel(model) {
+		slog.Warn("model not allowed for key", "req_id", reqID, "key_name", id.Name, "model", model)
+		writeAnthropicError(w, http.StatusForbidden, "permission_error", fmt.Sprintf("API key %q is not allowed to use model %s", id.Name, model))
+		return false
+	}
+	now := time.Now().UTC()
+	for _, b := range []struct {
+		period string
+		budget int64
+		since  time.Time
+	}{
+		{"daily", id.Policy.DailyTokenBudget, time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)},
+		{"monthly", id.Policy.MonthlyTokenBudget, time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)},
+	} {
+		if b.budget <= 0 {
+			continue
+		}
+		if used := s.usage.Used(id.Name, b.since); used >= b.budget {
+			slog.Warn("token budget exhausted", "req_id", reqID, "key_name", id.Name, "model", model, "period", b.period, "tokens", used, "budget", b.budget)
+			writeAnthropicError(w, http.StatusForbidden, "permission_error", fmt.Sprintf("API key %q has used its %s token budget of %d", id.Name, b.period, b.budget))
+			return false
+		}
+	}
+	return true
+}
+
+// inboundSecret returns the bearer token or x-api-key presented by the caller.
+func inboundSecret(r *http.Request) string {
+	if header := strings.TrimSpace(r.Header.Get("Authorization")); strings.HasPrefix(strings.ToLower(header), "bearer ") {
+		return strings.TrimSpace(header[len("bearer "):])
+	}
+	return strings.TrimSpace(r.Header.Get("x-api-key"))
+}
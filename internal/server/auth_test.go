@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"claude-nvidia-proxy/internal/config"
+	"claude-nvidia-proxy/internal/mockupstream"
+)
+
+func TestKeyPolicy(t *testing.T) {
+	dir := t.TempDir()
+	keysFile := filepath.Join(dir, "keys.json")
+	if err := os.WriteFile(keysFile, []byte(`{"keys":[
+		{"name":"alice","key":"sk-alice","allowed_models":["m"]},
+		{"name":"bob","key":"sk-bob","daily_token_budget":10},
+		{"name":"carol","key":"sk-carol","enabled":false}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	configure := func(cfg *config.ServerConfig) {
+		cfg.KeysFile = keysFile
+		cfg.UsageFile = filepath.Join(dir, "usage.jsonl")
+	}
+	fixture := mockupstream.Fixture{
+		Message: mockupstream.Message{Content: "ok"},
+		Usage:   &mockupstream.Usage{PromptTokens: 10, CompletionTokens: 5},
+	}
+	send := func(s *Server, key, model string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"`+model+`","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		rec := httptest.NewRecorder()
+		s.HandleMessages(rec, req)
+		return rec
+	}
+
+	s, _ := newTestServerWith(t, configure, fixture)
+	tests := []struct {
+		name, key, model string
+		status           int
+		body             string
+	}{
+		{"no key", "", "m", http.StatusUnauthorized, "unauthorized"},
+		{"unknown key", "sk-nobody", "m", http.StatusUnauthorized, "unauthorized"},
+		{"disabled key", "sk-carol", "m", http.StatusUnauthorized, "key_disabled"},
+		{"allowed model", "sk-alice", "m", http.StatusOK, "ok"},
+		{"model not allowed", "sk-alice", "other", http.StatusForbidden, "not allowed to use model other"},
+		{"within budget", "sk-bob", "m", http.StatusOK, "ok"},
+		{"budget used", "sk-bob", "m", http.StatusForbidden, "daily token budget of 10"},
+	}
+	for _, tt := range tests {
+		rec := send(s, tt.key, tt.model)
+		if rec.Code != tt.status || !strings.Contains(rec.Body.String(), tt.body) {
+			t.Errorf("%s: %d %s, want %d containing %q", tt.name, rec.Code, rec.Body, tt.status, tt.body)
+		}
+	}
+
+	// Budgets come from the usage file, so a restart doesn't reset them.
+	restarted, _ := newTestServerWith(t, configure, fixture)
+	if rec := send(restarted, "sk-bob", "m"); rec.Code != http.StatusForbidden {
+		t.Errorf("after restart: %d %s, want the budget still used", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"claude-nvidia-proxy/internal/cache"
+	"claude-nvidia-proxy/internal/config"
+	"claude-nvidia-proxy/internal/types"
+)
+
+// cacheStatusHeader reports hit, miss or bypass for cacheable requests.
+const cacheStatusHeader = "x-proxy-cache"
+
+func newResponseCache(cfg *config.ServerConfig) (cache.Store, error) {
+	switch cfg.ResponseCache {
+	case "memory":
+		return cache.NewMemory(cfg.ResponseCacheMaxEntries), nil
+	case "disk":
+		return cache.NewDisk(cfg.ResponseCacheDir)
+	default:
+		return nil, nil
+	}
+}
+
+// responseCacheKey returns the cache key for a deterministic request
+// (temperature 0) from client and the cache status to report. The key is
+// empty when the cache is disabled, the request is not deterministic or the
+// client asked to bypass the cache with "x-proxy-cache: bypass" or
+// "Cache-Control: no-cache".
+func (s *Server) responseCacheKey(r *http.Request, client string, anthropicReq types.AnthropicMessageRequest, openaiReq types.OpenAIChatCompletionRequest) (string, string) {
+	if s.cache == nil || anthropicReq.Temperature == nil || *anthropicReq.Temperature != 0 {
+		return "", ""
+	}
+	cc := strings.ToLower(r.Header.Get("Cache-Control"))
+	if strings.EqualFold(strings.TrimSpace(r.Header.Get(cacheStatusHeader)), "bypass") ||
+		strings.Contains(cc, "no-cache") || strings.Contains(cc, "no-store") {
+		return "", "bypass"
+	}
+	key, err := cache.Key(client, s.cfg.UpstreamURL, openaiReq)
+	if err != nil {
+		return "", ""
+	}
+	return key, "miss"
+}
+
+// cachedResponse returns the entry stored under key, or nil on a miss.
+func (s *Server) cachedResponse(reqID, key string) *types.AnthropicMessageResponse {
+	if key == "" {
+		return nil
+	}
+	body, ok := s.cache.Get(key)
+	if !ok {
+		return nil
+	}
+	var resp types.AnthropicMessageResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		slog.Warn("discarding unreadable cache entry", "req_id", reqID, "err", err)
+		return nil
+	}
+	return &resp
+}
+
+// cachedUsage returns the token counts of a cached response as the ints
+// chargeUsage expects.
+func cachedUsage(resp types.AnthropicMessageResponse) map[string]any {
+	u, _ := resp.Usage.(map[string]any)
+	out := make(map[string]any, len(u))
+	for k, v := range u {
+		if n, ok := v.(float64); ok {
+			v = int(n)
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// serveCachedResponse answers from the cache, replaying the stored message as
+// SSE when the client asked to stream.
+func serveCachedResponse(w http.ResponseWriter, reqID string, resp types.AnthropicMessageResponse, stream bool) {
+	w.Header().Set(cacheStatusHeader, "hit")
+	if stream {
+		if err := writeMessageAsSSE(w, resp); err != nil {
+			slog.Warn("cached stream replay error", "req_id", reqID, "err", err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// storeResponse caches a complete JSON response. Streamed upstream responses
+// are never stored: they are forwarded as they arrive and only cached when
+// the same request is later made without streaming.
+func (s *Server) storeResponse(key string, resp types.AnthropicMessageResponse) {
+	if key == "" {
+		return
+	}
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	s.cache.Set(key, b, s.cfg.ResponseCacheTTL)
+}
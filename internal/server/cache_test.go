@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"claude-nvidia-proxy/internal/config"
+	"claude-nvidia-proxy/internal/mockupstream"
+	"claude-nvidia-proxy/internal/ratelimit"
+)
+
+func TestResponseCache(t *testing.T) {
+	s, mock := newTestServerWith(t, func(cfg *config.ServerConfig) {
+		cfg.ResponseCache = "memory"
+		cfg.ResponseCacheTTL = time.Minute
+		cfg.KeyRateLimits = ratelimit.Limits{RequestsPerMinute: 3}
+	}, mockupstream.Fixture{
+		Message: mockupstream.Message{Content: "cached answer"},
+		Usage:   &mockupstream.Usage{PromptTokens: 10, CompletionTokens: 5},
+	})
+	send := func(key, body string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+		req.Header.Set("x-api-key", key)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		s.HandleMessages(rec, req)
+		return rec
+	}
+	const body = `{"model":"m","max_tokens":10,"temperature":0,"messages":[{"role":"user","content":"hi"}]}`
+
+	tests := []struct {
+		name, key, body string
+		header          []string
+		status          int
+		cache           string
+		upstream        int // upstream requests so far
+	}{
+		{"miss", "a", body, nil, http.StatusOK, "miss", 1},
+		{"hit", "a", body, nil, http.StatusOK, "hit", 1},
+		{"stream hit", "a", strings.Replace(body, `"temperature"`, `"stream":true,"temperature"`, 1), nil, http.StatusOK, "hit", 1},
+		{"hit still rate limited", "a", body, nil, http.StatusTooManyRequests, "", 1},
+		{"other client misses", "b", body, nil, http.StatusOK, "miss", 2},
+		{"bypass", "b", body, []string{"Cache-Control", "no-cache"}, http.StatusOK, "bypass", 3},
+		{"not deterministic", "b", strings.Replace(body, `"temperature":0`, `"temperature":0.7`, 1), nil, http.StatusOK, "", 4},
+	}
+	for _, tt := range tests {
+		rec := send(tt.key, tt.body, tt.header...)
+		if rec.Code != tt.status || rec.Header().Get(cacheStatusHeader) != tt.cache {
+			t.Errorf("%s: status %d, cache %q; want %d, %q", tt.name, rec.Code, rec.Header().Get(cacheStatusHeader), tt.status, tt.cache)
+		}
+		if tt.status == http.StatusOK && !strings.Contains(rec.Body.String(), "cached answer") {
+			t.Errorf("%s: body %s", tt.name, rec.Body)
+		}
+		if got := len(mock.Requests()); got != tt.upstream {
+			t.Errorf("%s: upstream requests = %d, want %d", tt.name, got, tt.upstream)
+		}
+	}
+
+	// Usage is recorded for hits as well as for upstream responses.
+	if used := s.usage.Used("anonymous", time.Now().Add(-time.Hour)); used != 6*15 {
+		t.Errorf("recorded tokens = %d, want %d", used, 6*15)
+	}
+}
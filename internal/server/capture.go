@@ -0,0 +1,55 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"claude-nvidia-proxy/internal/capture"
+	"claude-nvidia-proxy/internal/types"
+	"claude-nvidia-proxy/internal/usage"
+)
+
+// startCapture returns an exchange to fill in when capture is enabled and
+// the request passes the model, key and header filters, or nil. The
+// response body is copied from sw from here on.
+func (s *Server) startCapture(r *http.Request, rec *usage.Record, req types.AnthropicMessageRequest, inbound []byte, sw *statusRecorder, start time.Time) *capture.Exchange {
+	if s.capture == nil {
+		return nil
+	}
+	cfg := s.cfg
+	filtered := len(cfg.CaptureModels) > 0 || len(cfg.CaptureKeys) > 0 || cfg.CaptureHeader != ""
+	matched := slices.Contains(cfg.CaptureModels, rec.Model) ||
+		slices.Contains(cfg.CaptureKeys, rec.Key) ||
+		(cfg.CaptureHeader != "" && r.Header.Get(cfg.CaptureHeader) != "")
+	if filtered && !matched {
+		return nil
+	}
+
+	ex := &capture.Exchange{
+		Time:      start.UTC(),
+		RequestID: rec.RequestID,
+		Key:       rec.Key,
+		Model:     rec.Model,
+		Stream:    rec.Stream,
+		Inbound:   json.RawMessage(bytes.TrimSpace(inbound)),
+	}
+	if !json.Valid(ex.Inbound) {
+		// Trailing bytes after the JSON value; keep what was decoded.
+		ex.Inbound, _ = json.Marshal(req)
+	}
+	sw.body = &bytes.Buffer{}
+	return ex
+}
+
+// saveCapture writes ex once the response has been sent.
+func (s *Server) saveCapture(ex *capture.Exchange, sw *statusRecorder) {
+	ex.Status = sw.status
+	ex.Response = sw.body.String()
+	if err := s.capture.Write(ex); err != nil {
+		slog.Error("capture write failed", "req_id", ex.RequestID, "err", err)
+	}
+}
@@ -0,0 +1,713 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"claude-nvidia-proxy/internal/config"
+	"claude-nvidia-proxy/internal/converter"
+	"claude-nvidia-proxy/internal/mockupstream"
+	"claude-nvidia-proxy/internal/types"
+)
+
+// Conformance tests drive HandleMessages against the mock upstream and check
+// the output against the Anthropic Messages API: the SSE event grammar,
+// block indices, JSON shapes, stop reasons and usage.
+
+type sseEvent struct {
+	name string
+	data map[string]any
+}
+
+// parseSSE splits an SSE body into events, failing on frames whose event
+// name and payload type disagree.
+func parseSSE(t *testing.T, body string) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	for _, frame := range strings.Split(strings.TrimSpace(body), "\n\n") {
+		var ev sseEvent
+		for _, line := range strings.Split(frame, "\n") {
+			if name, ok := strings.CutPrefix(line, "event: "); ok {
+				ev.name = name
+			} else if data, ok := strings.CutPrefix(line, "data: "); ok {
+				if err := json.Unmarshal([]byte(data), &ev.data); err != nil {
+					t.Fatalf("event data is not JSON: %q", data)
+				}
+			}
+		}
+		if ev.name == "" || ev.data == nil {
+			t.Fatalf("incomplete SSE frame: %q", frame)
+		}
+		if ev.data["type"] != ev.name {
+			t.Fatalf("event %q carries payload type %v", ev.name, ev.data["type"])
+		}
+		events = append(events, ev)
+	}
+	return events
+}
+
+// block is a content block as assembled from a stream or read from a
+// non-streaming response.
+type block struct {
+	Type  string
+	Text  string
+	ID    string
+	Name  string
+	Input any
+}
+
+// message is the part of a response the scenarios compare.
+type message struct {
+	Blocks     []block
+	StopReason string
+	Usage      map[string]float64
+}
+
+var validStopReasons = map[string]bool{"end_turn": true, "max_tokens": true, "stop_sequence": true, "tool_use": true}
+
+// checkStream validates the event grammar
+//
+//	message_start (content_block_start content_block_delta* content_block_stop)* message_delta message_stop
+//
+// with ping allowed anywhere, and assembles the message. A stream that
+// fails ends with an error event instead of message_delta and message_stop;
+// errEvent returns it.
+func checkStream(t *testing.T, events []sseEvent) (msg message, errEvent map[string]any) {
+	t.Helper()
+	return assembleStream(t, events, true)
+}
+
+// assembleStream is checkStream with the tool input check optional: the
+// proxy forwards upstream argument fragments verbatim, so their
+// concatenation is only guaranteed to be JSON when the upstream's was.
+func assembleStream(t *testing.T, events []sseEvent, strictInput bool) (msg message, errEvent map[string]any) {
+	t.Helper()
+	if len(events) == 0 || events[0].name != "message_start" {
+		t.Fatalf("stream does not begin with message_start: %+v", events)
+	}
+	start, _ := events[0].data["message"].(map[string]any)
+	if start["type"] != "message" || start["role"] != "assistant" || !strings.HasPrefix(asString(start["id"]), "msg_") {
+		t.Errorf("message_start.message = %v", start)
+	}
+	if content, ok := start["content"].([]any); !ok || len(content) != 0 {
+		t.Errorf("message_start content = %v, want []", start["content"])
+	}
+
+	open := -1 // index of the open block
+	var partialJSON strings.Builder
+	sawDelta := false
+	for i, ev := range events[1:] {
+		d := ev.data
+		switch ev.name {
+		case "ping":
+		case "content_block_start":
+			if open >= 0 {
+				t.Fatalf("content_block_start while block %d is open", open)
+			}
+			if sawDelta {
+				t.Fatal("content_block_start after message_delta")
+			}
+			if idx := asInt(d["index"]); idx != len(msg.Blocks) {
+				t.Fatalf("content_block_start index %d, want %d", idx, len(msg.Blocks))
+			}
+			cb, _ := d["content_block"].(map[string]any)
+			b := block{Type: asString(cb["type"])}
+			switch b.Type {
+			case "text":
+				if cb["text"] != "" {
+					t.Errorf("text block starts with text %v", cb["text"])
+				}
+			case "tool_use":
+				b.ID, b.Name = asString(cb["id"]), asString(cb["name"])
+				if b.ID == "" || b.Name == "" {
+					t.Errorf("tool_use block without id or name: %v", cb)
+				}
+				if input, ok := cb["input"].(map[string]any); !ok || len(input) != 0 {
+					t.Errorf("tool_use block starts with input %v, want {}", cb["input"])
+				}
+			case "thinking":
+			default:
+				t.Fatalf("unknown content block type %q", b.Type)
+			}
+			msg.Blocks = append(msg.Blocks, b)
+			open = len(msg.Blocks) - 1
+			partialJSON.Reset()
+		case "content_block_delta":
+			if idx := asInt(d["index"]); idx != open {
+				t.Fatalf("delta for block %d while block %d is open", idx, open)
+			}
+			delta, _ := d["delta"].(map[string]any)
+			b := &msg.Blocks[open]
+			want := map[string]string{"text": "text_delta", "tool_use": "input_json_delta", "thinking": "thinking_delta"}[b.Type]
+			if delta["type"] != want {
+				t.Fatalf("%s block got a %v", b.Type, delta["type"])
+			}
+			b.Text += asString(delta["text"]) + asString(delta["thinking"])
+			partialJSON.WriteString(asString(delta["partial_json"]))
+		case "content_block_stop":
+			if idx := asInt(d["index"]); idx != open {
+				t.Fatalf("content_block_stop for block %d while block %d is open", idx, open)
+			}
+			if b := &msg.Blocks[open]; b.Type == "tool_use" {
+				b.Input = map[string]any{}
+				if partialJSON.Len() > 0 {
+					if err := json.Unmarshal([]byte(partialJSON.String()), &b.Input); err != nil && strictInput {
+						t.Errorf("tool_use %s input is not JSON: %q", b.ID, partialJSON.String())
+					}
+				}
+			}
+			open = -1
+		case "message_delta":
+			if open >= 0 {
+				t.Fatalf("message_delta while block %d is open", open)
+			}
+			if sawDelta {
+				t.Fatal("second message_delta")
+			}
+			sawDelta = true
+			delta, _ := d["delta"].(map[string]any)
+			msg.StopReason = asString(delta["stop_reason"])
+			msg.Usage = usageOf(t, d["usage"])
+		case "message_stop":
+			if !sawDelta {
+				t.Fatal("message_stop before message_delta")
+			}
+			if rest := events[i+2:]; len(rest) > 0 {
+				t.Fatalf("events after message_stop: %+v", rest)
+			}
+			return msg, nil
+		case "error":
+			if rest := events[i+2:]; len(rest) > 0 {
+				t.Fatalf("events after error: %+v", rest)
+			}
+			return msg, d
+		default:
+			t.Fatalf("unknown event %q", ev.name)
+		}
+	}
+	t.Fatal("stream ended without message_stop or error")
+	return msg, nil
+}
+
+// checkJSON validates a non-streaming response and extracts the message.
+func checkJSON(t *testing.T, body []byte) message {
+	t.Helper()
+	var resp map[string]any
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("response is not JSON: %s", body)
+	}
+	if resp["type"] != "message" || resp["role"] != "assistant" || asString(resp["id"]) == "" {
+		t.Errorf("response envelope = type %v, role %v, id %v", resp["type"], resp["role"], resp["id"])
+	}
+	if _, ok := resp["stop_sequence"]; !ok {
+		t.Error("response has no stop_sequence")
+	}
+	content, ok := resp["content"].([]any)
+	if !ok {
+		t.Fatalf("content = %v, want an array", resp["content"])
+	}
+	var msg message
+	for _, c := range content {
+		cb, _ := c.(map[string]any)
+		b := block{Type: asString(cb["type"])}
+		switch b.Type {
+		case "text":
+			b.Text = asString(cb["text"])
+		case "tool_use":
+			b.ID, b.Name, b.Input = asString(cb["id"]), asString(cb["name"]), cb["input"]
+			if _, ok := b.Input.(map[string]any); !ok {
+				t.Errorf("tool_use input = %v, want an object", cb["input"])
+			}
+		default:
+			t.Errorf("unexpected content block %v", cb)
+		}
+		msg.Blocks = append(msg.Blocks, b)
+	}
+	msg.StopReason = asString(resp["stop_reason"])
+	msg.Usage = usageOf(t, resp["usage"])
+	return msg
+}
+
+func usageOf(t *testing.T, v any) map[string]float64 {
+	t.Helper()
+	u, ok := v.(map[string]any)
+	if !ok {
+		t.Fatalf("usage = %v, want an object", v)
+	}
+	out := map[string]float64{}
+	for _, k := range []string{"input_tokens", "output_tokens", "cache_read_input_tokens", "cache_creation_input_tokens"} {
+		n, ok := u[k].(float64)
+		if !ok {
+			t.Errorf("usage.%s = %v, want a number", k, u[k])
+		}
+		out[k] = n
+	}
+	return out
+}
+
+func asString(v any) string {
+	s, _ := v.(string)
+	return s
+}
+
+func asInt(v any) int {
+	f, _ := v.(float64)
+	return int(f)
+}
+
+const conformanceTools = `"tools":[
+	{"name":"get_weather","input_schema":{"type":"object","properties":{"city":{"type":"string"}}}},
+	{"name":"get_time","input_schema":{"type":"object","properties":{"tz":{"type":"string"}}}}]`
+
+func TestConformance(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture mockupstream.Fixture
+		want    message
+	}{
+		{
+			name: "text",
+			fixture: mockupstream.Fixture{
+				Message:    mockupstream.Message{Content: "Hello, world! How can I help?"},
+				ChunkRunes: 4,
+				Usage:      &mockupstream.Usage{PromptTokens: 12, CompletionTokens: 8},
+			},
+			want: message{
+				Blocks:     []block{{Type: "text", Text: "Hello, world! How can I help?"}},
+				StopReason: "end_turn",
+				Usage:      map[string]float64{"input_tokens": 12, "output_tokens": 8, "cache_read_input_tokens": 0, "cache_creation_input_tokens": 0},
+			},
+		},
+		{
+			name: "multi-tool",
+			fixture: mockupstream.Fixture{
+				Message: mockupstream.Message{ToolCalls: []mockupstream.ToolCall{
+					{ID: "call_1", Name: "get_weather", Arguments: `{"city":"Paris"}`},
+					{ID: "call_2", Name: "get_time", Arguments: `{"tz":"Europe/Paris"}`},
+				}},
+				ChunkRunes: 5,
+				Usage:      &mockupstream.Usage{PromptTokens: 50, CompletionTokens: 20},
+			},
+			want: message{
+				Blocks: []block{
+					{Type: "tool_use", ID: "call_1", Name: "get_weather", Input: map[string]any{"city": "Paris"}},
+					{Type: "tool_use", ID: "call_2", Name: "get_time", Input: map[string]any{"tz": "Europe/Paris"}},
+				},
+				StopReason: "tool_use",
+				Usage:      map[string]float64{"input_tokens": 50, "output_tokens": 20, "cache_read_input_tokens": 0, "cache_creation_input_tokens": 0},
+			},
+		},
+		{
+			name: "mixed text and tool",
+			fixture: mockupstream.Fixture{
+				Message: mockupstream.Message{
+					Content:   "Let me look that up.",
+					ToolCalls: []mockupstream.ToolCall{{ID: "call_9", Name: "get_weather", Arguments: `{"city":"Oslo"}`}},
+				},
+				ChunkRunes: 3,
+				Usage:      &mockupstream.Usage{PromptTokens: 30, CompletionTokens: 15, CachedTokens: 10},
+			},
+			want: message{
+				Blocks: []block{
+					{Type: "text", Text: "Let me look that up."},
+					{Type: "tool_use", ID: "call_9", Name: "get_weather", Input: map[string]any{"city": "Oslo"}},
+				},
+				StopReason: "tool_use",
+				Usage:      map[string]float64{"input_tokens": 20, "output_tokens": 15, "cache_read_input_tokens": 10, "cache_creation_input_tokens": 0},
+			},
+		},
+		{
+			name: "tool without arguments",
+			fixture: mockupstream.Fixture{
+				Message: mockupstream.Message{ToolCalls: []mockupstream.ToolCall{{ID: "call_0", Name: "get_time"}}},
+				Usage:   &mockupstream.Usage{PromptTokens: 5, CompletionTokens: 2},
+			},
+			want: message{
+				Blocks:     []block{{Type: "tool_use", ID: "call_0", Name: "get_time", Input: map[string]any{}}},
+				StopReason: "tool_use",
+				Usage:      map[string]float64{"input_tokens": 5, "output_tokens": 2, "cache_read_input_tokens": 0, "cache_creation_input_tokens": 0},
+			},
+		},
+		{
+			name: "tool without a name",
+			fixture: mockupstream.Fixture{
+				Message: mockupstream.Message{ToolCalls: []mockupstream.ToolCall{{ID: "call_0", Name: "get_time"}, {ID: "call_1", Arguments: `{"x":1}`}}},
+				Usage:   &mockupstream.Usage{PromptTokens: 5, CompletionTokens: 2},
+			},
+			want: message{
+				Blocks: []block{
+					{Type: "tool_use", ID: "call_0", Name: "get_time", Input: map[string]any{}},
+					{Type: "tool_use", ID: "call_1", Name: "tool_1", Input: map[string]any{"x": 1.0}},
+				},
+				StopReason: "tool_use",
+				Usage:      map[string]float64{"input_tokens": 5, "output_tokens": 2, "cache_read_input_tokens": 0, "cache_creation_input_tokens": 0},
+			},
+		},
+		{
+			name: "max tokens",
+			fixture: mockupstream.Fixture{
+				Message:      mockupstream.Message{Content: "This answer was cut"},
+				FinishReason: "length",
+				Usage:        &mockupstream.Usage{PromptTokens: 9, CompletionTokens: 100},
+			},
+			want: message{
+				Blocks:     []block{{Type: "text", Text: "This answer was cut"}},
+				StopReason: "max_tokens",
+				Usage:      map[string]float64{"input_tokens": 9, "output_tokens": 100, "cache_read_input_tokens": 0, "cache_creation_input_tokens": 0},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, stream := range []bool{false, true} {
+			name := tt.name + "/json"
+			if stream {
+				name = tt.name + "/stream"
+			}
+			t.Run(name, func(t *testing.T) {
+				s, _ := newTestServer(t, tt.fixture)
+				body := `{"model":"test/model","max_tokens":512,"stream":` + map[bool]string{false: "false", true: "true"}[stream] +
+					`,"messages":[{"role":"user","content":"hi"}],` + conformanceTools + `}`
+				rec := postMessages(s, body)
+				if rec.Code != http.StatusOK {
+					t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+				}
+
+				var got message
+				if stream {
+					if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+						t.Errorf("Content-Type = %q", ct)
+					}
+					var errEvent map[string]any
+					got, errEvent = checkStream(t, parseSSE(t, rec.Body.String()))
+					if errEvent != nil {
+						t.Fatalf("unexpected error event: %v", errEvent)
+					}
+				} else {
+					got = checkJSON(t, rec.Body.Bytes())
+				}
+				if !validStopReasons[got.StopReason] {
+					t.Errorf("invalid stop_reason %q", got.StopReason)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("message mismatch\n got: %+v\nwant: %+v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+// Calls without an id, or repeating an earlier one, get a generated id so
+// tool_result blocks can be matched to them.
+func TestConformanceToolCallIDs(t *testing.T) {
+	fixture := mockupstream.Fixture{
+		Message: mockupstream.Message{ToolCalls: []mockupstream.ToolCall{
+			{ID: "call_1", Name: "get_weather", Arguments: `{"city":"Paris"}`},
+			{ID: "call_1", Name: "get_time", Arguments: `{"tz":"UTC"}`},
+			{Name: "get_time", Arguments: `{}`},
+		}},
+		Usage: &mockupstream.Usage{PromptTokens: 5, CompletionTokens: 2},
+	}
+	for _, stream := range []string{"false", "true"} {
+		t.Run("stream="+stream, func(t *testing.T) {
+			s, _ := newTestServer(t, fixture)
+			rec := postMessages(s, `{"model":"m","max_tokens":10,"stream":`+stream+`,"messages":[{"role":"user","content":"hi"}],`+conformanceTools+`}`)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+			}
+			var got message
+			if stream == "true" {
+				got, _ = checkStream(t, parseSSE(t, rec.Body.String()))
+			} else {
+				got = checkJSON(t, rec.Body.Bytes())
+			}
+			if len(got.Blocks) != 3 {
+				t.Fatalf("blocks = %+v", got.Blocks)
+			}
+			if got.Blocks[0].ID != "call_1" {
+				t.Errorf("first id = %q, want call_1", got.Blocks[0].ID)
+			}
+			for _, b := range got.Blocks[1:] {
+				if !strings.HasPrefix(b.ID, converter.GeneratedToolIDPrefix) {
+					t.Errorf("id = %q, want a generated id", b.ID)
+				}
+			}
+			if got.Blocks[1].ID == got.Blocks[2].ID {
+				t.Errorf("generated ids repeat: %q", got.Blocks[1].ID)
+			}
+			if want := map[string]any{"tz": "UTC"}; !reflect.DeepEqual(got.Blocks[1].Input, want) {
+				t.Errorf("second input = %v, want %v", got.Blocks[1].Input, want)
+			}
+		})
+	}
+}
+
+// Emulated calls whose input is not an object stay in the text, so every
+// tool_use block the client sees has an object input.
+func TestConformanceEmulatedNonObjectInput(t *testing.T) {
+	const reply = "Let me check.\n<tool_call>\n{\"name\": \"get_time\", \"input\": [\"UTC\"]}\n</tool_call>\n" +
+		"<tool_call>\n{\"name\": \"get_time\", \"input\": {\"tz\": \"UTC\"}}\n</tool_call>"
+	for _, stream := range []string{"false", "true"} {
+		t.Run("stream="+stream, func(t *testing.T) {
+			s, _ := newTestServerWith(t, func(cfg *config.ServerConfig) {
+				cfg.Models = map[string]types.ModelCapabilities{"emulated": {SystemRole: true}}
+			}, mockupstream.Fixture{
+				Message:    mockupstream.Message{Content: reply},
+				ChunkRunes: 7,
+				Usage:      &mockupstream.Usage{PromptTokens: 5, CompletionTokens: 2},
+			})
+			rec := postMessages(s, `{"model":"emulated","max_tokens":10,"stream":`+stream+`,"messages":[{"role":"user","content":"hi"}],`+conformanceTools+`}`)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+			}
+			var got message
+			if stream == "true" {
+				got, _ = checkStream(t, parseSSE(t, rec.Body.String()))
+			} else {
+				got = checkJSON(t, rec.Body.Bytes())
+			}
+			var text strings.Builder
+			var calls []block
+			for _, b := range got.Blocks {
+				if b.Type == "tool_use" {
+					calls = append(calls, b)
+				} else {
+					text.WriteString(b.Text)
+				}
+			}
+			if !strings.Contains(text.String(), `"input": ["UTC"]`) {
+				t.Errorf("text = %q, want the non-object call kept", text.String())
+			}
+			if len(calls) != 1 || calls[0].Name != "get_time" || !reflect.DeepEqual(calls[0].Input, map[string]any{"tz": "UTC"}) ||
+				!strings.HasPrefix(calls[0].ID, converter.GeneratedToolIDPrefix) {
+				t.Errorf("tool calls = %+v", calls)
+			}
+			if got.StopReason != "tool_use" {
+				t.Errorf("stop_reason = %q", got.StopReason)
+			}
+		})
+	}
+}
+
+// newStreamTestServer is a server whose upstream answers every request
+// with body as an event stream.
+func newStreamTestServer(t *testing.T, body string) *Server {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, body)
+	}))
+	t.Cleanup(upstream.Close)
+	s, _ := newTestServerWith(t, func(cfg *config.ServerConfig) {
+		cfg.UpstreamURL = upstream.URL + "/v1/chat/completions"
+	})
+	return s
+}
+
+// toolChunk is an upstream stream event carrying one tool call fragment.
+func toolChunk(index int, id, name, args string) string {
+	fn := map[string]any{"arguments": args}
+	if name != "" {
+		fn["name"] = name
+	}
+	tc := map[string]any{"index": index, "function": fn}
+	if id != "" {
+		tc["id"], tc["type"] = id, "function"
+	}
+	b, _ := json.Marshal(map[string]any{"choices": []any{map[string]any{"index": 0, "delta": map[string]any{"tool_calls": []any{tc}}}}})
+	return "data: " + string(b) + "\n\n"
+}
+
+const toolStreamEnd = "data: {\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"tool_calls\"}]}\n\ndata: [DONE]\n\n"
+
+func TestConformanceInterleavedToolCalls(t *testing.T) {
+	seed, err := os.ReadFile(filepath.Join("testdata", "streams", "interleaved_tool_calls.sse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		body string
+		want []block
+	}{
+		{
+			name: "two calls alternating",
+			body: string(seed),
+			want: []block{
+				{Type: "tool_use", ID: "call_0", Name: "get_weather", Input: map[string]any{"city": "Oslo"}},
+				{Type: "tool_use", ID: "call_1", Name: "get_time", Input: map[string]any{"tz": "UTC"}},
+			},
+		},
+		{
+			name: "buffered call completes first",
+			body: toolChunk(0, "call_a", "get_weather", `{"city":`) +
+				toolChunk(1, "call_b", "get_time", `{"tz":"UTC"}`) +
+				toolChunk(2, "call_c", "get_time", `{"tz":`) +
+				toolChunk(0, "", "", `"Rome"}`) +
+				toolChunk(2, "", "", `"CET"}`) + toolStreamEnd,
+			want: []block{
+				{Type: "tool_use", ID: "call_a", Name: "get_weather", Input: map[string]any{"city": "Rome"}},
+				{Type: "tool_use", ID: "call_b", Name: "get_time", Input: map[string]any{"tz": "UTC"}},
+				{Type: "tool_use", ID: "call_c", Name: "get_time", Input: map[string]any{"tz": "CET"}},
+			},
+		},
+		{
+			name: "incomplete calls flushed at finish",
+			body: toolChunk(0, "call_a", "get_weather", `{"city":"Rome"`) +
+				toolChunk(1, "call_b", "get_time", `{"tz":"UTC"}`) + toolStreamEnd,
+			want: []block{
+				{Type: "tool_use", ID: "call_a", Name: "get_weather", Input: map[string]any{}},
+				{Type: "tool_use", ID: "call_b", Name: "get_time", Input: map[string]any{"tz": "UTC"}},
+			},
+		},
+		{
+			name: "id and name after the first fragment",
+			body: toolChunk(0, "call_a", "get_weather", `{"city":`) +
+				toolChunk(1, "", "", `{"tz":`) +
+				toolChunk(1, "call_b", "get_time", `"UTC"}`) +
+				toolChunk(0, "", "", `"Rome"}`) + toolStreamEnd,
+			want: []block{
+				{Type: "tool_use", ID: "call_a", Name: "get_weather", Input: map[string]any{"city": "Rome"}},
+				{Type: "tool_use", ID: "call_b", Name: "get_time", Input: map[string]any{"tz": "UTC"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStreamTestServer(t, tt.body)
+			rec := postMessages(s, `{"model":"m","max_tokens":10,"stream":true,"messages":[{"role":"user","content":"hi"}],`+conformanceTools+`}`)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+			}
+			got, errEvent := assembleStream(t, parseSSE(t, rec.Body.String()), false)
+			if errEvent != nil {
+				t.Fatalf("unexpected error event: %v", errEvent)
+			}
+			if !reflect.DeepEqual(got.Blocks, tt.want) {
+				t.Errorf("blocks\n got: %+v\nwant: %+v", got.Blocks, tt.want)
+			}
+			metrics := httptest.NewRecorder()
+			s.MetricsHandler().ServeHTTP(metrics, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			if strings.Contains(metrics.Body.String(), `stage="stream_tool_delta"`) {
+				t.Error("tool call arguments were dropped")
+			}
+		})
+	}
+}
+
+func TestConformanceUnterminatedFinalEvent(t *testing.T) {
+	text := "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hi\"}}]}\n\n"
+	final := `data: {"choices":[{"index":0,"delta":{},"finish_reason":"length"}]}`
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"without the blank line", text + final + "\n"},
+		{"without a line ending", text + final},
+		{"with a CR line ending", text + final + "\r"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStreamTestServer(t, tt.body)
+			rec := postMessages(s, `{"model":"m","max_tokens":10,"stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+			}
+			got, errEvent := assembleStream(t, parseSSE(t, rec.Body.String()), true)
+			if errEvent != nil {
+				t.Fatalf("unexpected error event: %v", errEvent)
+			}
+			if got.StopReason != "max_tokens" {
+				t.Errorf("stop_reason = %q, want max_tokens from the unterminated event", got.StopReason)
+			}
+			metrics := httptest.NewRecorder()
+			s.MetricsHandler().ServeHTTP(metrics, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			if !strings.Contains(metrics.Body.String(), `proxy_conversion_failures_total{stage="stream_unterminated"} 1`) {
+				t.Errorf("unterminated event not counted:\n%s", metrics.Body)
+			}
+		})
+	}
+}
+
+func TestConformanceErrors(t *testing.T) {
+	t.Run("upstream error status", func(t *testing.T) {
+		s, _ := newTestServer(t, mockupstream.Fixture{Status: http.StatusInternalServerError})
+		for _, stream := range []string{"false", "true"} {
+			rec := postMessages(s, `{"model":"m","max_tokens":10,"stream":`+stream+`,"messages":[{"role":"user","content":"hi"}]}`)
+			if rec.Code != http.StatusInternalServerError {
+				t.Errorf("stream=%s: status = %d, want 500", stream, rec.Code)
+			}
+			if !json.Valid(rec.Body.Bytes()) {
+				t.Errorf("stream=%s: error body is not JSON: %s", stream, rec.Body)
+			}
+		}
+	})
+
+	t.Run("invalid request", func(t *testing.T) {
+		s, mock := newTestServer(t)
+		for _, body := range []string{`{"model":`, `{"max_tokens":10,"messages":[]}`} {
+			rec := postMessages(s, body)
+			if rec.Code != http.StatusBadRequest || !json.Valid(rec.Body.Bytes()) {
+				t.Errorf("%s: status = %d body %s, want a 400 JSON error", body, rec.Code, rec.Body)
+			}
+		}
+		if n := len(mock.Requests()); n != 0 {
+			t.Errorf("invalid requests reached the upstream %d times", n)
+		}
+	})
+
+	t.Run("stream interrupted", func(t *testing.T) {
+		s, _ := newTestServer(t, mockupstream.Fixture{
+			Message:    mockupstream.Message{Content: "This will not finish"},
+			ChunkRunes: 2,
+			BreakAfter: 4,
+		})
+		rec := postMessages(s, `{"model":"m","max_tokens":10,"stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d", rec.Code)
+		}
+		got, errEvent := checkStream(t, parseSSE(t, rec.Body.String()))
+		if errEvent == nil {
+			t.Fatal("interrupted stream did not end with an error event")
+		}
+		if e, _ := errEvent["error"].(map[string]any); e["type"] != "api_error" || asString(e["message"]) == "" {
+			t.Errorf("error event = %v", errEvent)
+		}
+		if len(got.Blocks) != 1 || got.Blocks[0].Text != "This w" {
+			t.Errorf("blocks before the error = %+v", got.Blocks)
+		}
+	})
+
+	const firstChunk = "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Partial\"}}]}\n\n"
+	for _, tt := range []struct {
+		name, body, message string
+	}{
+		{"upstream error event", firstChunk + "event: error\ndata: {\"error\":{\"message\":\"model overloaded\",\"code\":503}}\n\n", "model overloaded"},
+		{"upstream error payload", firstChunk + "data: {\"error\":{\"message\":\"context length exceeded\"}}\n\n", "context length exceeded"},
+		{"event too large", firstChunk + "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"" + strings.Repeat("x", 2<<10) + "\"}}]}\n\n", "too large"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestServer(t, mockupstream.Fixture{Body: tt.body + "data: [DONE]\n\n"})
+			s.cfg.UpstreamMaxEventBytes = 1 << 10
+			rec := postMessages(s, `{"model":"m","max_tokens":10,"stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+			got, errEvent := checkStream(t, parseSSE(t, rec.Body.String()))
+			if errEvent == nil {
+				t.Fatal("stream did not end with an error event")
+			}
+			if e, _ := errEvent["error"].(map[string]any); e["type"] != "api_error" || !strings.Contains(asString(e["message"]), tt.message) {
+				t.Errorf("error event = %v, want a message containing %q", errEvent, tt.message)
+			}
+			if len(got.Blocks) != 1 || got.Blocks[0].Text != "Partial" {
+				t.Errorf("blocks before the error = %+v", got.Blocks)
+			}
+		})
+	}
+}
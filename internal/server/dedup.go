@@ -0,0 +1,322 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"claude-nvidia-proxy/internal/cache"
+	"claude-nvidia-proxy/internal/config"
+	"claude-nvidia-proxy/internal/tracing"
+	"claude-nvidia-proxy/internal/types"
+)
+
+// flightGroup coalesces concurrent identical upstream calls. Non-streaming
+// callers share one response body; streaming callers each read the upstream
+// event stream from a shared buffer, and may join until its first byte.
+type flightGroup struct {
+	mu      sync.Mutex
+	calls   map[string]*jsonFlight
+	streams map[string]*streamFlight
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: map[string]*jsonFlight{}, streams: map[string]*streamFlight{}}
+}
+
+// flightKey scopes coalescing to one inbound API key and one converted request.
+func flightKey(cfg *config.ServerConfig, client string, openaiReq types.OpenAIChatCompletionRequest) string {
+	key, err := cache.Key(client, cfg.UpstreamURL, openaiReq)
+	if err != nil {
+		return ""
+	}
+	return key
+}
+
+type jsonFlight struct {
+	done   chan struct{}
+	body   []byte
+	status int
+	err    error
+}
+
+// doUpstreamJSON runs the upstream call once per key; concurrent callers wait
+// for and share its result. The shared call is detached from any single
+// caller's context so one client going away doesn't fail the others.
+func (g *flightGroup) doUpstreamJSON(ctx context.Context, cfg *config.ServerConfig, key string, openaiReq types.OpenAIChatCompletionRequest) (body []byte, status int, shared bool, err error) {
+	g.mu.Lock()
+	if f, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		select {
+		case <-f.done:
+			return f.body, f.status, true, f.err
+		case <-ctx.Done():
+			return nil, 0, true, ctx.Err()
+		}
+	}
+	f := &jsonFlight{done: make(chan struct{})}
+	g.calls[key] = f
+	g.mu.Unlock()
+
+	body, resp, err := doUpstreamJSON(context.WithoutCancel(ctx), cfg, openaiReq)
+	f.body, f.err = body, err
+	if resp != nil {
+		f.status = resp.StatusCode
+	}
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	close(f.done)
+	return f.body, f.status, false, f.err
+}
+
+// streamFlight buffers one upstream event stream for its readers. buf holds
+// the stream from offset base on; bytes every reader has consumed are
+// dropped, so the buffer stays as small as the slowest reader's lag.
+type streamFlight struct {
+	ready   chan struct{}
+	status  int
+	mu      sync.Mutex
+	cond    *sync.Cond
+	buf     []byte
+	base    int
+	started bool
+	done    bool
+	err     error
+	readers map[*flightReader]bool
+	cancel  context.CancelFunc
+}
+
+// upstreamStream is an upstream streaming response, possibly shared.
+type upstreamStream struct {
+	status int
+	body   io.ReadCloser
+	shared bool
+}
+
+// openStream starts the upstream stream for key, or subscribes to the one
+// already in flight if it has not produced any data yet. A stream that has
+// started is not joined, since its beginning may no longer be buffered. The
+// upstream request is cancelled once every reader has closed its body.
+func (g *flightGroup) openStream(ctx context.Context, cfg *config.ServerConfig, key string, openaiReq types.OpenAIChatCompletionRequest) (*upstreamStream, error) {
+	var body *flightReader
+	g.mu.Lock()
+	f, shared := g.streams[key]
+	if shared {
+		f.mu.Lock()
+		if len(f.readers) == 0 || f.started {
+			// Abandoned, or too far along to join; start afresh.
+			shared = false
+		} else {
+			body = f.subscribe(ctx)
+		}
+		f.mu.Unlock()
+	}
+	if !shared {
+		upCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		f = &streamFlight{ready: make(chan struct{}), readers: map[*flightReader]bool{}, cancel: cancel}
+		f.cond = sync.NewCond(&f.mu)
+		body = f.subscribe(ctx)
+		g.streams[key] = f
+		go g.pump(upCtx, cfg, key, f, openaiReq)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-f.ready:
+	case <-ctx.Done():
+		_ = body.Close()
+		return nil, ctx.Err()
+	}
+	if f.status == 0 {
+		_ = body.Close()
+		return nil, f.err
+	}
+	return &upstreamStream{status: f.status, body: body, shared: shared}, nil
+}
+
+// pump performs the upstream request and copies its body into f until the
+// upstream ends or every reader has gone.
+func (g *flightGroup) pump(ctx context.Context, cfg *config.ServerConfig, key string, f *streamFlight, openaiReq types.OpenAIChatCompletionRequest) {
+	defer f.cancel()
+	defer func() {
+		g.mu.Lock()
+		if g.streams[key] == f {
+			delete(g.streams, key)
+		}
+		g.mu.Unlock()
+	}()
+
+	resp, err := openUpstreamStream(ctx, cfg, openaiReq)
+	if err != nil {
+		f.mu.Lock()
+		f.done, f.err = true, err
+		f.cond.Broadcast()
+		f.mu.Unlock()
+		close(f.ready)
+		return
+	}
+	defer resp.Body.Close()
+	f.status = resp.StatusCode
+	close(f.ready)
+
+	chunk := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(chunk)
+		f.mu.Lock()
+		f.buf = append(f.buf, chunk[:n]...)
+		f.started = f.started || n > 0
+		if err != nil {
+			f.done = true
+			if err != io.EOF {
+				f.err = err
+			}
+		}
+		f.cond.Broadcast()
+		f.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// flightReader reads a streamFlight from the beginning, blocking until more
+// data arrives, the upstream stream ends or its caller goes away.
+type flightReader struct {
+	f    *streamFlight
+	ctx  context.Context
+	stop func() bool
+	off  int
+}
+
+// subscribe adds a reader at the start of the stream. f.mu must be held.
+func (f *streamFlight) subscribe(ctx context.Context) *flightReader {
+	r := &flightReader{f: f, ctx: ctx}
+	r.stop = context.AfterFunc(ctx, func() {
+		f.mu.Lock()
+		f.cond.Broadcast()
+		f.mu.Unlock()
+	})
+	f.readers[r] = true
+	return r
+}
+
+// trim drops the buffered bytes every reader has consumed. f.mu must be
+// held.
+func (f *streamFlight) trim() {
+	low := f.base + len(f.buf)
+	for r := range f.readers {
+		low = min(low, r.off)
+	}
+	if low == f.base {
+		return
+	}
+	f.buf = f.buf[low-f.base:]
+	if len(f.buf) == 0 {
+		f.buf = nil
+	}
+	f.base = low
+}
+
+func (r *flightReader) Read(p []byte) (int, error) {
+	f := r.f
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for r.off >= f.base+len(f.buf) && !f.done && r.ctx.Err() == nil {
+		f.cond.Wait()
+	}
+	if r.off < f.base+len(f.buf) {
+		n := copy(p, f.buf[r.off-f.base:])
+		r.off += n
+		f.trim()
+		return n, nil
+	}
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if f.err != nil {
+		return 0, f.err
+	}
+	return 0, io.EOF
+}
+
+func (r *flightReader) Close() error {
+	r.stop()
+	f := r.f
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.readers[r] {
+		return nil
+	}
+	delete(f.readers, r)
+	f.trim()
+	if len(f.readers) == 0 && !f.done {
+		f.cancel()
+	}
+	return nil
+}
+
+// openStream opens the upstream stream for openaiReq, sharing it with
+// identical in-flight requests from the same client when dedup is enabled.
+func (s *Server) openStream(ctx context.Context, reqID, client string, openaiReq types.OpenAIChatCompletionRequest) (*upstreamStream, error) {
+	key := ""
+	if s.flights != nil {
+		key = flightKey(s.cfg, client, openaiReq)
+	}
+	if key == "" {
+		resp, err := openUpstreamStream(ctx, s.cfg, openaiReq)
+		if err != nil {
+			return nil, err
+		}
+		return &upstreamStream{status: resp.StatusCode, body: resp.Body}, nil
+	}
+	up, err := s.flights.openStream(ctx, s.cfg, key, openaiReq)
+	if err == nil && up.shared {
+		slog.Info("joined in-flight upstream stream", "req_id", reqID)
+	}
+	return up, err
+}
+
+// upstreamJSON performs a non-streaming upstream call, sharing it with
+// identical in-flight requests from the same client when dedup is enabled.
+// shared reports that another request made the call.
+func (s *Server) upstreamJSON(ctx context.Context, reqID, client string, openaiReq types.OpenAIChatCompletionRequest) (body []byte, status int, shared bool, err error) {
+	key := ""
+	if s.flights != nil {
+		key = flightKey(s.cfg, client, openaiReq)
+	}
+	if key == "" {
+		body, resp, err := doUpstreamJSON(ctx, s.cfg, openaiReq)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		return body, resp.StatusCode, false, nil
+	}
+	body, status, shared, err = s.flights.doUpstreamJSON(ctx, s.cfg, key, openaiReq)
+	if shared {
+		slog.Info("joined in-flight upstream request", "req_id", reqID)
+	}
+	return body, status, shared, err
+}
+
+func openUpstreamStream(ctx context.Context, cfg *config.ServerConfig, openaiReq types.OpenAIChatCompletionRequest) (*http.Response, error) {
+	bodyBytes, err := json.Marshal(openaiReq)
+	if err != nil {
+		return nil, err
+	}
+	upReq, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.UpstreamURL, bytes.NewReader(bodyBytes))
+	if err != nil {
+		return nil, err
+	}
+	upReq.Header.Set("Content-Type", "application/json")
+	upReq.Header.Set("Authorization", "Bearer "+cfg.ProviderAPIKey)
+	tracing.Inject(ctx, upReq.Header)
+
+	client := &http.Client{Timeout: 0}
+	return client.Do(upReq)
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"claude-nvidia-proxy/internal/config"
+	"claude-nvidia-proxy/internal/mockupstream"
+	"claude-nvidia-proxy/internal/types"
+)
+
+// gatedUpstream streams "first" and "second", each once its gate is closed,
+// counting the requests it receives.
+type gatedUpstream struct {
+	calls         atomic.Int32
+	first, second chan struct{}
+}
+
+func (u *gatedUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.calls.Add(1)
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	for _, part := range []struct {
+		gate chan struct{}
+		data string
+	}{{u.first, "first"}, {u.second, "second"}} {
+		select {
+		case <-part.gate:
+		case <-r.Context().Done():
+			return
+		}
+		_, _ = io.WriteString(w, part.data)
+		w.(http.Flusher).Flush()
+	}
+}
+
+func TestFlightGroupStream(t *testing.T) {
+	up := &gatedUpstream{first: make(chan struct{}), second: make(chan struct{})}
+	srv := httptest.NewServer(up)
+	defer srv.Close()
+	cfg := &config.ServerConfig{UpstreamURL: srv.URL}
+	g := newFlightGroup()
+	ctx := context.Background()
+	req := types.OpenAIChatCompletionRequest{Model: "m"}
+
+	a, err := g.openStream(ctx, cfg, "k", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := g.openStream(ctx, cfg, "k", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.shared || !b.shared {
+		t.Fatalf("shared = %v, %v; want only the second caller to join", a.shared, b.shared)
+	}
+
+	close(up.first)
+	buf := make([]byte, 5)
+	for _, s := range []*upstreamStream{a, b} {
+		if _, err := io.ReadFull(s.body, buf); err != nil || string(buf) != "first" {
+			t.Fatalf("read %q, %v", buf, err)
+		}
+	}
+	f := a.body.(*flightReader).f
+	f.mu.Lock()
+	buffered, base := len(f.buf), f.base
+	f.mu.Unlock()
+	if buffered != 0 || base != 5 {
+		t.Errorf("after both readers consumed the data: %d bytes buffered from offset %d", buffered, base)
+	}
+
+	// The stream has started, so an identical request gets its own call.
+	c, err := g.openStream(ctx, cfg, "k", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.shared || up.calls.Load() != 2 {
+		t.Errorf("late request shared = %v, upstream calls = %d", c.shared, up.calls.Load())
+	}
+
+	close(up.second)
+	for name, s := range map[string]*upstreamStream{"a": a, "b": b, "c": c} {
+		rest, err := io.ReadAll(s.body)
+		want := "second"
+		if name == "c" {
+			want = "firstsecond"
+		}
+		if err != nil || string(rest) != want {
+			t.Errorf("%s read %q, %v; want %q", name, rest, err, want)
+		}
+		_ = s.body.Close()
+	}
+}
+
+// Closing every reader cancels the upstream call.
+func TestFlightGroupStreamAbandoned(t *testing.T) {
+	up := &gatedUpstream{first: make(chan struct{}), second: make(chan struct{})}
+	srv := httptest.NewServer(up)
+	defer srv.Close()
+	g := newFlightGroup()
+	s, err := g.openStream(context.Background(), &config.ServerConfig{UpstreamURL: srv.URL}, "k", types.OpenAIChatCompletionRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = s.body.Close()
+	f := s.body.(*flightReader).f
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		f.mu.Lock()
+		done := f.done
+		f.mu.Unlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("upstream stream still running after every reader closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Concurrent duplicates share one upstream call, and only the request that
+// made it is charged.
+func TestDedupChargesOnce(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		s, mock := newTestServerWith(t, func(cfg *config.ServerConfig) {
+			cfg.RequestDedup = true
+		}, mockupstream.Fixture{
+			DelayMs: 200,
+			Message: mockupstream.Message{Content: "shared"},
+			Usage:   &mockupstream.Usage{PromptTokens: 10, CompletionTokens: 5},
+		})
+		body := `{"model":"m","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`
+		if stream {
+			body = strings.Replace(body, `"model"`, `"stream":true,"model"`, 1)
+		}
+
+		var wg sync.WaitGroup
+		recs := make([]*httptest.ResponseRecorder, 3)
+		for i := range recs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				recs[i] = postMessages(s, body)
+			}()
+		}
+		wg.Wait()
+
+		for i, rec := range recs {
+			if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "shared") {
+				t.Errorf("stream %v: response %d = %d %s", stream, i, rec.Code, rec.Body)
+			}
+		}
+		if n := len(mock.Requests()); n != 1 {
+			t.Errorf("stream %v: upstream requests = %d, want 1", stream, n)
+		}
+		if used := s.usage.Used("anonymous", time.Now().Add(-time.Hour)); used != 15 {
+			t.Errorf("stream %v: charged tokens = %d, want 15", stream, used)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"claude-nvidia-proxy/internal/config"
+	"claude-nvidia-proxy/internal/converter"
+	"claude-nvidia-proxy/internal/sse"
+	"claude-nvidia-proxy/internal/types"
+)
+
+// FuzzProxyStream feeds arbitrary upstream SSE bodies through the stream
+// converter, seeded with captures in testdata/streams. Run it with
+//
+//	go test ./internal/server -run '^$' -fuzz FuzzProxyStream
+func FuzzProxyStream(f *testing.F) {
+	files, err := filepath.Glob(filepath.Join("testdata", "streams", "*.sse"))
+	if err != nil || len(files) == 0 {
+		f.Fatal("no seed streams in testdata/streams")
+	}
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b)
+	}
+
+	var mu sync.Mutex
+	var body []byte
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		mu.Lock()
+		b := body
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write(b)
+	}))
+	f.Cleanup(upstream.Close)
+
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	f.Cleanup(func() { slog.SetDefault(prev) })
+
+	s, err := New(&config.ServerConfig{
+		UpstreamURL:    upstream.URL + "/v1/chat/completions",
+		ProviderAPIKey: "test-key",
+		Timeout:        10 * time.Second,
+		ResponseCache:  "off",
+		Models:         map[string]types.ModelCapabilities{"fuzz/emulated": {SystemRole: true}},
+	})
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		mu.Lock()
+		body = data
+		mu.Unlock()
+		upstream := readUpstreamToolCalls(data)
+
+		for _, model := range []string{"fuzz/native", "fuzz/emulated"} {
+			rec := postMessages(s, `{"model":"`+model+`","max_tokens":512,"stream":true,"messages":[{"role":"user","content":"hi"}],`+conformanceTools+`}`)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("%s: status = %d, body %s", model, rec.Code, rec.Body)
+			}
+			msg, errEvent := assembleStream(t, parseSSE(t, rec.Body.String()), upstream.intact)
+			if errEvent == nil && !validStopReasons[msg.StopReason] {
+				t.Errorf("%s: invalid stop_reason %q", model, msg.StopReason)
+			}
+
+			ids := map[string]bool{}
+			for _, b := range msg.Blocks {
+				if b.Type != "tool_use" {
+					continue
+				}
+				if ids[b.ID] {
+					t.Errorf("%s: duplicate tool_use id %q", model, b.ID)
+				}
+				ids[b.ID] = true
+				if !upstream.ids[b.ID] && !strings.HasPrefix(b.ID, converter.GeneratedToolIDPrefix) {
+					t.Errorf("%s: tool_use id %q was neither sent by the upstream nor generated", model, b.ID)
+				}
+				if !upstream.native {
+					// Every call was parsed from emulated tool call text.
+					if _, ok := b.Input.(map[string]any); !ok {
+						t.Errorf("%s: emulated tool_use %s input %v is not an object", model, b.ID, b.Input)
+					}
+				}
+			}
+		}
+	})
+}
+
+type upstreamToolCalls struct {
+	ids    map[string]bool
+	native bool
+	// intact is set when every call's arguments concatenate to valid JSON
+	// and no text or finish_reason arrives while some are incomplete, so
+	// the proxy forwards all of them.
+	intact bool
+}
+
+// readUpstreamToolCalls reads the native tool calls in an upstream SSE body
+// the way proxyStream does.
+func readUpstreamToolCalls(body []byte) upstreamToolCalls {
+	out := upstreamToolCalls{ids: map[string]bool{}, intact: true}
+	args := map[int]*strings.Builder{}
+	incomplete := func() bool {
+		for _, a := range args {
+			if !completeToolArgs(a.String()) {
+				return true
+			}
+		}
+		return false
+	}
+	finished := false
+	d := sse.NewDecoder(bytes.NewReader(body), 0)
+	for {
+		ev, err := d.Next()
+		if errors.Is(err, io.EOF) {
+			// proxyStream still handles an event the upstream left open.
+			var ok bool
+			if ev, ok = d.Unterminated(); ok {
+				err = nil
+			}
+		}
+		if err != nil {
+			break
+		}
+		data := strings.TrimSpace(ev.Data)
+		if _, failed := upstreamStreamError(ev.Type, data); failed || data == "[DONE]" {
+			break
+		}
+		var chunk types.OpenAIChatCompletionChunk
+		if json.Unmarshal([]byte(data), &chunk) != nil || len(chunk.Choices) == 0 {
+			continue
+		}
+		delta := chunk.Choices[0].Delta
+		for _, tc := range delta.ToolCalls {
+			out.native = true
+			if id := strings.TrimSpace(tc.ID); id != "" {
+				out.ids[id] = true
+			}
+			if finished && tc.Function.Arguments != "" {
+				out.intact = false
+			}
+			a := args[max(tc.Index, 0)]
+			if a == nil {
+				a = &strings.Builder{}
+				args[max(tc.Index, 0)] = a
+			}
+			a.WriteString(tc.Function.Arguments)
+		}
+		if delta.Content != nil && *delta.Content != "" && incomplete() {
+			out.intact = false
+		}
+		if chunk.Choices[0].FinishReason != nil {
+			finished = true
+		}
+	}
+	for _, a := range args {
+		if a.Len() > 0 && !json.Valid([]byte(a.String())) {
+			out.intact = false
+		}
+	}
+	return out
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"claude-nvidia-proxy/internal/metrics"
+	"claude-nvidia-proxy/internal/usage"
+)
+
+// otherModel is the model label of every model without a label of its own.
+const otherModel = "other"
+
+// proxyMetrics are the series exported at /metrics. The model label takes
+// the configured models plus the first maxSeen other names, so clients
+// can't grow it without bound by sending arbitrary model names; key names
+// are left to the usage reports.
+type proxyMetrics struct {
+	registry *metrics.Registry
+	listed   map[string]bool
+	maxSeen  int
+
+	mu   sync.Mutex
+	seen map[string]bool
+
+	requests           *metrics.CounterVec
+	upstreamLatency    *metrics.HistogramVec
+	timeToFirstToken   *metrics.HistogramVec
+	tokens             *metrics.CounterVec
+	inflightStreams    *metrics.GaugeVec
+	conversionFailures *metrics.CounterVec
+	downgrades         *metrics.CounterVec
+	rateLimited        *metrics.CounterVec
+}
+
+func newProxyMetrics(models []string, maxSeen int) *proxyMetrics {
+	r := metrics.NewRegistry()
+	listed := map[string]bool{}
+	for _, m := range models {
+		listed[strings.TrimSpace(m)] = true
+	}
+	return &proxyMetrics{
+		registry:           r,
+		listed:             listed,
+		maxSeen:            maxSeen,
+		seen:               map[string]bool{},
+		requests:           r.NewCounterVec("proxy_requests_total", "Requests to /v1/messages by model, response status and streaming.", "model", "status", "stream"),
+		upstreamLatency:    r.NewHistogramVec("proxy_upstream_latency_seconds", "Time until the upstream responded: headers for streams, the full body otherwise.", metrics.DefaultLatencyBuckets, "model", "stream"),
+		timeToFirstToken:   r.NewHistogramVec("proxy_time_to_first_token_seconds", "Time from sending a streaming request upstream to the first content delta.", metrics.DefaultLatencyBuckets, "model"),
+		tokens:             r.NewCounterVec("proxy_tokens_total", "Tokens reported for completed requests by model and type.", "model", "type"),
+		inflightStreams:    r.NewGaugeVec("proxy_inflight_streams", "Streaming responses currently being proxied."),
+		conversionFailures: r.NewCounterVec("proxy_conversion_failures_total", "Requests or upstream payloads that could not be converted.", "stage"),
+		downgrades:         r.NewCounterVec("proxy_downgrades_total", "Requests that had a feature downgraded for the target model.", "model"),
+		rateLimited:        r.NewCounterVec("proxy_rate_limited_total", "Requests rejected by a rate limit.", "model"),
+	}
+}
+
+// MetricsHandler serves the Prometheus metrics.
+func (s *Server) MetricsHandler() http.Handler {
+	return s.metrics.registry.Handler()
+}
+
+// model returns the model label for name.
+func (m *proxyMetrics) model(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return otherModel
+	}
+	if m.listed[name] {
+		return name
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.seen[name] {
+		if len(m.seen) >= m.maxSeen {
+			return otherModel
+		}
+		m.seen[name] = true
+	}
+	return name
+}
+
+// observeRequest counts a finished request and its tokens.
+func (m *proxyMetrics) observeRequest(rec usage.Record) {
+	model := m.model(rec.Model)
+	m.requests.Inc(model, strconv.Itoa(rec.Status), strconv.FormatBool(rec.Stream))
+	m.tokens.Add(float64(rec.InputTokens), model, "input")
+	m.tokens.Add(float64(rec.OutputTokens), model, "output")
+	m.tokens.Add(float64(rec.CacheReadTokens), model, "cache_read")
+	m.tokens.Add(float64(rec.CacheCreationTokens), model, "cache_creation")
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"claude-nvidia-proxy/internal/config"
+	"claude-nvidia-proxy/internal/mockupstream"
+)
+
+func TestMetricsModelLabel(t *testing.T) {
+	s, _ := newTestServerWith(t, func(cfg *config.ServerConfig) {
+		cfg.MetricsModels = []string{"z-ai/glm4.7"}
+		cfg.MetricsMaxModels = 1
+	}, mockupstream.Fixture{
+		Message: mockupstream.Message{Content: "ok"},
+		Usage:   &mockupstream.Usage{PromptTokens: 4, CompletionTokens: 2},
+	})
+	for _, model := range []string{"random/model-1", "z-ai/glm4.7", "random/model-2", "random/model-1", "random/model-3"} {
+		if rec := postMessages(s, `{"model":"`+model+`","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`); rec.Code != http.StatusOK {
+			t.Fatalf("%s: %d %s", model, rec.Code, rec.Body)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.MetricsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`proxy_requests_total{model="z-ai/glm4.7",status="200",stream="false"} 1`,
+		`proxy_requests_total{model="random/model-1",status="200",stream="false"} 2`,
+		`proxy_requests_total{model="other",status="200",stream="false"} 2`,
+		`proxy_tokens_total{model="other",type="input"} 8`,
+		`proxy_upstream_latency_seconds_count{model="other",stream="false"} 2`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("metrics do not contain %s", want)
+		}
+	}
+	for _, unwanted := range []string{"random/model-2", "random/model-3", "retries", "fallbacks"} {
+		if strings.Contains(body, unwanted) {
+			t.Errorf("metrics contain %q:\n%s", unwanted, body)
+		}
+	}
+}
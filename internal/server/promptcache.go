@@ -0,0 +1,37 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+
+	"claude-nvidia-proxy/internal/converter"
+)
+
+// promptCacheMaxEntries bounds the number of remembered prefixes.
+const promptCacheMaxEntries = 100000
+
+// clientKey identifies the caller by a hash of its inbound API key, so prompt
+// cache state is never shared between keys.
+func clientKey(r *http.Request) string {
+	key := inboundSecret(r)
+	if key == "" {
+		return "anonymous"
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// emulateCacheUsage fills cache_creation_input_tokens and
+// cache_read_input_tokens from the client's prefix history when the upstream
+// didn't report cached tokens.
+func (s *Server) emulateCacheUsage(reqID, client string, conv *converter.Conversion, usage map[string]any) {
+	if s.promptCache == nil || len(conv.CacheBreakpoints) == 0 {
+		return
+	}
+	read, creation := s.promptCache.Observe(client, conv.CacheBreakpoints)
+	if converter.ApplyEmulatedCacheUsage(usage, read, creation) {
+		slog.Debug("emulated prompt cache usage", "req_id", reqID, "cache_read_tokens", usage["cache_read_input_tokens"], "cache_creation_tokens", usage["cache_creation_input_tokens"])
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"claude-nvidia-proxy/internal/auth"
+	"claude-nvidia-proxy/internal/converter"
+	"claude-nvidia-proxy/internal/ratelimit"
+	"claude-nvidia-proxy/internal/types"
+)
+
+// rateLimitScopes lists the limits that apply to a request from id for
+// model. Registry keys with their own limits replace the per-key defaults.
+// Scopes without limits are omitted.
+func (s *Server) rateLimitScopes(id auth.Identity, model string) []ratelimit.Scope {
+	var scopes []ratelimit.Scope
+	keyScope, keyLimits := "key:"+id.Client, s.cfg.KeyRateLimits
+	if id.Policy != nil {
+		keyScope = "key:" + id.Name
+		if !id.Policy.RateLimits.IsZero() {
+			keyLimits = id.Policy.RateLimits
+		}
+	}
+	if !keyLimits.IsZero() {
+		scopes = append(scopes, ratelimit.Scope{Name: keyScope, Limits: keyLimits})
+	}
+	if lim := s.cfg.ModelRateLimits[model]; !lim.IsZero() {
+		scopes = append(scopes, ratelimit.Scope{Name: "model:" + model, Limits: lim})
+	}
+	return scopes
+}
+
+// admitRequest charges the request against its rate limits and sets the
+// anthropic-ratelimit-* headers. When a limit is exceeded it writes a
+// rate_limit_error and returns false. The returned lease is nil when no
+// limits apply.
+func (s *Server) admitRequest(w http.ResponseWriter, reqID string, id auth.Identity, model string, openaiReq types.OpenAIChatCompletionRequest, stream bool) (*ratelimit.Lease, bool) {
+	scopes := s.rateLimitScopes(id, model)
+	if len(scopes) == 0 {
+		return nil, true
+	}
+	lease, err := s.limiter.Acquire(scopes, estimateInputTokens(openaiReq), stream)
+	var denied *ratelimit.Denied
+	if errors.As(err, &denied) {
+		setRateLimitHeaders(w.Header(), denied.Status)
+		retryAfter := int(math.Ceil(denied.RetryAfter.Seconds()))
+		w.Header().Set("retry-after", strconv.Itoa(max(retryAfter, 1)))
+		s.metrics.rateLimited.Inc(s.metrics.model(model))
+		slog.Warn("rate limited", "req_id", reqID, "key_name", id.Name, "model", model, "err", err, "retry_after_ms", denied.RetryAfter.Milliseconds())
+		writeAnthropicError(w, http.StatusTooManyRequests, "rate_limit_error", "Rate limit exceeded: "+denied.Reason)
+		return nil, false
+	}
+	if err != nil {
+		slog.Error("rate limiter error", "req_id", reqID, "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "rate_limiter_failed")
+		return nil, false
+	}
+	setRateLimitHeaders(w.Header(), lease.Status())
+	return lease, true
+}
+
+// estimateInputTokens guesses the prompt size of openaiReq from its
+// serialized messages.
+func estimateInputTokens(openaiReq types.OpenAIChatCompletionRequest) int {
+	b, err := json.Marshal(openaiReq.Messages)
+	if err != nil {
+		return 0
+	}
+	return converter.EstimateTokens(len(b))
+}
+
+// setRateLimitHeaders reports the most constrained scope for requests and for
+// tokens.
+func setRateLimitHeaders(h http.Header, status []ratelimit.Status) {
+	var reqs, toks *ratelimit.Status
+	for i := range status {
+		st := &status[i]
+		if st.Limits.RequestsPerMinute > 0 && (reqs == nil || st.RequestsRemaining < reqs.RequestsRemaining) {
+			reqs = st
+		}
+		if st.Limits.TokensPerMinute > 0 && (toks == nil || st.TokensRemaining < toks.TokensRemaining) {
+			toks = st
+		}
+	}
+	now := time.Now().UTC()
+	if reqs != nil {
+		h.Set("anthropic-ratelimit-requests-limit", strconv.Itoa(reqs.Limits.RequestsPerMinute))
+		h.Set("anthropic-ratelimit-requests-remaining", strconv.Itoa(reqs.RequestsRemaining))
+		h.Set("anthropic-ratelimit-requests-reset", now.Add(reqs.RequestsReset).Format(time.RFC3339))
+	}
+	if toks != nil {
+		h.Set("anthropic-ratelimit-tokens-limit", strconv.Itoa(toks.Limits.TokensPerMinute))
+		h.Set("anthropic-ratelimit-tokens-remaining", strconv.Itoa(toks.TokensRemaining))
+		h.Set("anthropic-ratelimit-tokens-reset", now.Add(toks.TokensReset).Format(time.RFC3339))
+	}
+}
+
+// writeAnthropicError writes an error in the Anthropic API format, for errors
+// clients are expected to handle (such as retrying on rate_limit_error).
+func writeAnthropicError(w http.ResponseWriter, status int, errType, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"type": "error",
+		"error": map[string]any{
+			"type":    errType,
+			"message": message,
+		},
+	})
+}
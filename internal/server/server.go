@@ -1,7 +1,6 @@
 package server
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"crypto/subtle"
@@ -9,67 +8,250 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
 
+	"claude-nvidia-proxy/internal/auth"
+	"claude-nvidia-proxy/internal/cache"
+	"claude-nvidia-proxy/internal/capture"
 	"claude-nvidia-proxy/internal/config"
 	"claude-nvidia-proxy/internal/converter"
 	"claude-nvidia-proxy/internal/logging"
+	"claude-nvidia-proxy/internal/promptcache"
+	"claude-nvidia-proxy/internal/ratelimit"
+	"claude-nvidia-proxy/internal/servertools"
+	"claude-nvidia-proxy/internal/sse"
+	"claude-nvidia-proxy/internal/tracing"
 	"claude-nvidia-proxy/internal/types"
+	"claude-nvidia-proxy/internal/usage"
 )
 
-func HandleMessages(w http.ResponseWriter, r *http.Request, cfg *config.ServerConfig) {
-	reqID := fmt.Sprintf("req_%d", time.Now().UnixNano())
-	if cfg.ServerAPIKey != "" && !checkInboundAuth(r, cfg.ServerAPIKey) {
-		log.Printf("[%s] inbound unauthorized", reqID)
-		writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+// Server serves /v1/messages and holds the state shared across requests.
+type Server struct {
+	cfg         *config.ServerConfig
+	cache       cache.Store
+	promptCache *promptcache.Tracker
+	flights     *flightGroup
+	limiter     *ratelimit.Limiter
+	keys        *auth.Registry
+	usage       *usage.Store
+	toggles     toggles
+	metrics     *proxyMetrics
+	tracer      *tracing.Tracer
+	capture     *capture.Writer
+	searcher    servertools.WebSearcher
+}
+
+func New(cfg *config.ServerConfig) (*Server, error) {
+	responseCache, err := newResponseCache(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("response cache: %w", err)
+	}
+	s := &Server{cfg: cfg, cache: responseCache, limiter: ratelimit.New(), metrics: newProxyMetrics(cfg.MetricsModels, cfg.MetricsMaxModels), searcher: newWebSearcher(cfg)}
+	s.toggles.disabledModels = map[string]bool{}
+	if cfg.PromptCacheEmulation {
+		s.promptCache = promptcache.NewTracker(cfg.PromptCacheTTL, promptCacheMaxEntries)
+	}
+	if cfg.RequestDedup {
+		s.flights = newFlightGroup()
+	}
+	if cfg.KeysFile != "" {
+		if s.keys, err = auth.LoadRegistry(cfg.KeysFile); err != nil {
+			return nil, fmt.Errorf("key registry: %w", err)
+		}
+	}
+	if s.usage, err = usage.Open(cfg.UsageFile); err != nil {
+		return nil, fmt.Errorf("usage store: %w", err)
+	}
+	if cfg.CaptureDir != "" {
+		if s.capture, err = capture.Open(cfg.CaptureDir, cfg.CaptureFormat, cfg.CaptureMaxBytes, cfg.CaptureRotateBytes); err != nil {
+			return nil, fmt.Errorf("capture: %w", err)
+		}
+	}
+	if cfg.TraceEndpoint != "" {
+		s.tracer = tracing.NewTracer(cfg.TraceEndpoint, cfg.TraceServiceName, cfg.TraceHeaders)
+	}
+	return s, nil
+}
+
+func (s *Server) HandleMessages(w http.ResponseWriter, r *http.Request) {
+	cfg := s.cfg
+	start := time.Now()
+	reqID := fmt.Sprintf("req_%d", start.UnixNano())
+	ctx, span := s.tracer.Start(tracing.Extract(r.Context(), r.Header), "HandleMessages", tracing.KindServer)
+	defer span.End()
+	span.SetAttr("proxy.request_id", reqID)
+	sw := &statusRecorder{ResponseWriter: w}
+	w = sw
+	defer func() { span.SetAttr("http.response.status_code", sw.status) }()
+
+	id, ok := s.authenticate(w, r, reqID)
+	if !ok {
 		return
 	}
+	r = r.WithContext(auth.WithIdentity(ctx, id))
+	client := id.Client
+	span.SetAttr("proxy.key_name", id.Name)
+
+	rec := &usage.Record{RequestID: reqID, Key: id.Name, Provider: upstreamProvider}
+	defer s.recordUsage(rec, sw, start)
 
+	var inbound bytes.Buffer
+	body := io.Reader(r.Body)
+	if s.capture != nil {
+		body = io.TeeReader(r.Body, &inbound)
+	}
 	var anthropicReq types.AnthropicMessageRequest
-	if err := json.NewDecoder(r.Body).Decode(&anthropicReq); err != nil {
-		log.Printf("[%s] invalid inbound json: %v", reqID, err)
+	if err := json.NewDecoder(body).Decode(&anthropicReq); err != nil {
+		slog.Warn("invalid inbound json", "req_id", reqID, "err", err)
 		writeJSONError(w, http.StatusBadRequest, "invalid_json")
 		return
 	}
 	if strings.TrimSpace(anthropicReq.Model) == "" {
-		log.Printf("[%s] missing model", reqID)
+		slog.Warn("missing model", "req_id", reqID)
 		writeJSONError(w, http.StatusBadRequest, "missing_model")
 		return
 	}
+	rec.Model, rec.Stream = anthropicReq.Model, anthropicReq.Stream
+	span.SetAttr("gen_ai.request.model", anthropicReq.Model)
+	span.SetAttr("proxy.stream", anthropicReq.Stream)
+	slog.Info("inbound request", "req_id", reqID, "key_name", id.Name, "model", anthropicReq.Model, "stream", anthropicReq.Stream)
+	ex := s.startCapture(r, rec, anthropicReq, inbound.Bytes(), sw, start)
+	if ex != nil {
+		defer s.saveCapture(ex, sw)
+	}
+	if !s.authorizeModel(w, reqID, id, anthropicReq.Model) {
+		return
+	}
+	if status, errType, msg := s.toggles.unavailable(anthropicReq.Model); status != 0 {
+		slog.Warn("request rejected", "req_id", reqID, "model", anthropicReq.Model, "reason", msg)
+		writeAnthropicError(w, status, errType, msg)
+		return
+	}
 	if anthropicReq.MaxTokens == 0 {
 		anthropicReq.MaxTokens = 1024
 	}
 
-	openaiReq, err := converter.ConvertAnthropicToOpenAI(&anthropicReq)
+	conv := converter.NewConversion(cfg.CapabilitiesFor(anthropicReq.Model))
+	conv.SchemaRules = cfg.SchemaRulesFor(anthropicReq.Model)
+	conv.WebSearchEnabled = s.searcher != nil
+	_, convSpan := s.tracer.Start(r.Context(), "ConvertAnthropicToOpenAI", tracing.KindInternal)
+	openaiReq, err := converter.ConvertAnthropicToOpenAI(&anthropicReq, conv)
+	convSpan.RecordError(err)
+	convSpan.SetAttr("proxy.downgrades", len(conv.Downgrades))
+	convSpan.SetAttr("proxy.emulate_tools", conv.EmulateTools)
+	convSpan.End()
 	if err != nil {
-		log.Printf("[%s] request conversion failed: %v", reqID, err)
+		slog.Warn("request conversion failed", "req_id", reqID, "model", anthropicReq.Model, "err", err)
+		s.metrics.conversionFailures.Inc("request")
 		writeJSONError(w, http.StatusBadRequest, "request_conversion_failed")
 		return
 	}
+	if ex != nil {
+		ex.Converted, _ = json.Marshal(openaiReq)
+	}
+	if len(conv.Downgrades) > 0 {
+		s.metrics.downgrades.Inc(s.metrics.model(anthropicReq.Model))
+	}
+	for _, d := range conv.Downgrades {
+		slog.Info("downgraded for model", "req_id", reqID, "model", anthropicReq.Model, "downgrade", d)
+	}
+	if len(conv.Normalizations) > 0 {
+		slog.Info("history normalized", "req_id", reqID, "changes", strings.Join(conv.Normalizations, "; "))
+	}
+	if mapped := conv.MappedToolNames(); len(mapped) > 0 {
+		slog.Info("tool names mapped", "req_id", reqID, "names", strings.Join(mapped, ", "))
+	}
+	if n := len(conv.CacheBreakpoints); n > 0 {
+		slog.Debug("cache prefix", "req_id", reqID, "hash", conv.PrefixHash()[:16], "breakpoints", n, "prefix_tokens_estimate", conv.CacheBreakpoints[n-1].Tokens)
+	}
+	if len(conv.SchemaChanges) > 0 {
+		slog.Info("tool schemas sanitized", "req_id", reqID, "changes", strings.Join(conv.SchemaChanges, "; "))
+	}
 
 	logging.LogForwardedRequest(reqID, cfg, anthropicReq, openaiReq)
 
+	cacheKey, cacheStatus := s.responseCacheKey(r, client, anthropicReq, openaiReq)
+	cached := s.cachedResponse(reqID, cacheKey)
+	if cacheStatus != "" && cached == nil {
+		w.Header().Set(cacheStatusHeader, cacheStatus)
+	}
+
+	lease, ok := s.admitRequest(w, reqID, id, anthropicReq.Model, openaiReq, anthropicReq.Stream)
+	if !ok {
+		return
+	}
+	if lease != nil {
+		defer lease.Release()
+	}
+	charge := func(u map[string]any) { chargeUsage(lease, rec, u) }
+
+	// Cache hits count against rate limits and budgets like any other
+	// response.
+	if cached != nil {
+		charge(cachedUsage(*cached))
+		serveCachedResponse(w, reqID, *cached, anthropicReq.Stream)
+		slog.Info("response cache hit", "req_id", reqID)
+		return
+	}
+
+	if conv.HasServerTools() {
+		loopCtx, loopSpan := s.tracer.Start(r.Context(), "server_tool_loop", tracing.KindInternal)
+		anthropicResp, charged, err := s.runServerToolLoop(loopCtx, reqID, client, anthropicReq, openaiReq, conv)
+		loopSpan.RecordError(err)
+		loopSpan.End()
+		var upErr *upstreamError
+		if errors.As(err, &upErr) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(upErr.status)
+			_, _ = w.Write(upErr.body)
+			logging.LogForwardedUpstreamBody(reqID, cfg, upErr.body)
+			return
+		}
+		if err != nil {
+			slog.Error("server tool loop failed", "req_id", reqID, "model", anthropicReq.Model, "err", err)
+			writeJSONError(w, http.StatusBadGateway, "upstream_request_failed")
+			return
+		}
+		if u, ok := anthropicResp.Usage.(map[string]any); ok {
+			s.emulateCacheUsage(reqID, client, conv, u)
+		}
+		charge(charged)
+		s.storeResponse(cacheKey, anthropicResp)
+		if anthropicReq.Stream {
+			if err := writeMessageAsSSE(w, anthropicResp); err != nil {
+				slog.Warn("stream replay error", "req_id", reqID, "err", err)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(anthropicResp)
+		return
+	}
+
 	if anthropicReq.Stream {
-		if err := proxyStream(w, r, cfg, reqID, openaiReq); err != nil {
-			log.Printf("[%s] stream proxy error: %v", reqID, err)
+		if err := s.proxyStream(w, r, reqID, id, openaiReq, conv, ex, charge); err != nil {
+			slog.Error("stream proxy error", "req_id", reqID, "model", anthropicReq.Model, "err", err)
 		}
 		return
 	}
 
-	openaiRespBody, resp, err := doUpstreamJSON(r.Context(), cfg, openaiReq)
+	upstreamStart := time.Now()
+	openaiRespBody, status, shared, err := s.callUpstream(r.Context(), reqID, client, openaiReq)
 	if err != nil {
-		log.Printf("[%s] upstream request failed: %v", reqID, err)
+		slog.Error("upstream request failed", "req_id", reqID, "model", openaiReq.Model, "err", err)
 		writeJSONError(w, http.StatusBadGateway, "upstream_request_failed")
 		return
 	}
-	defer resp.Body.Close()
-	log.Printf("[%s] upstream status=%d", reqID, resp.StatusCode)
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+	slog.Info("upstream response", "req_id", reqID, "model", openaiReq.Model, "upstream_status", status, "duration_ms", time.Since(upstreamStart).Milliseconds())
+	if ex != nil {
+		ex.UpstreamStatus, ex.Upstream = status, string(openaiRespBody)
+	}
+	if status < 200 || status >= 300 {
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(resp.StatusCode)
+		w.WriteHeader(status)
 		_, _ = w.Write(openaiRespBody)
 		logging.LogForwardedUpstreamBody(reqID, cfg, openaiRespBody)
 		return
@@ -77,16 +259,42 @@ func HandleMessages(w http.ResponseWriter, r *http.Request, cfg *config.ServerCo
 
 	var openaiResp types.OpenAIChatCompletionResponse
 	if err := json.Unmarshal(openaiRespBody, &openaiResp); err != nil {
-		log.Printf("[%s] invalid upstream json: %v", reqID, err)
+		slog.Error("invalid upstream json", "req_id", reqID, "model", openaiReq.Model, "err", err)
+		s.metrics.conversionFailures.Inc("response")
 		logging.LogForwardedUpstreamBody(reqID, cfg, openaiRespBody)
 		writeJSONError(w, http.StatusBadGateway, "invalid_upstream_json")
 		return
 	}
-	anthropicResp := converter.ConvertOpenAIToAnthropic(openaiResp)
+	anthropicResp := converter.ConvertOpenAIToAnthropic(openaiResp, conv)
+	if conv.DroppedToolCalls > 0 {
+		slog.Warn("dropped extra tool calls: parallel tool use disabled", "req_id", reqID, "dropped", conv.DroppedToolCalls)
+	}
+	if u, ok := anthropicResp.Usage.(map[string]any); ok {
+		s.emulateCacheUsage(reqID, client, conv, u)
+		if shared {
+			u = nil
+		}
+		charge(u)
+	}
+	s.storeResponse(cacheKey, anthropicResp)
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(anthropicResp)
 }
 
+// callUpstream performs one non-streaming upstream call in an upstream span,
+// recording its latency. shared reports that the call was made by an
+// identical in-flight request.
+func (s *Server) callUpstream(ctx context.Context, reqID, client string, openaiReq types.OpenAIChatCompletionRequest) (body []byte, status int, shared bool, err error) {
+	start := time.Now()
+	upCtx, upSpan := s.tracer.Start(ctx, "upstream", tracing.KindClient)
+	body, status, shared, err = s.upstreamJSON(upCtx, reqID, client, openaiReq)
+	upSpan.RecordError(err)
+	upSpan.SetAttr("http.response.status_code", status)
+	upSpan.End()
+	s.metrics.upstreamLatency.Observe(time.Since(start).Seconds(), s.metrics.model(openaiReq.Model), "false")
+	return body, status, shared, err
+}
+
 func checkInboundAuth(r *http.Request, expected string) bool {
 	auth := strings.TrimSpace(r.Header.Get("Authorization"))
 	if strings.HasPrefix(strings.ToLower(auth), "bearer ") {
@@ -124,6 +332,7 @@ func doUpstreamJSON(ctx context.Context, cfg *config.ServerConfig, openaiReq typ
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+cfg.ProviderAPIKey)
+	tracing.Inject(ctx, req.Header)
 
 	client := &http.Client{Timeout: cfg.Timeout}
 	resp, err := client.Do(req)
@@ -141,61 +350,77 @@ func doUpstreamJSON(ctx context.Context, cfg *config.ServerConfig, openaiReq typ
 	return respBody, resp, nil
 }
 
-func proxyStream(w http.ResponseWriter, r *http.Request, cfg *config.ServerConfig, reqID string, openaiReq types.OpenAIChatCompletionRequest) error {
+func (s *Server) proxyStream(w http.ResponseWriter, r *http.Request, reqID string, id auth.Identity, openaiReq types.OpenAIChatCompletionRequest, conv *converter.Conversion, ex *capture.Exchange, onUsage func(map[string]any)) error {
+	cfg := s.cfg
 	openaiReq.Stream = true
-
-	bodyBytes, err := json.Marshal(openaiReq)
-	if err != nil {
-		return err
+	openaiReq.StreamOptions = &types.OpenAIStreamOptions{IncludeUsage: true}
+	if ex != nil {
+		ex.Converted, _ = json.Marshal(openaiReq)
 	}
-	upReq, err := http.NewRequestWithContext(r.Context(), http.MethodPost, cfg.UpstreamURL, bytes.NewReader(bodyBytes))
-	if err != nil {
-		return err
-	}
-	upReq.Header.Set("Content-Type", "application/json")
-	upReq.Header.Set("Authorization", "Bearer "+cfg.ProviderAPIKey)
 
-	client := &http.Client{Timeout: 0}
-	upResp, err := client.Do(upReq)
+	upstreamStart := time.Now()
+	_, streamSpan := s.tracer.Start(r.Context(), "upstream.stream", tracing.KindInternal)
+	defer streamSpan.End()
+	_, firstChunkSpan := s.tracer.Start(r.Context(), "upstream.first_chunk", tracing.KindInternal)
+	defer firstChunkSpan.End()
+	upCtx, upSpan := s.tracer.Start(r.Context(), "upstream", tracing.KindClient)
+	upstream, err := s.openStream(upCtx, reqID, id.Client, openaiReq)
+	upSpan.RecordError(err)
+	if upstream != nil {
+		upSpan.SetAttr("http.response.status_code", upstream.status)
+	}
+	upSpan.End()
+	s.metrics.upstreamLatency.Observe(time.Since(upstreamStart).Seconds(), s.metrics.model(openaiReq.Model), "true")
 	if err != nil {
 		writeJSONError(w, http.StatusBadGateway, "upstream_request_failed")
 		return err
 	}
-	defer upResp.Body.Close()
+	defer upstream.body.Close()
+	upstreamBody := io.Reader(upstream.body)
+	if ex != nil {
+		var raw bytes.Buffer
+		upstreamBody = io.TeeReader(upstream.body, &raw)
+		ex.UpstreamStatus = upstream.status
+		defer func() { ex.Upstream = raw.String() }()
+	}
 
-	log.Printf("[%s] upstream status=%d (stream)", reqID, upResp.StatusCode)
-	if upResp.StatusCode < 200 || upResp.StatusCode >= 300 {
-		raw, _ := io.ReadAll(upResp.Body)
+	slog.Info("upstream response", "req_id", reqID, "model", openaiReq.Model, "upstream_status", upstream.status, "duration_ms", time.Since(upstreamStart).Milliseconds(), "stream", true)
+	if upstream.status < 200 || upstream.status >= 300 {
+		raw, _ := io.ReadAll(upstreamBody)
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(upResp.StatusCode)
+		w.WriteHeader(upstream.status)
 		_, _ = w.Write(raw)
 		logging.LogForwardedUpstreamBody(reqID, cfg, raw)
-		return fmt.Errorf("upstream status %d", upResp.StatusCode)
+		return fmt.Errorf("upstream status %d", upstream.status)
 	}
 
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("X-Accel-Buffering", "no")
-	w.WriteHeader(http.StatusOK)
-
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		writeJSONError(w, http.StatusInternalServerError, "streaming_not_supported")
-		return errors.New("http.Flusher not supported")
-	}
-
-	encoder := func(event string, payload any) error {
-		b, err := json.Marshal(payload)
-		if err != nil {
-			return err
-		}
-		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, string(b)); err != nil {
-			return err
+	// The upstream is generating from here on. If the stream ends before
+	// its usage is charged (the client went away, the stream failed, or
+	// the upstream ignored include_usage), charge what is known so that
+	// aborting a stream does not skip the budget.
+	var upstreamUsage *types.OpenAIUsage
+	textChars, toolArgsChars := 0, 0
+	charged := false
+	defer func() {
+		switch {
+		case charged:
+		case upstream.shared:
+			onUsage(nil)
+		case upstreamUsage != nil:
+			onUsage(converter.ConvertUsage(upstreamUsage))
+		default:
+			in, out := estimateInputTokens(openaiReq), converter.EstimateTokens(textChars+toolArgsChars)
+			slog.Warn("upstream stream ended without usage, charging an estimate", "req_id", reqID, "input_tokens", in, "output_tokens", out)
+			onUsage(map[string]any{"input_tokens": in, "output_tokens": out})
 		}
-		flusher.Flush()
-		return nil
+	}()
+
+	encoder, err := beginSSE(w)
+	if err != nil {
+		return err
 	}
+	s.metrics.inflightStreams.Inc()
+	defer s.metrics.inflightStreams.Dec()
 
 	messageID := fmt.Sprintf("msg_%d", time.Now().UnixMilli())
 	_ = encoder("message_start", map[string]any{
@@ -215,25 +440,39 @@ func proxyStream(w http.ResponseWriter, r *http.Request, cfg *config.ServerConfi
 		},
 	})
 
-	reader := bufio.NewReader(upResp.Body)
+	decoder := sse.NewDecoder(upstreamBody, cfg.UpstreamMaxEventBytes)
 	chunkCount := 0
-	textChars := 0
 	toolDeltaChunks := 0
-	toolArgsChars := 0
 	var finishReason string
 	var preview strings.Builder
 	sawDone := false
+	sawFirstToken := false
+	// Native tool calls stream live while only one is in progress. When the
+	// upstream interleaves several, the others' arguments are buffered and
+	// each is emitted once the open block's arguments are complete, since an
+	// Anthropic block cannot be reopened.
 	type toolState struct {
-		contentBlockIndex int
-		id                string
-		name              string
+		index   int
+		id      string
+		name    string
+		args    strings.Builder
+		started bool
+		stopped bool
 	}
 	toolStates := map[int]*toolState{}
+	var toolOrder []*toolState
+	var liveTool *toolState
+	toolIDs := map[string]bool{}
+	droppedToolIndexes := map[int]bool{}
 
 	nextContentBlockIndex := 0
 	currentContentBlockIndex := -1
 	currentBlockType := ""
-	hasTextBlock := false
+	emulatedToolCalls := 0
+	var scanner *converter.ToolCallScanner
+	if conv.EmulateTools {
+		scanner = conv.NewToolCallScanner()
+	}
 
 	assignContentBlockIndex := func() int {
 		idx := nextContentBlockIndex
@@ -250,39 +489,203 @@ func proxyStream(w http.ResponseWriter, r *http.Request, cfg *config.ServerConfi
 			currentContentBlockIndex = -1
 			currentBlockType = ""
 		}
+		if liveTool != nil {
+			liveTool.stopped = true
+			liveTool = nil
+		}
+	}
+
+	emitToolArgs := func(args string) {
+		if args == "" {
+			return
+		}
+		_ = encoder("content_block_delta", map[string]any{
+			"type":  "content_block_delta",
+			"index": currentContentBlockIndex,
+			"delta": map[string]any{
+				"type":         "input_json_delta",
+				"partial_json": args,
+			},
+		})
+	}
+
+	// startTool opens a block for st with the arguments buffered so far. The
+	// id and name are settled here, as they may arrive after the first chunk.
+	startTool := func(st *toolState) {
+		closeCurrentBlock()
+		id := st.id
+		if id == "" || toolIDs[id] {
+			id = converter.NewToolUseID()
+		}
+		toolIDs[id] = true
+		idx := assignContentBlockIndex()
+		_ = encoder("content_block_start", map[string]any{
+			"type":  "content_block_start",
+			"index": idx,
+			"content_block": map[string]any{
+				"type":  "tool_use",
+				"id":    id,
+				"name":  conv.ResponseToolName(st.name, st.index),
+				"input": map[string]any{},
+			},
+		})
+		currentContentBlockIndex = idx
+		currentBlockType = "tool_use"
+		st.started = true
+		liveTool = st
+		emitToolArgs(st.args.String())
+	}
+
+	// advanceTools emits buffered calls while the open tool block, if any,
+	// has complete arguments, preferring calls that are complete themselves.
+	advanceTools := func() {
+		for {
+			var next *toolState
+			for _, st := range toolOrder {
+				if !st.started && (next == nil || completeToolArgs(st.args.String()) && !completeToolArgs(next.args.String())) {
+					next = st
+				}
+			}
+			if next == nil || liveTool != nil && !completeToolArgs(liveTool.args.String()) {
+				return
+			}
+			startTool(next)
+			if !completeToolArgs(next.args.String()) {
+				return
+			}
+		}
+	}
+
+	// flushTools emits every remaining call once the upstream has finished.
+	flushTools := func() {
+		for _, st := range toolOrder {
+			if !st.started {
+				startTool(st)
+			}
+		}
+	}
+
+	emitText := func(text string) {
+		if currentBlockType != "text" {
+			closeCurrentBlock()
+			idx := assignContentBlockIndex()
+			_ = encoder("content_block_start", map[string]any{
+				"type":  "content_block_start",
+				"index": idx,
+				"content_block": map[string]any{
+					"type": "text",
+					"text": "",
+				},
+			})
+			currentContentBlockIndex = idx
+			currentBlockType = "text"
+		}
+		_ = encoder("content_block_delta", map[string]any{
+			"type":  "content_block_delta",
+			"index": currentContentBlockIndex,
+			"delta": map[string]any{
+				"type": "text_delta",
+				"text": text,
+			},
+		})
+	}
+
+	emitSegments := func(segs []converter.EmulatedSegment) {
+		for _, seg := range segs {
+			if seg.Call == nil {
+				if seg.Text != "" {
+					emitText(seg.Text)
+				}
+				continue
+			}
+			if !conv.KeepToolCall(emulatedToolCalls) {
+				continue
+			}
+			emulatedToolCalls++
+			closeCurrentBlock()
+			idx := assignContentBlockIndex()
+			_ = encoder("content_block_start", map[string]any{
+				"type":  "content_block_start",
+				"index": idx,
+				"content_block": map[string]any{
+					"type":  "tool_use",
+					"id":    seg.Call.ID,
+					"name":  seg.Call.Name,
+					"input": map[string]any{},
+				},
+			})
+			_ = encoder("content_block_delta", map[string]any{
+				"type":  "content_block_delta",
+				"index": idx,
+				"delta": map[string]any{
+					"type":         "input_json_delta",
+					"partial_json": string(seg.Call.Input),
+				},
+			})
+			currentContentBlockIndex = idx
+			currentBlockType = "tool_use"
+			closeCurrentBlock()
+		}
 	}
 
 	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			if errors.Is(err, io.EOF) {
+		ev, err := decoder.Next()
+		if errors.Is(err, io.EOF) {
+			// Some upstreams close without the blank line that ends their
+			// last event; the decoder holds it back, so handle it here.
+			var ok bool
+			if ev, ok = decoder.Unterminated(); !ok {
 				break
 			}
-			return err
+			err = nil
+			s.metrics.conversionFailures.Inc("stream_unterminated")
+			slog.Warn("upstream stream ended inside an event", "req_id", reqID, "event", ev.Type)
 		}
-		line = strings.TrimRight(line, "\r\n")
-		if line == "" || strings.HasPrefix(line, ":") {
-			continue
-		}
-		if !strings.HasPrefix(line, "data:") {
-			continue
+		if err != nil {
+			// Tell the client the message is incomplete, as Anthropic does
+			// for errors after the stream has started.
+			message := "upstream stream interrupted"
+			if errors.Is(err, sse.ErrEventTooLarge) {
+				message = "upstream stream event too large"
+			}
+			_ = encoder("error", map[string]any{
+				"type":  "error",
+				"error": map[string]any{"type": "api_error", "message": message},
+			})
+			return err
 		}
-		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		data := strings.TrimSpace(ev.Data)
 		if data == "[DONE]" {
 			sawDone = true
 			break
 		}
+		if message, ok := upstreamStreamError(ev.Type, data); ok {
+			_ = encoder("error", map[string]any{
+				"type":  "error",
+				"error": map[string]any{"type": "api_error", "message": message},
+			})
+			return errors.New(message)
+		}
 
 		var chunk types.OpenAIChatCompletionChunk
 		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			s.metrics.conversionFailures.Inc("stream_chunk")
 			continue
 		}
+		if chunk.Usage != nil {
+			upstreamUsage = chunk.Usage
+		}
 		if len(chunk.Choices) == 0 {
 			continue
 		}
 
 		chunkCount++
 		delta := chunk.Choices[0].Delta
+		if !sawFirstToken && (len(delta.ToolCalls) > 0 || (delta.Content != nil && *delta.Content != "")) {
+			sawFirstToken = true
+			s.metrics.timeToFirstToken.Observe(time.Since(upstreamStart).Seconds(), s.metrics.model(openaiReq.Model))
+			firstChunkSpan.End()
+		}
 
 		if len(delta.ToolCalls) > 0 {
 			for _, tc := range delta.ToolCalls {
@@ -292,57 +695,38 @@ func proxyStream(w http.ResponseWriter, r *http.Request, cfg *config.ServerConfi
 					toolIndex = 0
 				}
 				state := toolStates[toolIndex]
-
-				tcID := strings.TrimSpace(tc.ID)
-				if tcID == "" {
-					tcID = fmt.Sprintf("call_%d_%d", time.Now().UnixMilli(), toolIndex)
-				}
-				tcName := strings.TrimSpace(tc.Function.Name)
-				if tcName == "" {
-					tcName = fmt.Sprintf("tool_%d", toolIndex)
+				if state == nil && (droppedToolIndexes[toolIndex] || !conv.KeepToolCall(len(toolStates))) {
+					droppedToolIndexes[toolIndex] = true
+					continue
 				}
 
 				if state == nil {
-					closeCurrentBlock()
-					idx := assignContentBlockIndex()
-					state = &toolState{contentBlockIndex: idx, id: tcID, name: tcName}
+					state = &toolState{index: toolIndex}
 					toolStates[toolIndex] = state
-
-					_ = encoder("content_block_start", map[string]any{
-						"type":  "content_block_start",
-						"index": idx,
-						"content_block": map[string]any{
-							"type":  "tool_use",
-							"id":    state.id,
-							"name":  state.name,
-							"input": map[string]any{},
-						},
-					})
-					currentContentBlockIndex = idx
-					currentBlockType = "tool_use"
-				} else {
-					if state.id == "" && tcID != "" {
-						state.id = tcID
-					}
-					if state.name == "" && tcName != "" {
-						state.name = tcName
-					}
-					currentContentBlockIndex = state.contentBlockIndex
-					currentBlockType = "tool_use"
+					toolOrder = append(toolOrder, state)
+				}
+				if state.id == "" {
+					state.id = strings.TrimSpace(tc.ID)
+				}
+				if state.name == "" {
+					state.name = strings.TrimSpace(tc.Function.Name)
 				}
 
 				argsPart := tc.Function.Arguments
-				if argsPart != "" {
-					toolArgsChars += len([]rune(argsPart))
-					_ = encoder("content_block_delta", map[string]any{
-						"type":  "content_block_delta",
-						"index": state.contentBlockIndex,
-						"delta": map[string]any{
-							"type":         "input_json_delta",
-							"partial_json": argsPart,
-						},
-					})
+				toolArgsChars += len([]rune(argsPart))
+				if state.stopped {
+					// Text or an emulated call closed this block; it cannot reopen.
+					if strings.TrimSpace(argsPart) != "" {
+						s.metrics.conversionFailures.Inc("stream_tool_delta")
+						slog.Warn("dropped tool call arguments after its block closed", "req_id", reqID, "tool_index", toolIndex)
+					}
+					continue
 				}
+				state.args.WriteString(argsPart)
+				if state == liveTool {
+					emitToolArgs(argsPart)
+				}
+				advanceTools()
 			}
 		}
 
@@ -351,75 +735,63 @@ func proxyStream(w http.ResponseWriter, r *http.Request, cfg *config.ServerConfi
 			if cfg.LogStreamPreviewMax > 0 && preview.Len() < cfg.LogStreamPreviewMax {
 				preview.WriteString(logging.TakeFirstRunes(*delta.Content, cfg.LogStreamPreviewMax-preview.Len()))
 			}
-			if currentBlockType != "" && currentBlockType != "text" {
-				closeCurrentBlock()
-			}
-			if !hasTextBlock {
-				hasTextBlock = true
-				idx := assignContentBlockIndex()
-				_ = encoder("content_block_start", map[string]any{
-					"type":  "content_block_start",
-					"index": idx,
-					"content_block": map[string]any{
-						"type": "text",
-						"text": "",
-					},
-				})
-				currentContentBlockIndex = idx
-				currentBlockType = "text"
+			if scanner != nil {
+				emitSegments(scanner.Feed(*delta.Content))
+			} else {
+				emitText(*delta.Content)
 			}
-			_ = encoder("content_block_delta", map[string]any{
-				"type":  "content_block_delta",
-				"index": currentContentBlockIndex,
-				"delta": map[string]any{
-					"type": "text_delta",
-					"text": *delta.Content,
-				},
-			})
 		}
 
 		if chunk.Choices[0].FinishReason != nil {
 			finishReason = *chunk.Choices[0].FinishReason
-			stopReason := converter.MapFinishReason(*chunk.Choices[0].FinishReason)
-			_ = encoder("message_delta", map[string]any{
-				"type": "message_delta",
-				"delta": map[string]any{
-					"stop_reason":   stopReason,
-					"stop_sequence": nil,
-				},
-				"usage": map[string]any{
-					"input_tokens":            0,
-					"output_tokens":           0,
-					"cache_read_input_tokens": 0,
-				},
-			})
+			flushTools()
+			if scanner != nil {
+				emitSegments(scanner.Flush())
+				if emulatedToolCalls > 0 && finishReason == "stop" {
+					finishReason = "tool_calls"
+				}
+			}
 		}
 	}
 
+	flushTools()
+	if scanner != nil {
+		emitSegments(scanner.Flush())
+	}
 	closeCurrentBlock()
 
-	if finishReason == "" {
-		_ = encoder("message_delta", map[string]any{
-			"type": "message_delta",
-			"delta": map[string]any{
-				"stop_reason":   "end_turn",
-				"stop_sequence": nil,
-			},
-			"usage": map[string]any{
-				"input_tokens":            0,
-				"output_tokens":           0,
-				"cache_read_input_tokens": 0,
-			},
-		})
+	usage := converter.ConvertUsage(upstreamUsage)
+	if upstreamUsage != nil {
+		s.emulateCacheUsage(reqID, id.Client, conv, usage)
+		if upstream.shared {
+			onUsage(nil)
+		} else {
+			onUsage(usage)
+		}
+		charged = true
 	}
+	_ = encoder("message_delta", map[string]any{
+		"type": "message_delta",
+		"delta": map[string]any{
+			"stop_reason":   converter.MapFinishReason(finishReason),
+			"stop_sequence": nil,
+		},
+		"usage": usage,
+	})
 
 	_ = encoder("message_stop", map[string]any{
 		"type": "message_stop",
 	})
+	if conv.DroppedToolCalls > 0 {
+		slog.Warn("dropped extra tool calls: parallel tool use disabled", "req_id", reqID, "dropped", conv.DroppedToolCalls)
+	}
+	streamSpan.SetAttr("proxy.chunks", chunkCount)
+	streamSpan.SetAttr("gen_ai.response.finish_reason", finishReason)
+	streamSpan.SetAttr("proxy.saw_done", sawDone)
+	attrs := []any{"req_id", reqID, "model", openaiReq.Model, "chunks", chunkCount, "text_chars", textChars, "tool_delta_chunks", toolDeltaChunks, "tool_args_chars", toolArgsChars, "finish_reason", finishReason, "saw_done", sawDone}
 	if cfg.LogStreamPreviewMax > 0 {
-		log.Printf("[%s] stream summary chunks=%d text_chars=%d tool_delta_chunks=%d tool_args_chars=%d finish_reason=%q saw_done=%v preview=%q", reqID, chunkCount, textChars, toolDeltaChunks, toolArgsChars, finishReason, sawDone, preview.String())
-	} else {
-		log.Printf("[%s] stream summary chunks=%d text_chars=%d tool_delta_chunks=%d tool_args_chars=%d finish_reason=%q saw_done=%v", reqID, chunkCount, textChars, toolDeltaChunks, toolArgsChars, finishReason, sawDone)
+		attrs = append(attrs, "preview", logging.Redact(preview.String()))
 	}
+	slog.Info("stream summary", attrs...)
 	return nil
 }
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"claude-nvidia-proxy/internal/config"
+	"claude-nvidia-proxy/internal/converter"
+	"claude-nvidia-proxy/internal/mockupstream"
+	"claude-nvidia-proxy/internal/usage"
+)
+
+// newTestServer returns a proxy whose upstream is a mock serving fixtures.
+func newTestServer(t *testing.T, fixtures ...mockupstream.Fixture) (*Server, *mockupstream.Server) {
+	t.Helper()
+	return newTestServerWith(t, nil, fixtures...)
+}
+
+// newTestServerWith is newTestServer with the config adjusted by configure.
+func newTestServerWith(t *testing.T, configure func(*config.ServerConfig), fixtures ...mockupstream.Fixture) (*Server, *mockupstream.Server) {
+	t.Helper()
+	mock := mockupstream.New(fixtures...)
+	upstream := httptest.NewServer(mock)
+	t.Cleanup(upstream.Close)
+
+	cfg := &config.ServerConfig{
+		UpstreamURL:    upstream.URL + "/v1/chat/completions",
+		ProviderAPIKey: "test-key",
+		Timeout:        10 * time.Second,
+		ResponseCache:  "off",
+	}
+	if configure != nil {
+		configure(cfg)
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, mock
+}
+
+// postMessages sends body to HandleMessages and returns the recorded response.
+func postMessages(s *Server, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	s.HandleMessages(rec, req)
+	return rec
+}
+
+func TestHandleMessagesText(t *testing.T) {
+	s, mock := newTestServer(t, mockupstream.Fixture{
+		Message: mockupstream.Message{Content: "Hi there"},
+		Usage:   &mockupstream.Usage{PromptTokens: 11, CompletionTokens: 3},
+	})
+
+	rec := postMessages(s, `{"model":"m","max_tokens":100,"messages":[{"role":"user","content":"hello"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Type       string `json:"type"`
+		Role       string `json:"role"`
+		StopReason string `json:"stop_reason"`
+		Content    []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+		Usage struct {
+			InputTokens  int `json:"input_tokens"`
+			OutputTokens int `json:"output_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Type != "message" || resp.Role != "assistant" || resp.StopReason != "end_turn" {
+		t.Errorf("type=%q role=%q stop_reason=%q", resp.Type, resp.Role, resp.StopReason)
+	}
+	if len(resp.Content) != 1 || resp.Content[0].Text != "Hi there" {
+		t.Errorf("content = %+v", resp.Content)
+	}
+	if resp.Usage.InputTokens != 11 || resp.Usage.OutputTokens != 3 {
+		t.Errorf("usage = %+v", resp.Usage)
+	}
+
+	reqs := mock.Requests()
+	if len(reqs) != 1 {
+		t.Fatalf("upstream saw %d requests, want 1", len(reqs))
+	}
+	if got := reqs[0].Header.Get("Authorization"); got != "Bearer test-key" {
+		t.Errorf("Authorization = %q", got)
+	}
+}
+
+func TestHandleMessagesStreamToolCall(t *testing.T) {
+	s, _ := newTestServer(t, mockupstream.Fixture{
+		Message: mockupstream.Message{
+			ToolCalls: []mockupstream.ToolCall{{ID: "call_1", Name: "get_weather", Arguments: `{"city":"Paris"}`}},
+		},
+		ChunkRunes: 4,
+		Usage:      &mockupstream.Usage{PromptTokens: 20, CompletionTokens: 8},
+	})
+
+	rec := postMessages(s, `{"model":"m","max_tokens":100,"stream":true,"messages":[{"role":"user","content":"weather?"}],
+		"tools":[{"name":"get_weather","input_schema":{"type":"object","properties":{"city":{"type":"string"}}}}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`"type":"tool_use"`,
+		`"id":"call_1"`,
+		`"name":"get_weather"`,
+		`"stop_reason":"tool_use"`,
+		"event: message_stop",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("stream is missing %s:\n%s", want, body)
+		}
+	}
+}
+
+func TestHandleMessagesUpstreamError(t *testing.T) {
+	s, _ := newTestServer(t, mockupstream.Fixture{Status: http.StatusServiceUnavailable})
+
+	for _, stream := range []string{"false", "true"} {
+		rec := postMessages(s, `{"model":"m","max_tokens":100,"stream":`+stream+`,"messages":[{"role":"user","content":"hello"}]}`)
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("stream=%s: status = %d, want 503", stream, rec.Code)
+		}
+	}
+}
+
+// Upstream events are decoded per the SSE spec: JSON split across data
+// lines, CR line endings, comments and named events.
+func TestHandleMessagesStreamSSEFraming(t *testing.T) {
+	body := ": keep-alive\r\r" +
+		"id: 1\rdata: {\"choices\":[{\"index\":0,\r" +
+		"data:  \"delta\":{\"content\":\"Hello\"}}]}\r\r" +
+		"event: message\ndata: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\" there\"},\"finish_reason\":\"stop\"}]}\n\n" +
+		"data: [DONE]\n\n"
+	s, _ := newTestServer(t, mockupstream.Fixture{Body: body})
+
+	rec := postMessages(s, `{"model":"m","max_tokens":10,"stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	got, errEvent := checkStream(t, parseSSE(t, rec.Body.String()))
+	if errEvent != nil {
+		t.Fatalf("unexpected error event: %v", errEvent)
+	}
+	if len(got.Blocks) != 1 || got.Blocks[0].Text != "Hello there" || got.StopReason != "end_turn" {
+		t.Errorf("message = %+v", got)
+	}
+}
+
+// cancelOnWrite cancels the request once the client has received match.
+type cancelOnWrite struct {
+	*httptest.ResponseRecorder
+	match  string
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnWrite) Write(b []byte) (int, error) {
+	n, err := c.ResponseRecorder.Write(b)
+	if strings.Contains(c.Body.String(), c.match) {
+		c.cancel()
+	}
+	return n, err
+}
+
+func TestHandleMessagesStreamUsageFallback(t *testing.T) {
+	const text = "Hello world"
+	chunk := "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"" + text + "\"}}]}\n\n"
+	const body = `{"model":"m","max_tokens":10,"stream":true,"messages":[{"role":"user","content":"hi"}]}`
+
+	usageOf := func(t *testing.T, s *Server) usage.Rollup {
+		t.Helper()
+		report := s.usage.Report(usage.Query{})
+		if len(report) != 1 || report[0].Requests != 1 {
+			t.Fatalf("usage report = %+v", report)
+		}
+		return report[0]
+	}
+
+	t.Run("upstream without usage", func(t *testing.T) {
+		s := newStreamTestServer(t, chunk+"data: {\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\ndata: [DONE]\n\n")
+		if rec := postMessages(s, body); rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+		}
+		if u := usageOf(t, s); u.InputTokens == 0 || u.OutputTokens != int64(converter.EstimateTokens(len(text))) {
+			t.Errorf("charged %+v, want the estimate", u)
+		}
+	})
+
+	t.Run("client disconnects", func(t *testing.T) {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = io.WriteString(w, chunk)
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		}))
+		t.Cleanup(upstream.Close)
+		s, _ := newTestServerWith(t, func(cfg *config.ServerConfig) {
+			cfg.UpstreamURL = upstream.URL + "/v1/chat/completions"
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)).WithContext(ctx)
+		rec := &cancelOnWrite{ResponseRecorder: httptest.NewRecorder(), match: text, cancel: cancel}
+		s.HandleMessages(rec, req)
+		if strings.Contains(rec.Body.String(), "message_stop") {
+			t.Fatal("stream completed after the client went away")
+		}
+		if u := usageOf(t, s); u.InputTokens == 0 || u.OutputTokens != int64(converter.EstimateTokens(len(text))) {
+			t.Errorf("charged %+v, want the estimate", u)
+		}
+	})
+
+	t.Run("upstream usage before the stream fails", func(t *testing.T) {
+		s := newStreamTestServer(t, chunk+"data: {\"choices\":[],\"usage\":{\"prompt_tokens\":40,\"completion_tokens\":7}}\n\nevent: error\ndata: {\"error\":{\"message\":\"boom\"}}\n\n")
+		if rec := postMessages(s, body); !strings.Contains(rec.Body.String(), "boom") {
+			t.Fatalf("body %s, want the upstream error", rec.Body)
+		}
+		if u := usageOf(t, s); u.InputTokens != 40 || u.OutputTokens != 7 {
+			t.Errorf("charged %+v, want the upstream usage", u)
+		}
+	})
+}
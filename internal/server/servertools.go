@@ -0,0 +1,231 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"claude-nvidia-proxy/internal/config"
+	"claude-nvidia-proxy/internal/converter"
+	"claude-nvidia-proxy/internal/logging"
+	"claude-nvidia-proxy/internal/servertools"
+	"claude-nvidia-proxy/internal/types"
+)
+
+// maxServerToolTurns bounds the number of upstream round trips spent on
+// server tools before the turn is handed back with stop_reason "pause_turn".
+const maxServerToolTurns = 8
+
+// defaultWebSearchMaxUses applies when a web_search tool sets no max_uses.
+const defaultWebSearchMaxUses = 5
+
+// upstreamError is a non-2xx upstream reply that is passed through verbatim.
+type upstreamError struct {
+	status int
+	body   []byte
+}
+
+func (e *upstreamError) Error() string {
+	return fmt.Sprintf("upstream status %d", e.status)
+}
+
+func newWebSearcher(cfg *config.ServerConfig) servertools.WebSearcher {
+	if cfg.WebSearchURL == "" {
+		return nil
+	}
+	return servertools.NewHTTPSearcher(cfg.WebSearchURL, cfg.WebSearchAPIKey, cfg.WebSearchMaxResults, cfg.Timeout)
+}
+
+// runServerToolLoop answers a request that declared server tools, starting
+// from its already converted first turn. Upstream calls to those tools are
+// executed locally, recorded as server_tool_use and result blocks, and fed
+// back until the model finishes or calls a client tool. charged is the usage
+// of the turns this request sent upstream itself, leaving out turns shared
+// with an identical in-flight request.
+func (s *Server) runServerToolLoop(ctx context.Context, reqID, client string, anthropicReq types.AnthropicMessageRequest, openaiReq types.OpenAIChatCompletionRequest, conv *converter.Conversion) (resp types.AnthropicMessageResponse, charged map[string]any, err error) {
+	cfg := s.cfg
+	messages := append([]types.AnthropicMsg(nil), anthropicReq.Messages...)
+	anthropicReq.Stream = false
+	openaiReq.Stream = false
+
+	var content []any
+	usage, own := map[string]int{}, map[string]int{}
+	searches := map[string]int{}
+	webSearchRequests := 0
+
+	for turn := 1; ; turn++ {
+		if turn > 1 {
+			anthropicReq.Messages = messages
+			if openaiReq, err = converter.ConvertAnthropicToOpenAI(&anthropicReq, conv); err != nil {
+				return types.AnthropicMessageResponse{}, nil, err
+			}
+		}
+
+		body, status, shared, err := s.callUpstream(ctx, reqID, client, openaiReq)
+		if err != nil {
+			return types.AnthropicMessageResponse{}, nil, err
+		}
+		slog.Info("upstream response", "req_id", reqID, "model", openaiReq.Model, "upstream_status", status, "turn", turn)
+		if status < 200 || status >= 300 {
+			return types.AnthropicMessageResponse{}, nil, &upstreamError{status: status, body: body}
+		}
+		var openaiResp types.OpenAIChatCompletionResponse
+		if err := json.Unmarshal(body, &openaiResp); err != nil {
+			logging.LogForwardedUpstreamBody(reqID, cfg, body)
+			return types.AnthropicMessageResponse{}, nil, fmt.Errorf("invalid upstream json: %w", err)
+		}
+		conv.DroppedToolCalls = 0
+		anthropicResp := converter.ConvertOpenAIToAnthropic(openaiResp, conv)
+		if conv.DroppedToolCalls > 0 {
+			slog.Warn("dropped extra tool calls: parallel tool use disabled", "req_id", reqID, "dropped", conv.DroppedToolCalls, "turn", turn)
+		}
+		if u, ok := anthropicResp.Usage.(map[string]any); ok {
+			for k, v := range u {
+				if n, ok := v.(int); ok {
+					usage[k] += n
+					if !shared {
+						own[k] += n
+					}
+				}
+			}
+		}
+
+		// history is turnBlocks as fed back upstream: search results keep
+		// their snippets there, while the client copy carries none.
+		var turnBlocks, history []any
+		executed, clientCalls := false, false
+		for _, block := range anthropicResp.Content {
+			m, ok := block.(map[string]any)
+			if !ok || m["type"] != "tool_use" {
+				turnBlocks = append(turnBlocks, block)
+				history = append(history, block)
+				continue
+			}
+			name, _ := m["name"].(string)
+			tool, ok := conv.ServerTool(name)
+			if !ok {
+				clientCalls = true
+				turnBlocks = append(turnBlocks, block)
+				history = append(history, block)
+				continue
+			}
+			executed = true
+			webSearchRequests++
+			id, _ := m["id"].(string)
+			if !strings.HasPrefix(id, "srvtoolu_") {
+				id = "srvtoolu_" + id
+			}
+			query := webSearchQuery(m["input"])
+			use := map[string]any{
+				"type":  "server_tool_use",
+				"id":    id,
+				"name":  name,
+				"input": map[string]any{"query": query},
+			}
+			results, withSnippets := executeWebSearch(ctx, reqID, s.searcher, tool, query, searches)
+			turnBlocks = append(turnBlocks, use, webSearchToolResult(id, results))
+			history = append(history, use, webSearchToolResult(id, withSnippets))
+		}
+		content = append(content, turnBlocks...)
+
+		if !executed || clientCalls || turn >= maxServerToolTurns {
+			stopReason := anthropicResp.StopReason
+			if executed && !clientCalls {
+				stopReason = "pause_turn"
+			}
+			final, led := map[string]any{}, map[string]any{}
+			for k, v := range usage {
+				final[k] = v
+			}
+			for k, v := range own {
+				led[k] = v
+			}
+			final["server_tool_use"] = map[string]any{"web_search_requests": webSearchRequests}
+			anthropicResp.Content = content
+			anthropicResp.StopReason = stopReason
+			anthropicResp.Usage = final
+			return anthropicResp, led, nil
+		}
+
+		raw, err := json.Marshal(history)
+		if err != nil {
+			return types.AnthropicMessageResponse{}, nil, err
+		}
+		messages = append(messages, types.AnthropicMsg{Role: "assistant", Content: raw})
+	}
+}
+
+func webSearchToolResult(toolUseID string, content any) map[string]any {
+	return map[string]any{
+		"type":        "web_search_tool_result",
+		"tool_use_id": toolUseID,
+		"content":     content,
+	}
+}
+
+func webSearchQuery(input any) string {
+	b, err := json.Marshal(input)
+	if err != nil {
+		return ""
+	}
+	var in struct {
+		Query string `json:"query"`
+	}
+	_ = json.Unmarshal(b, &in)
+	return strings.TrimSpace(in.Query)
+}
+
+// executeWebSearch runs one search and returns web_search_tool_result
+// content twice: for the client, and with each result's snippet in
+// encrypted_content for the follow-up upstream turn. The proxy does not
+// encrypt snippets, so the client copy leaves that field empty rather than
+// passing plaintext off as encrypted. Errors are the same object in both.
+func executeWebSearch(ctx context.Context, reqID string, searcher servertools.WebSearcher, tool types.AnthropicTool, query string, searches map[string]int) (client, upstream any) {
+	maxUses := tool.MaxUses
+	if maxUses <= 0 {
+		maxUses = defaultWebSearchMaxUses
+	}
+	searchError := func(code string) (any, any) {
+		e := map[string]any{"type": "web_search_tool_result_error", "error_code": code}
+		return e, e
+	}
+	if query == "" {
+		return searchError("invalid_tool_input")
+	}
+	if searches[tool.Name] >= maxUses {
+		return searchError("max_uses_exceeded")
+	}
+	searches[tool.Name]++
+
+	results, err := searcher.Search(ctx, query)
+	if err != nil {
+		slog.Warn("web search failed", "req_id", reqID, "err", err)
+		return searchError("unavailable")
+	}
+	results = servertools.FilterDomains(results, tool.AllowedDomains, tool.BlockedDomains)
+	slog.Info("web search", "req_id", reqID, "query", logging.Redact(query), "results", len(results))
+
+	out := make([]any, 0, len(results))
+	withSnippets := make([]any, 0, len(results))
+	for _, r := range results {
+		item := map[string]any{
+			"type":              "web_search_result",
+			"url":               r.URL,
+			"title":             r.Title,
+			"encrypted_content": "",
+		}
+		if r.PageAge != "" {
+			item["page_age"] = r.PageAge
+		}
+		out = append(out, item)
+		snippet := make(map[string]any, len(item))
+		for k, v := range item {
+			snippet[k] = v
+		}
+		snippet["encrypted_content"] = r.Content
+		withSnippets = append(withSnippets, snippet)
+	}
+	return out, withSnippets
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"claude-nvidia-proxy/internal/mockupstream"
+	"claude-nvidia-proxy/internal/servertools"
+)
+
+type fakeSearcher struct {
+	queries []string
+	results []servertools.SearchResult
+}
+
+func (f *fakeSearcher) Search(_ context.Context, query string) ([]servertools.SearchResult, error) {
+	f.queries = append(f.queries, query)
+	return f.results, nil
+}
+
+func TestHandleMessagesWebSearchLoop(t *testing.T) {
+	s, mock := newTestServer(t,
+		mockupstream.Fixture{
+			Name:    "answer",
+			Match:   mockupstream.Match{Contains: `"role":"tool"`},
+			Message: mockupstream.Message{Content: "Go 1.25 is out."},
+			Usage:   &mockupstream.Usage{PromptTokens: 30, CompletionTokens: 5},
+		},
+		mockupstream.Fixture{
+			Name:    "search",
+			Message: mockupstream.Message{ToolCalls: []mockupstream.ToolCall{{ID: "call_1", Name: "web_search", Arguments: `{"query":"go release"}`}}},
+			Usage:   &mockupstream.Usage{PromptTokens: 20, CompletionTokens: 4},
+		},
+	)
+	searcher := &fakeSearcher{results: []servertools.SearchResult{
+		{Title: "Go 1.25", URL: "https://go.dev/doc/go1.25", Content: "Go 1.25 release notes snippet"},
+		{Title: "Elsewhere", URL: "https://example.com/", Content: "blocked"},
+	}}
+	s.searcher = searcher
+
+	rec := postMessages(s, `{"model":"m","max_tokens":100,
+		"tools":[{"type":"web_search_20250305","name":"web_search","allowed_domains":["go.dev"]}],
+		"messages":[{"role":"user","content":"latest go?"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		StopReason string           `json:"stop_reason"`
+		Content    []map[string]any `json:"content"`
+		Usage      map[string]any   `json:"usage"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StopReason != "end_turn" {
+		t.Errorf("stop_reason = %q", resp.StopReason)
+	}
+	var types []string
+	for _, b := range resp.Content {
+		types = append(types, b["type"].(string))
+	}
+	if got := strings.Join(types, ","); got != "server_tool_use,web_search_tool_result,text" {
+		t.Fatalf("content types = %s", got)
+	}
+	if id := resp.Content[0]["id"]; id != "srvtoolu_call_1" || resp.Content[1]["tool_use_id"] != id {
+		t.Errorf("server_tool_use id = %v, result tool_use_id = %v", id, resp.Content[1]["tool_use_id"])
+	}
+	results, _ := resp.Content[1]["content"].([]any)
+	if len(results) != 1 {
+		t.Fatalf("results = %v, want only the allowed domain", results)
+	}
+	if r := results[0].(map[string]any); r["url"] != "https://go.dev/doc/go1.25" || r["encrypted_content"] != "" {
+		t.Errorf("result = %v, want an empty encrypted_content", r)
+	}
+	if resp.Usage["input_tokens"] != float64(50) || resp.Usage["output_tokens"] != float64(9) {
+		t.Errorf("usage = %v, want both turns summed", resp.Usage)
+	}
+	if stu, _ := resp.Usage["server_tool_use"].(map[string]any); stu["web_search_requests"] != float64(1) {
+		t.Errorf("server_tool_use = %v", resp.Usage["server_tool_use"])
+	}
+
+	if len(searcher.queries) != 1 || searcher.queries[0] != "go release" {
+		t.Errorf("queries = %q", searcher.queries)
+	}
+	reqs := mock.Requests()
+	if len(reqs) != 2 {
+		t.Fatalf("upstream requests = %d, want 2", len(reqs))
+	}
+	if reqs[0].Stream || reqs[1].Stream {
+		t.Error("server tool turns were streamed upstream")
+	}
+	if !strings.Contains(string(reqs[1].Body), "Go 1.25 release notes snippet") {
+		t.Errorf("second turn did not carry the result snippet: %s", reqs[1].Body)
+	}
+	if strings.Contains(string(reqs[1].Body), "blocked") {
+		t.Errorf("second turn carried a filtered result: %s", reqs[1].Body)
+	}
+}
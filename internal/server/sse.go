@@ -0,0 +1,166 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"claude-nvidia-proxy/internal/logging"
+	"claude-nvidia-proxy/internal/types"
+)
+
+type sseEncoder func(event string, payload any) error
+
+// beginSSE writes the event-stream response headers and returns an encoder
+// that writes and flushes one Anthropic event at a time.
+func beginSSE(w http.ResponseWriter) (sseEncoder, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, "streaming_not_supported")
+		return nil, errors.New("http.Flusher not supported")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	return func(event string, payload any) error {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, string(b)); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}, nil
+}
+
+// writeMessageAsSSE replays a complete Anthropic message as the event
+// sequence a streaming client expects.
+func writeMessageAsSSE(w http.ResponseWriter, resp types.AnthropicMessageResponse) error {
+	encoder, err := beginSSE(w)
+	if err != nil {
+		return err
+	}
+
+	usage, _ := resp.Usage.(map[string]any)
+	startUsage := map[string]any{"input_tokens": 0, "output_tokens": 0}
+	for k, v := range usage {
+		if k != "output_tokens" {
+			startUsage[k] = v
+		}
+	}
+	_ = encoder("message_start", map[string]any{
+		"type": "message_start",
+		"message": map[string]any{
+			"id":            resp.ID,
+			"type":          "message",
+			"role":          "assistant",
+			"model":         resp.Model,
+			"content":       []any{},
+			"stop_reason":   nil,
+			"stop_sequence": nil,
+			"usage":         startUsage,
+		},
+	})
+
+	for idx, block := range resp.Content {
+		m, ok := block.(map[string]any)
+		if !ok {
+			continue
+		}
+		var delta map[string]any
+		start := map[string]any{}
+		for k, v := range m {
+			start[k] = v
+		}
+		switch m["type"] {
+		case "text":
+			start["text"] = ""
+			delta = map[string]any{"type": "text_delta", "text": m["text"]}
+		case "tool_use", "server_tool_use":
+			start["input"] = map[string]any{}
+			b, err := json.Marshal(m["input"])
+			if err != nil {
+				return err
+			}
+			delta = map[string]any{"type": "input_json_delta", "partial_json": string(b)}
+		}
+		_ = encoder("content_block_start", map[string]any{
+			"type":          "content_block_start",
+			"index":         idx,
+			"content_block": start,
+		})
+		if delta != nil {
+			_ = encoder("content_block_delta", map[string]any{
+				"type":  "content_block_delta",
+				"index": idx,
+				"delta": delta,
+			})
+		}
+		_ = encoder("content_block_stop", map[string]any{
+			"type":  "content_block_stop",
+			"index": idx,
+		})
+	}
+
+	_ = encoder("message_delta", map[string]any{
+		"type": "message_delta",
+		"delta": map[string]any{
+			"stop_reason":   resp.StopReason,
+			"stop_sequence": resp.StopSequence,
+		},
+		"usage": usage,
+	})
+	return encoder("message_stop", map[string]any{
+		"type": "message_stop",
+	})
+}
+
+// upstreamStreamError reports whether an upstream stream event is an error:
+// an "error" event or a data payload carrying an "error" member, as OpenAI
+// compatible servers send when generation fails after the stream started.
+// It returns the upstream's message.
+func upstreamStreamError(eventType, data string) (string, bool) {
+	var payload struct {
+		Error   json.RawMessage `json:"error"`
+		Message string          `json:"message"`
+	}
+	parsed := json.Unmarshal([]byte(data), &payload) == nil
+	if eventType != "error" && (!parsed || len(payload.Error) == 0 || string(payload.Error) == "null") {
+		return "", false
+	}
+
+	var detail struct {
+		Message string `json:"message"`
+	}
+	var text string
+	switch {
+	case json.Unmarshal(payload.Error, &detail) == nil && detail.Message != "":
+		text = detail.Message
+	case json.Unmarshal(payload.Error, &text) == nil && text != "":
+	case payload.Message != "":
+		text = payload.Message
+	case !parsed && data != "":
+		text = data
+	default:
+		text = "unknown error"
+	}
+	return "upstream error: " + logging.TakeFirstRunes(text, maxUpstreamErrorRunes), true
+}
+
+// maxUpstreamErrorRunes caps upstream error text relayed to clients.
+const maxUpstreamErrorRunes = 500
+
+// completeToolArgs reports whether buffered tool call arguments form a
+// complete JSON object, so no later fragment can belong to them.
+func completeToolArgs(args string) bool {
+	args = strings.TrimSpace(args)
+	return strings.HasSuffix(args, "}") && json.Valid([]byte(args))
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"bytes"
+	"encoding/csv"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"claude-nvidia-proxy/internal/ratelimit"
+	"claude-nvidia-proxy/internal/usage"
+)
+
+// statusRecorder remembers the status code written to the client, and
+// copies the response body into body when it is set. It keeps http.Flusher
+// working for streaming responses.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	body   *bytes.Buffer
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	if s.body != nil {
+		s.body.Write(b)
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// chargeUsage copies an Anthropic usage object into rec and settles the
+// rate-limit lease with the actual token count. Requests served by an
+// identical in-flight request's upstream call pass nil: only the request that
+// made the call is charged, and the others' reservations are returned.
+func chargeUsage(lease *ratelimit.Lease, rec *usage.Record, u map[string]any) {
+	rec.InputTokens, _ = u["input_tokens"].(int)
+	rec.OutputTokens, _ = u["output_tokens"].(int)
+	rec.CacheReadTokens, _ = u["cache_read_input_tokens"].(int)
+	rec.CacheCreationTokens, _ = u["cache_creation_input_tokens"].(int)
+	if lease != nil {
+		lease.Settle(int(rec.Tokens()))
+	}
+}
+
+// recordUsage logs and stores rec once the response to it has been written.
+func (s *Server) recordUsage(rec *usage.Record, sw *statusRecorder, start time.Time) {
+	rec.Time = start.UTC()
+	rec.Status = sw.status
+	rec.LatencyMs = time.Since(start).Milliseconds()
+	slog.Info("request completed", "req_id", rec.RequestID, "key_name", rec.Key, "model", rec.Model, "stream", rec.Stream,
+		"status", rec.Status, "duration_ms", rec.LatencyMs, "input_tokens", rec.InputTokens, "output_tokens", rec.OutputTokens, "tokens", rec.Tokens())
+	s.metrics.observeRequest(*rec)
+	if err := s.usage.Add(*rec); err != nil {
+		slog.Error("usage record failed", "req_id", rec.RequestID, "err", err)
+	}
+}
+
+// adminUsage reports usage rollups. Query parameters: from and to
+// (YYYY-MM-DD, default the current month), group_by (comma-separated day,
+// key, model; default key), key, model and format=csv.
+func (s *Server) adminUsage(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().UTC()
+	qs := r.URL.Query()
+	q := usage.Query{
+		From:  now.Format("2006-01") + "-01",
+		To:    now.Format(time.DateOnly),
+		Key:   qs.Get("key"),
+		Model: qs.Get("model"),
+	}
+	for name, dst := range map[string]*string{"from": &q.From, "to": &q.To} {
+		if v := qs.Get(name); v != "" {
+			if _, err := time.Parse(time.DateOnly, v); err != nil {
+				writeJSONError(w, http.StatusBadRequest, "invalid_"+name)
+				return
+			}
+			*dst = v
+		}
+	}
+	groupBy := qs.Get("group_by")
+	if groupBy == "" {
+		groupBy = "key"
+	}
+	for _, g := range strings.Split(groupBy, ",") {
+		switch strings.TrimSpace(g) {
+		case "day":
+			q.ByDay = true
+		case "key":
+			q.ByKey = true
+		case "model":
+			q.ByModel = true
+		default:
+			writeJSONError(w, http.StatusBadRequest, "invalid_group_by")
+			return
+		}
+	}
+
+	report := s.usage.Report(q)
+	if qs.Get("format") != "csv" {
+		writeAdminJSON(w, http.StatusOK, map[string]any{"from": q.From, "to": q.To, "usage": report})
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="usage-`+q.From+`-`+q.To+`.csv"`)
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"day", "key", "model", "requests", "errors", "input_tokens", "output_tokens", "cache_read_tokens", "cache_creation_tokens", "avg_latency_ms"})
+	for _, row := range report {
+		avgLatency := int64(0)
+		if row.Requests > 0 {
+			avgLatency = row.LatencyMsTotal / row.Requests
+		}
+		_ = cw.Write([]string{
+			row.Day, row.Key, row.Model,
+			strconv.FormatInt(row.Requests, 10),
+			strconv.FormatInt(row.Errors, 10),
+			strconv.FormatInt(row.InputTokens, 10),
+			strconv.FormatInt(row.OutputTokens, 10),
+			strconv.FormatInt(row.CacheReadTokens, 10),
+			strconv.FormatInt(row.CacheCreationTokens, 10),
+			strconv.FormatInt(avgLatency, 10),
+		})
+	}
+	cw.Flush()
+}
@@ -0,0 +1,135 @@
+package servertools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SearchResult is one hit returned by a web search backend.
+type SearchResult struct {
+	Title   string
+	URL     string
+	Content string
+	PageAge string
+}
+
+// WebSearcher executes web_search server tool calls on behalf of the upstream
+// model.
+type WebSearcher interface {
+	Search(ctx context.Context, query string) ([]SearchResult, error)
+}
+
+// HTTPSearcher queries a SearXNG-compatible JSON search endpoint:
+// GET <URL>?q=<query>&format=json returning {"results":[{title,url,content}]}.
+type HTTPSearcher struct {
+	URL        string
+	APIKey     string
+	MaxResults int
+	Client     *http.Client
+}
+
+func NewHTTPSearcher(endpoint, apiKey string, maxResults int, timeout time.Duration) *HTTPSearcher {
+	return &HTTPSearcher{
+		URL:        endpoint,
+		APIKey:     apiKey,
+		MaxResults: maxResults,
+		Client:     &http.Client{Timeout: timeout},
+	}
+}
+
+func (s *HTTPSearcher) Search(ctx context.Context, query string) ([]SearchResult, error) {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("q", query)
+	q.Set("format", "json")
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if s.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+s.APIKey)
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("search backend status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var payload struct {
+		Results []struct {
+			Title         string `json:"title"`
+			URL           string `json:"url"`
+			Content       string `json:"content"`
+			PublishedDate string `json:"publishedDate"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return nil, fmt.Errorf("decode search response: %w", err)
+	}
+
+	out := make([]SearchResult, 0, len(payload.Results))
+	for _, r := range payload.Results {
+		if r.URL == "" {
+			continue
+		}
+		out = append(out, SearchResult{Title: r.Title, URL: r.URL, Content: r.Content, PageAge: r.PublishedDate})
+		if s.MaxResults > 0 && len(out) >= s.MaxResults {
+			break
+		}
+	}
+	return out, nil
+}
+
+// FilterDomains applies the allowed_domains / blocked_domains options of a
+// web_search tool. A domain matches its subdomains as well.
+func FilterDomains(results []SearchResult, allowed, blocked []string) []SearchResult {
+	if len(allowed) == 0 && len(blocked) == 0 {
+		return results
+	}
+	out := results[:0:0]
+	for _, r := range results {
+		u, err := url.Parse(r.URL)
+		if err != nil {
+			continue
+		}
+		host := strings.ToLower(u.Hostname())
+		if len(allowed) > 0 && !matchesAnyDomain(host, allowed) {
+			continue
+		}
+		if matchesAnyDomain(host, blocked) {
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func matchesAnyDomain(host string, domains []string) bool {
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if d == "" {
+			continue
+		}
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
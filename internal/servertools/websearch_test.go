@@ -0,0 +1,98 @@
+package servertools
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPSearcher(t *testing.T) {
+	var gotQuery, gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery, gotAuth = r.URL.RawQuery, r.Header.Get("Authorization")
+		_, _ = w.Write([]byte(`{"results":[
+			{"title":"A","url":"https://a.example/","content":"first","publishedDate":"2026-01-02"},
+			{"title":"no url"},
+			{"title":"B","url":"https://b.example/","content":"second"},
+			{"title":"C","url":"https://c.example/","content":"third"}]}`))
+	}))
+	defer srv.Close()
+
+	s := NewHTTPSearcher(srv.URL+"/search?lang=en", "secret", 2, time.Second)
+	got, err := s.Search(context.Background(), "go fuzzing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SearchResult{
+		{Title: "A", URL: "https://a.example/", Content: "first", PageAge: "2026-01-02"},
+		{Title: "B", URL: "https://b.example/", Content: "second"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("results = %+v, want %+v", got, want)
+	}
+	if gotQuery != "format=json&lang=en&q=go+fuzzing" {
+		t.Errorf("query = %q", gotQuery)
+	}
+	if gotAuth != "Bearer secret" {
+		t.Errorf("Authorization = %q", gotAuth)
+	}
+}
+
+func TestHTTPSearcherErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		status int
+		body   string
+		want   string
+	}{
+		{"status", http.StatusTooManyRequests, "slow down", "status 429: slow down"},
+		{"bad json", http.StatusOK, "<html>", "decode search response"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+			_, err := NewHTTPSearcher(srv.URL, "", 0, time.Second).Search(context.Background(), "q")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterDomains(t *testing.T) {
+	results := []SearchResult{
+		{URL: "https://go.dev/doc"},
+		{URL: "https://pkg.go.dev/fmt"},
+		{URL: "https://example.com/"},
+		{URL: "https://notgo.dev/"},
+		{URL: "://bad"},
+	}
+	tests := []struct {
+		name             string
+		allowed, blocked []string
+		want             []string
+	}{
+		{"no filters", nil, nil, []string{"https://go.dev/doc", "https://pkg.go.dev/fmt", "https://example.com/", "https://notgo.dev/", "://bad"}},
+		{"allowed matches subdomains", []string{"GO.dev"}, nil, []string{"https://go.dev/doc", "https://pkg.go.dev/fmt"}},
+		{"blocked", nil, []string{"pkg.go.dev", " "}, []string{"https://go.dev/doc", "https://example.com/", "https://notgo.dev/"}},
+		{"allowed and blocked", []string{"go.dev"}, []string{"pkg.go.dev"}, []string{"https://go.dev/doc"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, r := range FilterDomains(results, tt.allowed, tt.blocked) {
+				got = append(got, r.URL)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("urls = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
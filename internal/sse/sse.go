@@ -0,0 +1,196 @@
+// Package sse decodes text/event-stream bodies following the WHATWG HTML
+// event stream interpretation rules: CRLF, LF and CR line endings, a
+// leading BOM, comments, multi-line data and the event, id and retry
+// fields.
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMaxEventSize applies when NewDecoder is given no limit.
+const DefaultMaxEventSize = 1 << 20
+
+// ErrEventTooLarge is returned once an event, or a single line, grows past
+// the decoder's limit. The decoder cannot continue after it.
+var ErrEventTooLarge = errors.New("sse: event too large")
+
+// Event is one dispatched event. Type is "message" when the stream did not
+// name it, and ID is the last event ID seen so far in the stream.
+type Event struct {
+	Type string
+	Data string
+	ID   string
+}
+
+// Decoder reads events from a stream.
+type Decoder struct {
+	r       *bufio.Reader
+	max     int
+	started bool
+	skipLF  bool // the previous line ended with CR
+	err     error
+
+	lastID string
+	retry  time.Duration
+
+	typ  string
+	data bytes.Buffer
+	line []byte
+}
+
+// NewDecoder decodes r, failing events larger than maxEventSize bytes;
+// maxEventSize <= 0 uses DefaultMaxEventSize.
+func NewDecoder(r io.Reader, maxEventSize int) *Decoder {
+	if maxEventSize <= 0 {
+		maxEventSize = DefaultMaxEventSize
+	}
+	return &Decoder{r: bufio.NewReader(r), max: maxEventSize}
+}
+
+// Retry returns the reconnection time the stream last set with a retry
+// field, or zero.
+func (d *Decoder) Retry() time.Duration {
+	return d.retry
+}
+
+// Next returns the next event. At the end of the stream it returns io.EOF;
+// as the spec requires, an event without its terminating blank line is
+// not dispatched. Unterminated returns it instead.
+func (d *Decoder) Next() (Event, error) {
+	if d.err != nil {
+		return Event{}, d.err
+	}
+	for {
+		line, err := d.readLine()
+		if err != nil {
+			d.err = err
+			return Event{}, err
+		}
+		if len(line) == 0 {
+			if ev, ok := d.dispatch(); ok {
+				return ev, nil
+			}
+			continue
+		}
+		d.processField(line)
+		if d.data.Len()+len(d.typ) > d.max {
+			d.err = fmt.Errorf("%w: over %d bytes", ErrEventTooLarge, d.max)
+			return Event{}, d.err
+		}
+	}
+}
+
+// Unterminated returns the event that was still open when Next returned
+// io.EOF, including a last line that had no line ending. It reports false
+// before the end of the stream, when nothing was pending, or once the
+// event has been returned.
+func (d *Decoder) Unterminated() (Event, bool) {
+	if d.err != io.EOF {
+		return Event{}, false
+	}
+	if line := d.stripBOM(d.line); len(line) > 0 {
+		d.processField(line)
+	}
+	d.line = d.line[:0]
+	if d.data.Len()+len(d.typ) > d.max {
+		d.typ = ""
+		d.data.Reset()
+		return Event{}, false
+	}
+	return d.dispatch()
+}
+
+// readLine returns the next line without its terminator. The returned slice
+// is only valid until the next call.
+func (d *Decoder) readLine() ([]byte, error) {
+	d.line = d.line[:0]
+	for {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if d.skipLF {
+			d.skipLF = false
+			if b == '\n' {
+				continue
+			}
+		}
+		switch b {
+		case '\n':
+			return d.stripBOM(d.line), nil
+		case '\r':
+			// A following LF belongs to this line ending; it is skipped on
+			// the next read so a lone CR dispatches without waiting.
+			d.skipLF = true
+			return d.stripBOM(d.line), nil
+		}
+		if len(d.line) >= d.max {
+			return nil, fmt.Errorf("%w: line over %d bytes", ErrEventTooLarge, d.max)
+		}
+		d.line = append(d.line, b)
+	}
+}
+
+// stripBOM removes one U+FEFF from the start of the stream.
+func (d *Decoder) stripBOM(line []byte) []byte {
+	if !d.started {
+		d.started = true
+		line = bytes.TrimPrefix(line, []byte("\ufeff"))
+	}
+	return line
+}
+
+func (d *Decoder) processField(line []byte) {
+	if line[0] == ':' {
+		return
+	}
+	field, value := line, []byte(nil)
+	if i := bytes.IndexByte(line, ':'); i >= 0 {
+		field, value = line[:i], line[i+1:]
+		value = bytes.TrimPrefix(value, []byte(" "))
+	}
+	switch string(field) {
+	case "event":
+		d.typ = string(value)
+	case "data":
+		d.data.Write(value)
+		d.data.WriteByte('\n')
+	case "id":
+		if bytes.IndexByte(value, 0) < 0 {
+			d.lastID = string(value)
+		}
+	case "retry":
+		if ms, err := strconv.ParseUint(string(value), 10, 63); err == nil {
+			d.retry = time.Duration(ms) * time.Millisecond
+		}
+	}
+}
+
+// dispatch turns the buffered fields into an event. Events without data
+// are dropped, but their id still counts.
+func (d *Decoder) dispatch() (Event, bool) {
+	defer func() {
+		d.typ = ""
+		d.data.Reset()
+	}()
+	if d.data.Len() == 0 {
+		return Event{}, false
+	}
+	ev := Event{
+		Type: d.typ,
+		Data: strings.TrimSuffix(d.data.String(), "\n"),
+		ID:   d.lastID,
+	}
+	if ev.Type == "" {
+		ev.Type = "message"
+	}
+	return ev, true
+}
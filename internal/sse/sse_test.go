@@ -0,0 +1,213 @@
+package sse
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// decodeAll returns the events in input and the error that ended it.
+func decodeAll(r io.Reader, maxEventSize int) ([]Event, error) {
+	d := NewDecoder(r, maxEventSize)
+	var events []Event
+	for {
+		ev, err := d.Next()
+		if err != nil {
+			return events, err
+		}
+		events = append(events, ev)
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []Event
+	}{
+		{
+			name:  "openai chunks",
+			input: "data: {\"a\":1}\n\ndata: [DONE]\n\n",
+			want:  []Event{{Type: "message", Data: `{"a":1}`}, {Type: "message", Data: "[DONE]"}},
+		},
+		{
+			name:  "crlf and cr line endings",
+			input: "data: a\r\n\r\ndata: b\r\rdata: c\n\r\n",
+			want:  []Event{{Type: "message", Data: "a"}, {Type: "message", Data: "b"}, {Type: "message", Data: "c"}},
+		},
+		{
+			name:  "multi-line data",
+			input: "data: {\"a\":\ndata: 1}\ndata\ndata:\n\n",
+			want:  []Event{{Type: "message", Data: "{\"a\":\n1}\n\n"}},
+		},
+		{
+			name:  "named events",
+			input: "event: error\ndata: {\"error\":{}}\n\nevent: ping\n\ndata: x\n\n",
+			want:  []Event{{Type: "error", Data: `{"error":{}}`}, {Type: "message", Data: "x"}},
+		},
+		{
+			name:  "one leading space stripped",
+			input: "data:x\n\ndata:  y\n\n",
+			want:  []Event{{Type: "message", Data: "x"}, {Type: "message", Data: " y"}},
+		},
+		{
+			name:  "comments and unknown fields",
+			input: ": keep-alive\nfoo: bar\ndata: x\n:data: y\n\n",
+			want:  []Event{{Type: "message", Data: "x"}},
+		},
+		{
+			name:  "ids",
+			input: "id: 1\ndata: a\n\nid: 2\n\ndata: b\n\nid: 3\x00\ndata: c\n\nid\ndata: d\n\n",
+			want: []Event{
+				{Type: "message", Data: "a", ID: "1"},
+				{Type: "message", Data: "b", ID: "2"},
+				{Type: "message", Data: "c", ID: "2"},
+				{Type: "message", Data: "d", ID: ""},
+			},
+		},
+		{
+			name:  "leading bom",
+			input: "\ufeffdata: a\n\ndata: \ufeffb\n\n",
+			want:  []Event{{Type: "message", Data: "a"}, {Type: "message", Data: "\ufeffb"}},
+		},
+		{
+			name:  "unterminated event discarded",
+			input: "data: a\n\ndata: b\n",
+			want:  []Event{{Type: "message", Data: "a"}},
+		},
+	}
+	for _, tt := range tests {
+		for _, oneByte := range []bool{false, true} {
+			name := tt.name
+			if oneByte {
+				name += "/one byte reads"
+			}
+			t.Run(name, func(t *testing.T) {
+				r := io.Reader(strings.NewReader(tt.input))
+				if oneByte {
+					r = iotest.OneByteReader(r)
+				}
+				got, err := decodeAll(r, 0)
+				if err != io.EOF {
+					t.Fatalf("err = %v, want io.EOF", err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("events\n got: %q\nwant: %q", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestDecoderUnterminated(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  *Event
+	}{
+		{"terminated", "data: a\n\n", nil},
+		{"missing blank line", "data: a\n\ndata: b\n", &Event{Type: "message", Data: "b"}},
+		{"missing line ending", "data: a\n\nevent: x\ndata: b", &Event{Type: "x", Data: "b"}},
+		{"multi-line data", "data: b\ndata: c", &Event{Type: "message", Data: "b\nc"}},
+		{"only a bom", "\ufeff", nil},
+		{"bom before data", "\ufeffdata: b", &Event{Type: "message", Data: "b"}},
+		{"no data", "data: a\n\nevent: x\nid: 7", nil},
+		{"comment", "data: a\n\n: ping", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(tt.input), 0)
+			if _, ok := d.Unterminated(); ok {
+				t.Fatal("Unterminated reported an event before EOF")
+			}
+			for {
+				if _, err := d.Next(); err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+			}
+			ev, ok := d.Unterminated()
+			if ok != (tt.want != nil) || ok && ev != *tt.want {
+				t.Fatalf("Unterminated() = %q, %v, want %v", ev, ok, tt.want)
+			}
+			if _, ok := d.Unterminated(); ok {
+				t.Error("Unterminated returned the event twice")
+			}
+		})
+	}
+
+	d := NewDecoder(strings.NewReader("data: "+strings.Repeat("x", 64)), 32)
+	if _, err := d.Next(); !errors.Is(err, ErrEventTooLarge) {
+		t.Fatalf("err = %v, want ErrEventTooLarge", err)
+	}
+	if _, ok := d.Unterminated(); ok {
+		t.Error("Unterminated returned an event after ErrEventTooLarge")
+	}
+}
+
+func TestDecoderRetry(t *testing.T) {
+	d := NewDecoder(strings.NewReader("retry: 1500\n\nretry: soon\n\nretry: -1\ndata: x\n\n"), 0)
+	if _, err := d.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.Retry(); got != 1500*time.Millisecond {
+		t.Errorf("Retry() = %v, want 1.5s", got)
+	}
+}
+
+func TestDecoderMaxEventSize(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		ok    int // events decoded before the error
+	}{
+		{"long line", "data: ok\n\ndata: " + strings.Repeat("x", 64) + "\n\n", 1},
+		{"many lines", "data: ok\n\n" + strings.Repeat("data: xxxxxxxx\n", 8) + "\n", 1},
+		{"long unterminated line", strings.Repeat("y", 1000), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(tt.input), 32)
+			for i := 0; i < tt.ok; i++ {
+				if _, err := d.Next(); err != nil {
+					t.Fatalf("event %d: %v", i, err)
+				}
+			}
+			if _, err := d.Next(); !errors.Is(err, ErrEventTooLarge) {
+				t.Fatalf("err = %v, want ErrEventTooLarge", err)
+			}
+			if _, err := d.Next(); !errors.Is(err, ErrEventTooLarge) {
+				t.Errorf("decoder continued after ErrEventTooLarge: %v", err)
+			}
+		})
+	}
+}
+
+// A CR-terminated blank line must dispatch without waiting to see whether
+// an LF follows.
+func TestDecoderDoesNotWaitAfterCR(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go func() { _, _ = io.WriteString(pw, "data: a\r\r") }()
+
+	got := make(chan Event, 1)
+	go func() {
+		ev, err := NewDecoder(pr, 0).Next()
+		if err == nil {
+			got <- ev
+		}
+	}()
+	select {
+	case ev := <-got:
+		if ev.Data != "a" {
+			t.Errorf("Data = %q", ev.Data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("event was not dispatched")
+	}
+}
@@ -0,0 +1,161 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	exportQueueSize = 2048
+	exportBatchSize = 512
+	exportInterval  = 5 * time.Second
+)
+
+// exporter batches finished spans and posts them to an OTLP/HTTP endpoint.
+type exporter struct {
+	url     string
+	headers map[string]string
+	service string
+	queue   chan *Span
+	client  *http.Client
+}
+
+// NewTracer exports spans to url, the full OTLP/HTTP traces endpoint
+// (usually ending in /v1/traces).
+func NewTracer(url, serviceName string, headers map[string]string) *Tracer {
+	e := &exporter{
+		url:     url,
+		headers: headers,
+		service: serviceName,
+		queue:   make(chan *Span, exportQueueSize),
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+	go e.run()
+	return &Tracer{exp: e}
+}
+
+func (t *Tracer) export(s *Span) {
+	select {
+	case t.exp.queue <- s:
+	default:
+		// Dropping spans is better than blocking requests on a slow collector.
+	}
+}
+
+func (e *exporter) run() {
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	var batch []*Span
+	for {
+		select {
+		case s := <-e.queue:
+			batch = append(batch, s)
+			if len(batch) < exportBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := e.post(batch); err != nil {
+			slog.Warn("trace export failed", "dropped_spans", len(batch), "err", err)
+		}
+		batch = nil
+	}
+}
+
+func (e *exporter) post(batch []*Span) error {
+	spans := make([]map[string]any, 0, len(batch))
+	for _, s := range batch {
+		spans = append(spans, s.otlp())
+	}
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": []any{attribute("service.name", e.service)},
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "claude-nvidia-proxy"},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("collector status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *Span) otlp() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := map[string]any{
+		"traceId":           hex.EncodeToString(s.sc.TraceID[:]),
+		"spanId":            hex.EncodeToString(s.sc.SpanID[:]),
+		"name":              s.name,
+		"kind":              int(s.kind),
+		"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+	}
+	if s.parentID != (SpanID{}) {
+		out["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+	}
+	attrs := make([]any, 0, len(s.attrs))
+	for k, v := range s.attrs {
+		attrs = append(attrs, attribute(k, v))
+	}
+	if len(attrs) > 0 {
+		out["attributes"] = attrs
+	}
+	if s.failed {
+		out["status"] = map[string]any{"code": 2, "message": s.errMsg}
+	}
+	return out
+}
+
+func attribute(key string, v any) map[string]any {
+	var value map[string]any
+	switch x := v.(type) {
+	case string:
+		value = map[string]any{"stringValue": x}
+	case bool:
+		value = map[string]any{"boolValue": x}
+	case int:
+		value = map[string]any{"intValue": strconv.Itoa(x)}
+	case int64:
+		value = map[string]any{"intValue": strconv.FormatInt(x, 10)}
+	case float64:
+		value = map[string]any{"doubleValue": x}
+	default:
+		value = map[string]any{"stringValue": fmt.Sprint(x)}
+	}
+	return map[string]any{"key": key, "value": value}
+}
@@ -0,0 +1,188 @@
+// Package tracing creates W3C trace-context spans and exports them over
+// OTLP/HTTP as JSON.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+// SpanKind follows the OTLP enum.
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// SpanContext identifies a span across process boundaries.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) valid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent renders sc as a W3C traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+// ParseTraceparent parses a W3C traceparent header value.
+func ParseTraceparent(v string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+	var sc SpanContext
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.valid()
+}
+
+// Span is one timed operation. A nil *Span is a valid no-op.
+type Span struct {
+	tracer   *Tracer
+	name     string
+	kind     SpanKind
+	sc       SpanContext
+	parentID SpanID
+	start    time.Time
+
+	mu     sync.Mutex
+	end    time.Time
+	attrs  map[string]any
+	errMsg string
+	failed bool
+	ended  bool
+}
+
+// SetAttr records a string, bool, int or float attribute.
+func (s *Span) SetAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attrs == nil {
+		s.attrs = map[string]any{}
+	}
+	s.attrs[key] = value
+}
+
+// RecordError marks the span as failed.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed, s.errMsg = true, err.Error()
+}
+
+// End finishes the span and queues it for export. Later calls are ignored.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended, s.end = true, time.Now()
+	s.mu.Unlock()
+	if s.sc.Sampled {
+		s.tracer.export(s)
+	}
+}
+
+// Context returns the span's propagation context.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+type spanKey struct{}
+
+// FromContext returns the current span, or nil.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+type remoteKey struct{}
+
+// Extract returns ctx carrying the caller's traceparent, if it sent one.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	if sc, ok := ParseTraceparent(h.Get("traceparent")); ok {
+		return context.WithValue(ctx, remoteKey{}, sc)
+	}
+	return ctx
+}
+
+// Inject sets traceparent on h from the current span, or forwards the
+// caller's traceparent when no span is active.
+func Inject(ctx context.Context, h http.Header) {
+	if s := FromContext(ctx); s != nil {
+		h.Set("traceparent", s.sc.Traceparent())
+		return
+	}
+	if sc, ok := ctx.Value(remoteKey{}).(SpanContext); ok {
+		h.Set("traceparent", sc.Traceparent())
+	}
+}
+
+// Tracer starts spans and hands finished ones to an exporter. A nil *Tracer
+// starts no spans, but Inject still forwards an inbound traceparent.
+type Tracer struct {
+	exp *exporter
+}
+
+// Start begins a span that is a child of the current span, or of the
+// caller's traceparent for the first span of a request.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	span := &Span{tracer: t, name: name, kind: kind, start: time.Now()}
+	if parent := FromContext(ctx); parent != nil {
+		span.sc.TraceID, span.parentID, span.sc.Sampled = parent.sc.TraceID, parent.sc.SpanID, parent.sc.Sampled
+	} else if remote, ok := ctx.Value(remoteKey{}).(SpanContext); ok {
+		span.sc.TraceID, span.parentID, span.sc.Sampled = remote.TraceID, remote.SpanID, remote.Sampled
+	} else {
+		_, _ = rand.Read(span.sc.TraceID[:])
+		span.sc.Sampled = true
+	}
+	_, _ = rand.Read(span.sc.SpanID[:])
+	return context.WithValue(ctx, spanKey{}, span), span
+}
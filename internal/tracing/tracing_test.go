@@ -0,0 +1,261 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+const sampleTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		ok          bool
+		wantSampled bool
+	}{
+		{name: "sampled", value: sampleTraceparent, ok: true, wantSampled: true},
+		{name: "not sampled", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", ok: true},
+		{name: "surrounding space", value: " " + sampleTraceparent + " ", ok: true, wantSampled: true},
+		{name: "future version with extra field", value: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", ok: true, wantSampled: true},
+		{name: "empty", value: ""},
+		{name: "forbidden version", value: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{name: "short trace id", value: "00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01"},
+		{name: "short span id", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902-01"},
+		{name: "bad flags length", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1"},
+		{name: "non-hex trace id", value: "00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01"},
+		{name: "non-hex flags", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-zz"},
+		{name: "zero trace id", value: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+		{name: "zero span id", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01"},
+		{name: "missing field", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, ok := ParseTraceparent(tt.value)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if sc.Sampled != tt.wantSampled {
+				t.Errorf("sampled = %v, want %v", sc.Sampled, tt.wantSampled)
+			}
+		})
+	}
+
+	sc, _ := ParseTraceparent(sampleTraceparent)
+	if got := sc.Traceparent(); got != sampleTraceparent {
+		t.Errorf("round trip = %q, want %q", got, sampleTraceparent)
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	inbound := http.Header{"Traceparent": {sampleTraceparent}}
+	remote, _ := ParseTraceparent(sampleTraceparent)
+
+	t.Run("forwards inbound without a span", func(t *testing.T) {
+		ctx := Extract(context.Background(), inbound)
+		out := http.Header{}
+		Inject(ctx, out)
+		if got := out.Get("traceparent"); got != sampleTraceparent {
+			t.Errorf("traceparent = %q, want %q", got, sampleTraceparent)
+		}
+	})
+
+	t.Run("ignores an invalid inbound header", func(t *testing.T) {
+		ctx := Extract(context.Background(), http.Header{"Traceparent": {"garbage"}})
+		out := http.Header{}
+		Inject(ctx, out)
+		if got := out.Get("traceparent"); got != "" {
+			t.Errorf("traceparent = %q, want none", got)
+		}
+	})
+
+	t.Run("spans continue the inbound trace", func(t *testing.T) {
+		tracer := &Tracer{exp: &exporter{queue: make(chan *Span, 4)}}
+		ctx, parent := tracer.Start(Extract(context.Background(), inbound), "server", KindServer)
+		ctx, child := tracer.Start(ctx, "client", KindClient)
+
+		if parent.sc.TraceID != remote.TraceID || parent.parentID != remote.SpanID {
+			t.Errorf("parent = %+v (parent %x), want child of %+v", parent.sc, parent.parentID, remote)
+		}
+		if child.sc.TraceID != remote.TraceID || child.parentID != parent.sc.SpanID {
+			t.Errorf("child = %+v (parent %x), want child of %+v", child.sc, child.parentID, parent.sc)
+		}
+		if child.sc.SpanID == parent.sc.SpanID {
+			t.Error("child reused the parent's span id")
+		}
+		if FromContext(ctx) != child {
+			t.Error("FromContext did not return the innermost span")
+		}
+
+		out := http.Header{}
+		Inject(ctx, out)
+		if got, want := out.Get("traceparent"), child.Context().Traceparent(); got != want {
+			t.Errorf("traceparent = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("root span starts a sampled trace", func(t *testing.T) {
+		tracer := &Tracer{exp: &exporter{queue: make(chan *Span, 4)}}
+		_, span := tracer.Start(context.Background(), "root", KindServer)
+		if !span.sc.valid() || !span.sc.Sampled || span.parentID != (SpanID{}) {
+			t.Errorf("root span context = %+v, parent %x", span.sc, span.parentID)
+		}
+	})
+}
+
+func TestNilTracerAndSpan(t *testing.T) {
+	var tracer *Tracer
+	ctx := Extract(context.Background(), http.Header{"Traceparent": {sampleTraceparent}})
+	ctx, span := tracer.Start(ctx, "noop", KindInternal)
+	if span != nil {
+		t.Fatalf("nil tracer started span %+v", span)
+	}
+	span.SetAttr("k", "v")
+	span.RecordError(errors.New("boom"))
+	span.End()
+	if sc := span.Context(); sc.valid() {
+		t.Errorf("nil span context = %+v", sc)
+	}
+
+	out := http.Header{}
+	Inject(ctx, out)
+	if got := out.Get("traceparent"); got != sampleTraceparent {
+		t.Errorf("traceparent = %q, want the inbound value", got)
+	}
+}
+
+func TestSpanEnd(t *testing.T) {
+	tracer := &Tracer{exp: &exporter{queue: make(chan *Span, 4)}}
+	_, span := tracer.Start(context.Background(), "once", KindInternal)
+	span.End()
+	span.End()
+	if n := len(tracer.exp.queue); n != 1 {
+		t.Errorf("queued %d spans after two Ends, want 1", n)
+	}
+
+	unsampled, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	ctx := Extract(context.Background(), http.Header{"Traceparent": {unsampled.Traceparent()}})
+	_, span = tracer.Start(ctx, "unsampled", KindInternal)
+	span.End()
+	if n := len(tracer.exp.queue); n != 1 {
+		t.Errorf("queued %d spans after an unsampled End, want 1", n)
+	}
+}
+
+func TestExporterPost(t *testing.T) {
+	type request struct {
+		header http.Header
+		body   []byte
+	}
+	got := make(chan request, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- request{r.Header, body}
+	}))
+	defer collector.Close()
+
+	exp := &exporter{
+		url:     collector.URL,
+		headers: map[string]string{"Authorization": "Bearer secret"},
+		service: "proxy-test",
+		queue:   make(chan *Span, 4),
+		client:  collector.Client(),
+	}
+	tracer := &Tracer{exp: exp}
+	ctx, parent := tracer.Start(context.Background(), "HandleMessages", KindServer)
+	_, child := tracer.Start(ctx, "upstream", KindClient)
+	child.SetAttr("http.status_code", 502)
+	child.SetAttr("retry", true)
+	child.RecordError(errors.New("bad gateway"))
+	child.End()
+	parent.End()
+
+	if err := exp.post([]*Span{<-exp.queue, <-exp.queue}); err != nil {
+		t.Fatal(err)
+	}
+	req := <-got
+	if v := req.header.Get("Authorization"); v != "Bearer secret" {
+		t.Errorf("Authorization = %q", v)
+	}
+	if v := req.header.Get("Content-Type"); v != "application/json" {
+		t.Errorf("Content-Type = %q", v)
+	}
+
+	var payload struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []map[string]any `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID      string           `json:"traceId"`
+					SpanID       string           `json:"spanId"`
+					ParentSpanID string           `json:"parentSpanId"`
+					Name         string           `json:"name"`
+					Kind         int              `json:"kind"`
+					Attributes   []map[string]any `json:"attributes"`
+					Status       *struct {
+						Code    int    `json:"code"`
+						Message string `json:"message"`
+					} `json:"status"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal(req.body, &payload); err != nil {
+		t.Fatalf("decode %s: %v", req.body, err)
+	}
+	if len(payload.ResourceSpans) != 1 || len(payload.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("payload = %s", req.body)
+	}
+	rs := payload.ResourceSpans[0]
+	if attrs := rs.Resource.Attributes; len(attrs) != 1 || attrs[0]["key"] != "service.name" ||
+		attrs[0]["value"].(map[string]any)["stringValue"] != "proxy-test" {
+		t.Errorf("resource attributes = %v", attrs)
+	}
+	spans := rs.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("spans = %d, want 2", len(spans))
+	}
+	up, root := spans[0], spans[1]
+	if up.Name != "upstream" || up.Kind != int(KindClient) || root.Name != "HandleMessages" || root.Kind != int(KindServer) {
+		t.Errorf("spans = %+v", spans)
+	}
+	if up.TraceID != root.TraceID || up.ParentSpanID != root.SpanID || root.ParentSpanID != "" {
+		t.Errorf("parenting: upstream %+v, root %+v", up, root)
+	}
+	if up.Status == nil || up.Status.Code != 2 || up.Status.Message != "bad gateway" || root.Status != nil {
+		t.Errorf("status: upstream %+v, root %+v", up.Status, root.Status)
+	}
+	attrs := map[string]any{}
+	for _, a := range up.Attributes {
+		attrs[a["key"].(string)] = a["value"]
+	}
+	want := map[string]any{
+		"http.status_code": map[string]any{"intValue": "502"},
+		"retry":            map[string]any{"boolValue": true},
+	}
+	if !reflect.DeepEqual(attrs, want) {
+		t.Errorf("attributes = %v, want %v", attrs, want)
+	}
+}
+
+func TestExporterPostStatus(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer collector.Close()
+	exp := &exporter{url: collector.URL, client: collector.Client()}
+	if err := exp.post(nil); err == nil {
+		t.Error("post to a failing collector returned nil")
+	}
+}
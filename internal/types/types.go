@@ -2,6 +2,47 @@ package types
 
 import "encoding/json"
 
+// Model capability types
+
+// ModelCapabilities describes which request features an upstream model accepts.
+type ModelCapabilities struct {
+	Vision        bool `json:"vision"`
+	Tools         bool `json:"tools"`
+	ParallelTools bool `json:"parallel_tools"`
+	SystemRole    bool `json:"system_role"`
+	Thinking      bool `json:"thinking"`
+
+	// SystemBlocks sends the system prompt as an array of text parts and
+	// PromptCacheKey forwards the cache prefix hash as prompt_cache_key. Both
+	// are opt-in since most chat templates expect neither.
+	SystemBlocks   bool `json:"system_blocks"`
+	PromptCacheKey bool `json:"prompt_cache_key"`
+}
+
+// FullCapabilities is assumed for models without an entry in the capability
+// table. The opt-in request shapes stay disabled.
+func FullCapabilities() ModelCapabilities {
+	return ModelCapabilities{
+		Vision:        true,
+		Tools:         true,
+		ParallelTools: true,
+		SystemRole:    true,
+		Thinking:      true,
+	}
+}
+
+// SchemaRules controls how tool input schemas are rewritten before they are
+// forwarded to the upstream provider. MaxDepth 0 leaves nesting unbounded.
+type SchemaRules struct {
+	Enabled                         bool     `json:"enabled"`
+	StripKeywords                   []string `json:"strip_keywords,omitempty"`
+	StripFormats                    []string `json:"strip_formats,omitempty"`
+	StripNestedAdditionalProperties bool     `json:"strip_nested_additional_properties"`
+	CollapseNullable                bool     `json:"collapse_nullable"`
+	ResolveRefs                     bool     `json:"resolve_refs"`
+	MaxDepth                        int      `json:"max_depth,omitempty"`
+}
+
 // Anthropic request types
 
 type AnthropicMessageRequest struct {
@@ -21,10 +62,31 @@ type AnthropicMsg struct {
 	Content json.RawMessage `json:"content"`
 }
 
+// AnthropicCacheControl marks the end of a cacheable prompt prefix.
+type AnthropicCacheControl struct {
+	Type string `json:"type"`
+	TTL  string `json:"ttl,omitempty"`
+}
+
 type AnthropicTool struct {
+	// Type is empty or "custom" for client tools; Anthropic-defined tools
+	// carry a versioned type such as "bash_20250124" or "web_search_20250305".
+	Type        string          `json:"type,omitempty"`
 	Name        string          `json:"name"`
 	Description string          `json:"description,omitempty"`
 	InputSchema json.RawMessage `json:"input_schema,omitempty"`
+
+	// computer_*
+	DisplayWidthPx  int `json:"display_width_px,omitempty"`
+	DisplayHeightPx int `json:"display_height_px,omitempty"`
+	DisplayNumber   int `json:"display_number,omitempty"`
+
+	// web_search_*
+	MaxUses        int      `json:"max_uses,omitempty"`
+	AllowedDomains []string `json:"allowed_domains,omitempty"`
+	BlockedDomains []string `json:"blocked_domains,omitempty"`
+
+	CacheControl *AnthropicCacheControl `json:"cache_control,omitempty"`
 }
 
 type AnthropicContentBlock struct {
@@ -44,6 +106,8 @@ type AnthropicContentBlock struct {
 	// tool_result
 	ToolUseID string          `json:"tool_use_id,omitempty"`
 	Content   json.RawMessage `json:"content,omitempty"`
+
+	CacheControl *AnthropicCacheControl `json:"cache_control,omitempty"`
 }
 
 type AnthropicImageSource struct {
@@ -69,13 +133,21 @@ type AnthropicMessageResponse struct {
 // OpenAI request types
 
 type OpenAIChatCompletionRequest struct {
-	Model       string `json:"model"`
-	Messages    []any  `json:"messages"`
-	MaxTokens   int    `json:"max_tokens,omitempty"`
-	Temperature any    `json:"temperature,omitempty"`
-	Stream      bool   `json:"stream,omitempty"`
-	Tools       []any  `json:"tools,omitempty"`
-	ToolChoice  any    `json:"tool_choice,omitempty"`
+	Model             string `json:"model"`
+	Messages          []any  `json:"messages"`
+	MaxTokens         int    `json:"max_tokens,omitempty"`
+	Temperature       any    `json:"temperature,omitempty"`
+	Stream            bool   `json:"stream,omitempty"`
+	Tools             []any  `json:"tools,omitempty"`
+	ToolChoice        any    `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool  `json:"parallel_tool_calls,omitempty"`
+	PromptCacheKey    string `json:"prompt_cache_key,omitempty"`
+
+	StreamOptions *OpenAIStreamOptions `json:"stream_options,omitempty"`
+}
+
+type OpenAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 // OpenAI response types
@@ -98,13 +170,15 @@ type OpenAIChatCompletionResponse struct {
 		} `json:"message"`
 		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
-	Usage *struct {
-		PromptTokens        int `json:"prompt_tokens"`
-		CompletionTokens    int `json:"completion_tokens"`
-		PromptTokensDetails *struct {
-			CachedTokens int `json:"cached_tokens"`
-		} `json:"prompt_tokens_details,omitempty"`
-	} `json:"usage,omitempty"`
+	Usage *OpenAIUsage `json:"usage,omitempty"`
+}
+
+type OpenAIUsage struct {
+	PromptTokens        int `json:"prompt_tokens"`
+	CompletionTokens    int `json:"completion_tokens"`
+	PromptTokensDetails *struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"prompt_tokens_details,omitempty"`
 }
 
 // Streaming chunk types
@@ -126,4 +200,5 @@ type OpenAIChatCompletionChunk struct {
 		} `json:"delta"`
 		FinishReason *string `json:"finish_reason,omitempty"`
 	} `json:"choices"`
+	Usage *OpenAIUsage `json:"usage,omitempty"`
 }
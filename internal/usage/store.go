@@ -0,0 +1,233 @@
+// Package usage records per-request token usage and keeps daily rollups for
+// budgets and reports.
+package usage
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Record is one finished request.
+type Record struct {
+	Time                time.Time `json:"time"`
+	RequestID           string    `json:"request_id"`
+	Key                 string    `json:"key"`
+	Model               string    `json:"model"`
+	Provider            string    `json:"provider"`
+	Stream              bool      `json:"stream"`
+	Status              int       `json:"status"`
+	InputTokens         int       `json:"input_tokens"`
+	OutputTokens        int       `json:"output_tokens"`
+	CacheReadTokens     int       `json:"cache_read_tokens"`
+	CacheCreationTokens int       `json:"cache_creation_tokens"`
+	LatencyMs           int64     `json:"latency_ms"`
+}
+
+// Tokens is the total counted against budgets.
+func (r Record) Tokens() int64 {
+	return int64(r.InputTokens + r.OutputTokens + r.CacheReadTokens + r.CacheCreationTokens)
+}
+
+// Rollup aggregates records. Day, Key and Model are empty when a report is
+// not grouped by them.
+type Rollup struct {
+	Day                 string `json:"day,omitempty"`
+	Key                 string `json:"key,omitempty"`
+	Model               string `json:"model,omitempty"`
+	Requests            int64  `json:"requests"`
+	Errors              int64  `json:"errors"`
+	InputTokens         int64  `json:"input_tokens"`
+	OutputTokens        int64  `json:"output_tokens"`
+	CacheReadTokens     int64  `json:"cache_read_tokens"`
+	CacheCreationTokens int64  `json:"cache_creation_tokens"`
+	LatencyMsTotal      int64  `json:"latency_ms_total"`
+}
+
+func (r *Rollup) add(rec Record) {
+	r.Requests++
+	if rec.Status < 200 || rec.Status >= 300 {
+		r.Errors++
+	}
+	r.InputTokens += int64(rec.InputTokens)
+	r.OutputTokens += int64(rec.OutputTokens)
+	r.CacheReadTokens += int64(rec.CacheReadTokens)
+	r.CacheCreationTokens += int64(rec.CacheCreationTokens)
+	r.LatencyMsTotal += rec.LatencyMs
+}
+
+func (r *Rollup) merge(o *Rollup) {
+	r.Requests += o.Requests
+	r.Errors += o.Errors
+	r.InputTokens += o.InputTokens
+	r.OutputTokens += o.OutputTokens
+	r.CacheReadTokens += o.CacheReadTokens
+	r.CacheCreationTokens += o.CacheCreationTokens
+	r.LatencyMsTotal += o.LatencyMsTotal
+}
+
+// Tokens is the rollup's total token count.
+func (r *Rollup) Tokens() int64 {
+	return r.InputTokens + r.OutputTokens + r.CacheReadTokens + r.CacheCreationTokens
+}
+
+type rollupKey struct {
+	day, key, model string
+}
+
+// Store appends records to a JSONL file and keeps day/key/model rollups in
+// memory. The rollups are rebuilt from the file on startup.
+type Store struct {
+	mu      sync.Mutex
+	f       *os.File
+	rollups map[rollupKey]*Rollup
+}
+
+// Open loads and appends to the JSONL file at path. An empty path keeps
+// usage in memory only.
+func Open(path string) (*Store, error) {
+	s := &Store{rollups: map[rollupKey]*Rollup{}}
+	if path == "" {
+		return s, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		var rec Record
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			// A torn last line from a crash shouldn't block startup.
+			continue
+		}
+		s.rollup(rec)
+	}
+	if err := sc.Err(); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	// End a torn last line so the next record starts on a line of its own.
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			if _, err := f.Write([]byte{'\n'}); err != nil {
+				_ = f.Close()
+				return nil, fmt.Errorf("write %s: %w", path, err)
+			}
+		}
+	}
+	s.f = f
+	return s, nil
+}
+
+func (s *Store) rollup(rec Record) {
+	k := rollupKey{day: rec.Time.UTC().Format(time.DateOnly), key: rec.Key, model: rec.Model}
+	r := s.rollups[k]
+	if r == nil {
+		r = &Rollup{Day: k.day, Key: k.key, Model: k.model}
+		s.rollups[k] = r
+	}
+	r.add(rec)
+}
+
+// Add records rec.
+func (s *Store) Add(rec Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rollup(rec)
+	if s.f == nil {
+		return nil
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = s.f.Write(append(b, '\n'))
+	return err
+}
+
+// Used returns the tokens key has used on days from since (inclusive).
+func (s *Store) Used(key string, since time.Time) int64 {
+	from := since.UTC().Format(time.DateOnly)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var total int64
+	for k, r := range s.rollups {
+		if k.key == key && k.day >= from {
+			total += r.Tokens()
+		}
+	}
+	return total
+}
+
+// Query selects rollups for a report. From and To are inclusive
+// YYYY-MM-DD days; empty means unbounded.
+type Query struct {
+	From, To              string
+	ByDay, ByKey, ByModel bool
+	Key, Model            string
+}
+
+// Report aggregates the rollups matching q, sorted by day, key and model.
+func (s *Store) Report(q Query) []Rollup {
+	s.mu.Lock()
+	grouped := map[rollupKey]*Rollup{}
+	for k, r := range s.rollups {
+		if (q.From != "" && k.day < q.From) || (q.To != "" && k.day > q.To) ||
+			(q.Key != "" && k.key != q.Key) || (q.Model != "" && k.model != q.Model) {
+			continue
+		}
+		var g rollupKey
+		if q.ByDay {
+			g.day = k.day
+		}
+		if q.ByKey {
+			g.key = k.key
+		}
+		if q.ByModel {
+			g.model = k.model
+		}
+		out := grouped[g]
+		if out == nil {
+			out = &Rollup{Day: g.day, Key: g.key, Model: g.model}
+			grouped[g] = out
+		}
+		out.merge(r)
+	}
+	s.mu.Unlock()
+
+	report := make([]Rollup, 0, len(grouped))
+	for _, r := range grouped {
+		report = append(report, *r)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		a, b := report[i], report[j]
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		return a.Model < b.Model
+	})
+	return report
+}
+
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	return s.f.Close()
+}
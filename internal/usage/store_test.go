@@ -0,0 +1,122 @@
+package usage
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var (
+	day1 = time.Date(2026, 3, 1, 23, 30, 0, 0, time.UTC)
+	day2 = time.Date(2026, 3, 2, 1, 0, 0, 0, time.UTC)
+)
+
+func testRecords() []Record {
+	return []Record{
+		{Time: day1, Key: "alice", Model: "a/m", Status: 200, InputTokens: 100, OutputTokens: 10, LatencyMs: 50},
+		{Time: day1, Key: "alice", Model: "b/m", Status: 429, LatencyMs: 1},
+		{Time: day2, Key: "alice", Model: "a/m", Status: 200, InputTokens: 20, OutputTokens: 5, CacheReadTokens: 80, LatencyMs: 40},
+		{Time: day2.In(time.FixedZone("UTC-5", -5*3600)), Key: "bob", Model: "a/m", Status: 200, InputTokens: 7, CacheCreationTokens: 3},
+	}
+}
+
+func TestStoreUsed(t *testing.T) {
+	s, err := Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range testRecords() {
+		if err := s.Add(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		key   string
+		since time.Time
+		want  int64
+	}{
+		{"alice", day1, 215},
+		{"alice", day2, 105},
+		{"alice", day2.Add(24 * time.Hour), 0},
+		{"bob", day1, 10},
+		{"carol", day1, 0},
+	}
+	for _, tt := range tests {
+		if got := s.Used(tt.key, tt.since); got != tt.want {
+			t.Errorf("Used(%s, %s) = %d, want %d", tt.key, tt.since.Format(time.DateOnly), got, tt.want)
+		}
+	}
+}
+
+func TestStoreReport(t *testing.T) {
+	s, _ := Open("")
+	for _, rec := range testRecords() {
+		_ = s.Add(rec)
+	}
+	tests := []struct {
+		name string
+		q    Query
+		want []Rollup
+	}{
+		{"by key", Query{ByKey: true}, []Rollup{
+			{Key: "alice", Requests: 3, Errors: 1, InputTokens: 120, OutputTokens: 15, CacheReadTokens: 80, LatencyMsTotal: 91},
+			{Key: "bob", Requests: 1, InputTokens: 7, CacheCreationTokens: 3},
+		}},
+		{"by day and model for one key", Query{ByDay: true, ByModel: true, Key: "alice"}, []Rollup{
+			{Day: "2026-03-01", Model: "a/m", Requests: 1, InputTokens: 100, OutputTokens: 10, LatencyMsTotal: 50},
+			{Day: "2026-03-01", Model: "b/m", Requests: 1, Errors: 1, LatencyMsTotal: 1},
+			{Day: "2026-03-02", Model: "a/m", Requests: 1, InputTokens: 20, OutputTokens: 5, CacheReadTokens: 80, LatencyMsTotal: 40},
+		}},
+		{"date range and model", Query{From: "2026-03-02", To: "2026-03-02", Model: "a/m"}, []Rollup{
+			{Requests: 2, InputTokens: 27, OutputTokens: 5, CacheReadTokens: 80, CacheCreationTokens: 3, LatencyMsTotal: 40},
+		}},
+		{"nothing matches", Query{From: "2026-04-01"}, []Rollup{}},
+	}
+	for _, tt := range tests {
+		if got := s.Report(tt.q); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\n got %+v\nwant %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Rollups are rebuilt from the file, skipping a torn last line.
+func TestStoreReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage", "usage.jsonl")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recs := testRecords()
+	for _, rec := range recs[:3] {
+		_ = s.Add(rec)
+	}
+	_ = s.Close()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString(`{"time":"2026-03-02T`)
+	_ = f.Close()
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Used("alice", day1); got != 215 {
+		t.Errorf("reopened Used = %d, want 215", got)
+	}
+	_ = s.Add(recs[3])
+	_ = s.Close()
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got := s.Used("bob", day1); got != 10 {
+		t.Errorf("record written after a torn line: Used = %d, want 10", got)
+	}
+}